@@ -0,0 +1,27 @@
+package client
+
+import "net/http"
+
+// Auth authenticates the requests of a Client.
+type Auth interface {
+	Authenticate(req *http.Request) error
+}
+
+// BasicAuth sends the credentials of a user of the API.
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a BasicAuth) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// AuthFunc makes a function an Auth, for credentials kept elsewhere such as
+// a token a proxy in front of the API checks.
+type AuthFunc func(req *http.Request) error
+
+func (f AuthFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
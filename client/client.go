@@ -0,0 +1,205 @@
+// Package client is a Go SDK of the expenses API.
+//
+//	c := client.New("http://localhost:2565/v1", client.BasicAuth{Username: "admin", Password: "admin"})
+//	ex, err := c.GetExpense(ctx, 1)
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+//
+// Calls that are safe to repeat are retried with backoff on network errors,
+// 429 and 5xx responses, and every call stops when its context is done.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API at BaseURL, such as http://localhost:2565/v1.
+type Client struct {
+	BaseURL string
+	// Auth authenticates every request, none is sent when nil.
+	Auth Auth
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	Retry      Retry
+}
+
+func New(baseURL string, auth Auth) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Auth: auth, Retry: DefaultRetry}
+}
+
+// Retry is how a call that is safe to repeat is retried. The wait before
+// the first retry is Base and doubles every retry up to MaxWait, less a
+// random part of it so clients don't retry in step. A Retry-After the
+// server answers with is waited for instead.
+type Retry struct {
+	// Max is how many times a call is retried, 0 never retries.
+	Max     int
+	Base    time.Duration
+	MaxWait time.Duration
+}
+
+var DefaultRetry = Retry{Max: 3, Base: 200 * time.Millisecond, MaxWait: 5 * time.Second}
+
+func (r Retry) wait(attempt int) time.Duration {
+	d := r.Base
+	for i := 0; i < attempt && d < r.MaxWait; i++ {
+		d *= 2
+	}
+	if d > r.MaxWait {
+		d = r.MaxWait
+	}
+	return d/2 + jitter(d/2)
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(mrand.Int63n(int64(d)))
+}
+
+// HeaderIdempotencyKey makes the server answer a repeated POST with the
+// response of the first one, which makes creating retryable.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// headerIdempotentReplayed marks a response replayed for an Idempotency-Key.
+const headerIdempotentReplayed = "Idempotent-Replayed"
+
+func idempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// request is one call of the API.
+type request struct {
+	method string
+	// url is BaseURL with path and query, or a link the API answered with.
+	url        string
+	header     http.Header
+	body       []byte
+	idempotent bool
+}
+
+func (c *Client) newRequest(method, path string, query url.Values, in interface{}) (*request, error) {
+	r := &request{method: method, url: c.BaseURL + path, header: http.Header{}}
+	if len(query) > 0 {
+		r.url += "?" + query.Encode()
+	}
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		r.body = b
+		r.header.Set("Content-Type", "application/json")
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		r.idempotent = true
+	case http.MethodPost:
+		r.header.Set(HeaderIdempotencyKey, idempotencyKey())
+		r.idempotent = true
+	}
+	return r, nil
+}
+
+// do sends r and decodes the JSON response into out, retrying r when it is
+// idempotent. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, r *request, out interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, r, out)
+		if err == nil || !r.idempotent || attempt >= c.Retry.Max || !retryable(ctx, r, err) {
+			return res, err
+		}
+
+		wait := c.Retry.wait(attempt)
+		if e, ok := err.(*Error); ok && e.RetryAfter > 0 {
+			wait = e.RetryAfter
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, r *request, out interface{}) (*http.Response, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Auth != nil {
+		if err := c.Auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return res, responseError(res)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return res, nil
+	}
+	return res, json.NewDecoder(res.Body).Decode(out)
+}
+
+// retryable tells whether err may go away when r is repeated. A 409 to a
+// request with an Idempotency-Key that wasn't replayed means the first
+// request with the key is still running.
+func retryable(ctx context.Context, r *request, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if e, ok := err.(*Error); ok {
+		if e.Status == http.StatusConflict {
+			return r.header.Get(HeaderIdempotencyKey) != "" && !e.Replayed
+		}
+		return e.Status == http.StatusTooManyRequests || (e.Status >= http.StatusInternalServerError && e.Status != http.StatusNotImplemented)
+	}
+	// the request didn't make it to the server or its response back
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+func retryAfter(res *http.Response) time.Duration {
+	s, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || s < 0 {
+		return 0
+	}
+	return time.Duration(s) * time.Second
+}
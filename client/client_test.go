@@ -0,0 +1,257 @@
+//go:build unit
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRetry = Retry{Max: 2, Base: time.Millisecond, MaxWait: time.Millisecond}
+
+func TestListExpensesPages(t *testing.T) {
+	//arrange
+	got := []*http.Request{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("after") == "" {
+			w.Header().Add("Link", `</v1/expenses?after=1&limit=1&tag=travel>; rel="next"`)
+			w.Write([]byte(`[{"id":1,"title":"taxi","amount":250,"tags":["travel"],"date":"2022-12-24"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id":2,"title":"train","amount":90,"tags":["travel"],"date":"2022-12-25"}]`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL+"/v1/", BasicAuth{Username: "admin", Password: "admin"})
+
+	//action
+	pages := c.ListExpenses(context.Background(), Filter{Tag: "travel", Meta: map[string]string{"project": "apollo"}, PageSize: 1})
+	titles := []string{}
+	for pages.Next() {
+		for _, ex := range pages.Page() {
+			titles = append(titles, ex.Title)
+		}
+	}
+
+	//assert
+	assert.Nil(t, pages.Err())
+	assert.Equal(t, []string{"taxi", "train"}, titles)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, "/v1/expenses", got[0].URL.Path)
+		assert.Equal(t, "limit=1&meta.project=apollo&tag=travel", got[0].URL.RawQuery)
+		assert.Equal(t, "after=1&limit=1&tag=travel", got[1].URL.RawQuery)
+		username, password, ok := got[1].BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", username)
+		assert.Equal(t, "admin", password)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	//arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"title error : this field should not empty.","request_id":"abc",
+			"message":"title error : this field should not empty.","errors":[{"field":"title","code":"required","message":"title error : this field should not empty."}]}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+
+	//action
+	_, err := c.UpdateExpense(context.Background(), 1, expense.Expense{Amount: 10})
+
+	//assert
+	assert.True(t, errors.Is(err, ErrBadRequest))
+	assert.False(t, errors.Is(err, ErrNotFound))
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusBadRequest, e.Status)
+		assert.Equal(t, "abc", e.RequestId)
+		assert.Equal(t, expense.CodeRequired, e.Field("title").Code)
+		assert.Nil(t, e.Field("amount"))
+	}
+	assert.EqualError(t, err, "400 Bad Request: title error : this field should not empty.")
+}
+
+func TestUpdateExpenseSendsGroup(t *testing.T) {
+	//arrange
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+
+	//action
+	_, err := c.UpdateExpense(context.Background(), 1, expense.Expense{Title: "rent", Amount: 12000})
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), body["group_id"])
+}
+
+func TestCreateExpenseRetries(t *testing.T) {
+	//arrange
+	keys := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(HeaderIdempotencyKey))
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7,"title":"taxi","amount":250,"tags":["travel"]}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, AuthFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer token")
+		return nil
+	}))
+	c.Retry = testRetry
+
+	//action
+	ex, err := c.CreateExpense(context.Background(), expense.Expense{Title: "taxi", Amount: 250, Tags: []string{"travel"}})
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, 7, ex.Id)
+	if assert.Len(t, keys, 3) {
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[1])
+		assert.Equal(t, keys[0], keys[2])
+	}
+}
+
+func TestCreateExpenseRetriesInProgress(t *testing.T) {
+	//arrange
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7,"title":"taxi","amount":250}`))
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+	c.Retry = testRetry
+
+	//action
+	ex, err := c.CreateExpense(context.Background(), expense.Expense{Title: "taxi", Amount: 250})
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, 7, ex.Id)
+	assert.Equal(t, 2, calls)
+}
+
+func TestNoRetryOnConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		replayed bool
+		call     func(c *Client) error
+	}{
+		{"request without an Idempotency-Key", false, func(c *Client) error {
+			_, err := c.UpdateExpense(context.Background(), 1, expense.Expense{Title: "taxi", Amount: 250})
+			return err
+		}},
+		{"replayed response", true, func(c *Client) error {
+			_, err := c.CreateExpense(context.Background(), expense.Expense{Title: "taxi", Amount: 250})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if tt.replayed {
+					w.Header().Set("Idempotent-Replayed", "true")
+				}
+				w.WriteHeader(http.StatusConflict)
+			}))
+			defer srv.Close()
+			c := New(srv.URL, nil)
+			c.Retry = testRetry
+
+			err := tt.call(c)
+
+			assert.True(t, errors.Is(err, ErrConflict))
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	//arrange
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+	c.Retry = testRetry
+
+	//action
+	_, err := c.GetExpense(context.Background(), 1)
+
+	//assert
+	assert.True(t, errors.Is(err, ErrServer))
+	assert.Equal(t, 3, calls)
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+	c.Retry = testRetry
+
+	err := c.DeleteExpense(context.Background(), 1)
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 1, calls)
+}
+
+func TestContextCancelStopsRetries(t *testing.T) {
+	//arrange
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c := New(srv.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	//action
+	start := time.Now()
+	_, err := c.GetExpense(ctx, 1)
+
+	//assert
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 1, calls)
+}
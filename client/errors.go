@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+// The kinds of error responses, an *Error is one of them for errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnprocessableEntity: ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusPreconditionFailed:  ErrConflict,
+	http.StatusTooManyRequests:     ErrRateLimited,
+}
+
+// Error is an error response of the API, the expense.Err of the handler
+// that answered. Errors lists the fields that failed validation.
+type Error struct {
+	Status    int
+	Message   string
+	Errors    []expense.FieldError
+	RequestId string
+	// RetryAfter is how long the server asked to wait before trying again.
+	RetryAfter time.Duration
+	// Replayed is set when the response is the stored one of an earlier
+	// request with the same Idempotency-Key.
+	Replayed bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	if e.Status >= http.StatusInternalServerError {
+		return target == ErrServer
+	}
+	return statusErrors[e.Status] == target
+}
+
+// Field returns the validation error of field, nil when it passed.
+func (e *Error) Field(field string) *expense.FieldError {
+	for i := range e.Errors {
+		if e.Errors[i].Field == field {
+			return &e.Errors[i]
+		}
+	}
+	return nil
+}
+
+// responseError reads the problem the API answered with.
+func responseError(res *http.Response) error {
+	p := expense.Problem{}
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil || p.Detail == "" {
+		p.Detail = http.StatusText(res.StatusCode)
+	}
+	if p.RequestId == "" {
+		p.RequestId = res.Header.Get("X-Request-Id")
+	}
+	return &Error{Status: res.StatusCode, Message: p.Detail, Errors: p.Errors, RequestId: p.RequestId, RetryAfter: retryAfter(res), Replayed: res.Header.Get(headerIdempotentReplayed) == "true"}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+// DefaultPageSize is how many expenses a page holds unless Filter says
+// otherwise, the most the API gives.
+const DefaultPageSize = 100
+
+// Filter narrows ListExpenses. Zero fields don't filter, From and To are
+// dates formatted as 2006-01-02.
+type Filter struct {
+	Group    int
+	Status   string
+	Tag      string
+	Owner    string
+	Currency string
+	From     string
+	To       string
+	Meta     map[string]string
+	// PageSize is how many expenses a page holds, DefaultPageSize when 0.
+	PageSize int
+}
+
+func (f Filter) query() url.Values {
+	q := url.Values{}
+	if f.Group != 0 {
+		q.Set("group", strconv.Itoa(f.Group))
+	}
+	for name, v := range map[string]string{"status": f.Status, "tag": f.Tag, "owner": f.Owner, "currency": f.Currency, "from": f.From, "to": f.To} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	for field, v := range f.Meta {
+		q.Set("meta."+field, v)
+	}
+	size := f.PageSize
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	q.Set("limit", strconv.Itoa(size))
+	return q
+}
+
+// CreateExpense creates ex as a draft of the caller. It is sent with an
+// idempotency key, so retrying it doesn't create it twice.
+func (c *Client) CreateExpense(ctx context.Context, ex expense.Expense) (expense.Expense, error) {
+	return c.expense(ctx, http.MethodPost, "/expenses", ex)
+}
+
+func (c *Client) GetExpense(ctx context.Context, id int) (expense.Expense, error) {
+	return c.expense(ctx, http.MethodGet, "/expenses/"+strconv.Itoa(id), nil)
+}
+
+// UpdateExpense writes ex over the expense id, group included: a GroupId of
+// 0 takes the expense out of its group.
+func (c *Client) UpdateExpense(ctx context.Context, id int, ex expense.Expense) (expense.Expense, error) {
+	// group_id is left out of an expense of no group, which would keep it in
+	// its group
+	body := struct {
+		expense.Expense
+		GroupId int `json:"group_id"`
+	}{ex, ex.GroupId}
+	return c.expense(ctx, http.MethodPut, "/expenses/"+strconv.Itoa(id), body)
+}
+
+func (c *Client) DeleteExpense(ctx context.Context, id int) error {
+	r, err := c.newRequest(http.MethodDelete, "/expenses/"+strconv.Itoa(id), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, r, nil)
+	return err
+}
+
+func (c *Client) expense(ctx context.Context, method, path string, in interface{}) (expense.Expense, error) {
+	out := expense.Expense{}
+	r, err := c.newRequest(method, path, nil, in)
+	if err != nil {
+		return out, err
+	}
+	_, err = c.do(ctx, r, &out)
+	return out, err
+}
+
+// ListExpenses returns the pages of the expenses matching f, in id order.
+// Nothing is fetched until Next is called.
+func (c *Client) ListExpenses(ctx context.Context, f Filter) *ExpensePages {
+	return &ExpensePages{c: c, ctx: ctx, next: c.BaseURL + "/expenses?" + f.query().Encode()}
+}
+
+// ExpensePages iterates over pages of expenses:
+//
+//	pages := c.ListExpenses(ctx, client.Filter{Tag: "travel"})
+//	for pages.Next() {
+//		for _, ex := range pages.Page() {
+//			...
+//		}
+//	}
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+type ExpensePages struct {
+	c   *Client
+	ctx context.Context
+	// next is the URL of the next page, empty after the last one.
+	next string
+	page []expense.Expense
+	err  error
+}
+
+// Next fetches the next page. It returns false after the last page or an
+// error.
+func (p *ExpensePages) Next() bool {
+	if p.err != nil || p.next == "" {
+		return false
+	}
+	r := &request{method: http.MethodGet, url: p.next, header: http.Header{}, idempotent: true}
+	page := []expense.Expense{}
+	res, err := p.c.do(p.ctx, r, &page)
+	if err != nil {
+		p.page, p.err = nil, err
+		return false
+	}
+	p.page, p.next = page, p.c.nextLink(res)
+	return true
+}
+
+// Page returns the page Next fetched.
+func (p *ExpensePages) Page() []expense.Expense {
+	return p.page
+}
+
+// Err returns the error that stopped Next.
+func (p *ExpensePages) Err() error {
+	return p.err
+}
+
+// All fetches the pages left and returns their expenses.
+func (p *ExpensePages) All() ([]expense.Expense, error) {
+	exs := []expense.Expense{}
+	for p.Next() {
+		exs = append(exs, p.Page()...)
+	}
+	return exs, p.Err()
+}
+
+// nextLink returns the URL of the rel="next" Link of res, resolved against
+// BaseURL. It is empty when res has none.
+func (c *Client) nextLink(res *http.Response) string {
+	for _, header := range res.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			base, err := url.Parse(c.BaseURL)
+			if err != nil {
+				return ""
+			}
+			ref, err := url.Parse(target)
+			if err != nil {
+				return ""
+			}
+			return base.ResolveReference(ref).String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+func runAdd(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	f := newExpenseFlags(fs)
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	ex := expense.Expense{}
+	if err := f.apply(fs, &ex); err != nil {
+		return err
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	ex, err = c.CreateExpense(context.Background(), ex)
+	if err != nil {
+		return err
+	}
+	return writeTable(cl.stdout, []expense.Expense{ex})
+}
+
+func runList(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	f := filterFlags(fs)
+	output := fs.String("o", "table", "output format: table, json or csv")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	write, err := writer(*output, "table", "json", "csv")
+	if err != nil {
+		return err
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	exs, err := c.ListExpenses(context.Background(), *f).All()
+	if err != nil {
+		return err
+	}
+	return write(cl.stdout, exs)
+}
+
+func runGet(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	output := fs.String("o", "table", "output format: table or json")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("get takes one expense id")
+	}
+	id, err := parseId(pos[0])
+	if err != nil {
+		return err
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("output format %q should be one of table, json", *output)
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	ex, err := c.GetExpense(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		return writeJSON(cl.stdout, ex)
+	}
+	return writeTable(cl.stdout, []expense.Expense{ex})
+}
+
+// runEdit changes the fields given on the command line and keeps the
+// others.
+func runEdit(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	f := newExpenseFlags(fs)
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("edit takes one expense id")
+	}
+	id, err := parseId(pos[0])
+	if err != nil {
+		return err
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	ex, err := c.GetExpense(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := f.apply(fs, &ex); err != nil {
+		return err
+	}
+	ex, err = c.UpdateExpense(ctx, id, ex)
+	if err != nil {
+		return err
+	}
+	return writeTable(cl.stdout, []expense.Expense{ex})
+}
+
+func runDelete(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) == 0 {
+		return errors.New("delete takes the ids of the expenses to delete")
+	}
+	ids := []int{}
+	for _, s := range pos {
+		id, err := parseId(s)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := c.DeleteExpense(context.Background(), id); err != nil {
+			return fmt.Errorf("expense %d: %w", id, err)
+		}
+		fmt.Fprintln(cl.stdout, "deleted", id)
+	}
+	return nil
+}
+
+func runConfig(cl *cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("config takes set, use or show")
+	}
+	cfg, err := loadConfig(cl.configPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		fs := flag.NewFlagSet("config set", flag.ContinueOnError)
+		url := fs.String("url", "", "base URL of the API, such as http://localhost:2565/v1")
+		username := fs.String("username", "", "username")
+		password := fs.String("password", "", "password")
+		pos, err := parse(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(pos) != 1 {
+			return errors.New("config set takes the name of the profile")
+		}
+		p := cfg.Profiles[pos[0]]
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "url":
+				p.URL = *url
+			case "username":
+				p.Username = *username
+			case "password":
+				p.Password = *password
+			}
+		})
+		cfg.Profiles[pos[0]] = p
+		if cfg.Current == "" {
+			cfg.Current = pos[0]
+		}
+	case "use":
+		if len(args) != 2 {
+			return errors.New("config use takes the name of the profile")
+		}
+		if _, ok := cfg.Profiles[args[1]]; !ok {
+			return fmt.Errorf("there's no profile %q", args[1])
+		}
+		cfg.Current = args[1]
+	case "show":
+		cfg.print(cl.stdout)
+		return nil
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+	return cfg.save(cl.configPath)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the profiles expensectl can use. Current is used unless
+// another one is asked for.
+type Config struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile is where a server is and who to call it as.
+type Profile struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// defaultConfigPath is config.yaml in the expensectl directory of the user
+// config directory, unless EXPENSECTL_CONFIG says otherwise.
+func defaultConfigPath() string {
+	if path := os.Getenv("EXPENSECTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "expensectl.yaml"
+	}
+	return filepath.Join(dir, "expensectl", "config.yaml")
+}
+
+// loadConfig reads the config at path. A missing file is an empty config.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{Profiles: map[string]Profile{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("can't read config %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]Profile{}
+	}
+	return cfg, nil
+}
+
+// save writes cfg to path, readable by its owner only as it holds
+// passwords.
+func (cfg *Config) save(path string) error {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// profile returns the profile name, or the current one when name is empty.
+// EXPENSECTL_URL, EXPENSECTL_USERNAME and EXPENSECTL_PASSWORD override what
+// it holds, so scripts can run without a config file.
+func (cfg *Config) profile(name string) (Profile, error) {
+	if name == "" {
+		name = cfg.Current
+	}
+	p, ok := cfg.Profiles[name]
+	if name != "" && !ok {
+		return p, fmt.Errorf("there's no profile %q", name)
+	}
+	for _, o := range []struct {
+		env string
+		v   *string
+	}{{"EXPENSECTL_URL", &p.URL}, {"EXPENSECTL_USERNAME", &p.Username}, {"EXPENSECTL_PASSWORD", &p.Password}} {
+		if v := os.Getenv(o.env); v != "" {
+			*o.v = v
+		}
+	}
+	if p.URL == "" {
+		return p, errors.New("no server to call, add a profile with expensectl config set")
+	}
+	return p, nil
+}
+
+func (cfg *Config) print(w io.Writer) {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := cfg.Profiles[name]
+		mark := " "
+		if name == cfg.Current {
+			mark = "*"
+		}
+		password := ""
+		if p.Password != "" {
+			password = "********"
+		}
+		fmt.Fprintf(w, "%s %s\turl=%s username=%s password=%s\n", mark, name, p.URL, p.Username, password)
+	}
+}
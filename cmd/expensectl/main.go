@@ -0,0 +1,225 @@
+// Command expensectl manages expenses through the API.
+//
+// Usage:
+//
+//	expensectl [-config file] [-profile name] <command> [flags] [args]
+//
+// The server and credentials come from a profile of the config file, see
+// expensectl config.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Suvisuttikasame/assessment/client"
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "expensectl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// cli is what the commands share.
+type cli struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	configPath string
+	profile    string
+}
+
+type command struct {
+	usage string
+	run   func(cl *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"add":    {"add -title T -amount N -tags a,b [-note T] [-currency C] [-date D] [-group N] [-meta k=v]", runAdd},
+	"list":   {"list [filters] [-o table|json|csv]", runList},
+	"get":    {"get ID [-o table|json]", runGet},
+	"edit":   {"edit ID [-title T] [-amount N] [-tags a,b] [-note T] [-currency C] [-date D] [-group N] [-meta k=v]", runEdit},
+	"delete": {"delete ID...", runDelete},
+	"import": {"import [-format json|csv] FILE", runImport},
+	"export": {"export [filters] [-format json|csv] [-f FILE]", runExport},
+	"config": {"config set NAME -url U -username X -password P | config use NAME | config show", runConfig},
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("expensectl", flag.ContinueOnError)
+	cl := &cli{stdin: stdin, stdout: stdout}
+	fs.StringVar(&cl.configPath, "config", defaultConfigPath(), "config file holding the profiles")
+	fs.StringVar(&cl.profile, "profile", os.Getenv("EXPENSECTL_PROFILE"), "profile to use instead of the current one")
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintln(w, "usage: expensectl [-config file] [-profile name] <command> [flags] [args]")
+		fmt.Fprintln(w, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(w, "  "+commands[name].usage)
+		}
+		fmt.Fprintln(w, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see expensectl -h", fs.Arg(0))
+	}
+	return cmd.run(cl, fs.Args()[1:])
+}
+
+// client calls the server of the profile in use.
+func (cl *cli) client() (*client.Client, error) {
+	cfg, err := loadConfig(cl.configPath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cfg.profile(cl.profile)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(p.URL, client.BasicAuth{Username: p.Username, Password: p.Password}), nil
+}
+
+// parse parses the flags of a command, which may come before or after its
+// arguments, and returns the arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	pos := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func parseId(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%q isn't an expense id", s)
+	}
+	return id, nil
+}
+
+// metaFlag collects -meta field=value flags.
+type metaFlag map[string]string
+
+func (m metaFlag) String() string {
+	return ""
+}
+
+func (m metaFlag) Set(s string) error {
+	field, value, ok := strings.Cut(s, "=")
+	if !ok || field == "" {
+		return fmt.Errorf("%q should be field=value", s)
+	}
+	m[field] = value
+	return nil
+}
+
+// expenseFlags are the fields of an expense add and edit take.
+type expenseFlags struct {
+	title, note, tags, currency, date string
+	amount                            float64
+	group                             int
+	meta                              metaFlag
+}
+
+func newExpenseFlags(fs *flag.FlagSet) *expenseFlags {
+	f := &expenseFlags{meta: metaFlag{}}
+	fs.StringVar(&f.title, "title", "", "title")
+	fs.Float64Var(&f.amount, "amount", 0, "amount")
+	fs.StringVar(&f.note, "note", "", "note")
+	fs.StringVar(&f.tags, "tags", "", "comma separated tags")
+	fs.StringVar(&f.currency, "currency", "", "ISO 4217 currency code, the server's default when empty")
+	fs.StringVar(&f.date, "date", "", "date formatted as 2006-01-02, today when empty")
+	fs.IntVar(&f.group, "group", 0, "id of the group the expense is filed under")
+	fs.Var(f.meta, "meta", "metadata field=value, may be repeated")
+	return f
+}
+
+// apply sets the fields of ex given on the command line.
+func (f *expenseFlags) apply(fs *flag.FlagSet, ex *expense.Expense) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "title":
+			ex.Title = f.title
+		case "amount":
+			ex.Amount = float32(f.amount)
+		case "note":
+			ex.Note = f.note
+		case "tags":
+			ex.Tags = splitTags(f.tags)
+		case "currency":
+			ex.Currency = f.currency
+		case "date":
+			ex.Date, err = expense.ParseDate(f.date)
+		case "group":
+			ex.GroupId = f.group
+		case "meta":
+			if ex.Metadata == nil {
+				ex.Metadata = expense.Metadata{}
+			}
+			// values are read as JSON when they are, so that km=12 is a number
+			for field, value := range f.meta {
+				var v interface{}
+				if json.Unmarshal([]byte(value), &v) != nil {
+					v = value
+				}
+				ex.Metadata[field] = v
+			}
+		}
+	})
+	return err
+}
+
+func splitTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// filterFlags are the filters list and export take.
+func filterFlags(fs *flag.FlagSet) *client.Filter {
+	f := &client.Filter{Meta: metaFlag{}}
+	fs.IntVar(&f.Group, "group", 0, "only expenses of this group")
+	fs.StringVar(&f.Status, "status", "", "only expenses in this status")
+	fs.StringVar(&f.Tag, "tag", "", "only expenses with this tag")
+	fs.StringVar(&f.Owner, "owner", "", "only expenses of this owner")
+	fs.StringVar(&f.Currency, "currency", "", "only expenses in this currency")
+	fs.StringVar(&f.From, "from", "", "only expenses dated on or after this day, as 2006-01-02")
+	fs.StringVar(&f.To, "to", "", "only expenses dated on or before this day, as 2006-01-02")
+	fs.Var(metaFlag(f.Meta), "meta", "only expenses whose metadata field=value, may be repeated")
+	return f
+}
@@ -0,0 +1,162 @@
+//go:build unit
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+var testExpenses = []expense.Expense{
+	{Id: 1, Title: "taxi", Amount: 250, Tags: []string{"travel"}, Owner: "admin", Status: expense.StatusDraft, Currency: "THB", Metadata: expense.Metadata{"km": float64(12)}},
+	{Id: 2, Title: "lunch, team", Amount: 99.5, Tags: []string{"food", "team"}, Owner: "bob", Status: expense.StatusApproved, Currency: "THB", GroupId: 3},
+}
+
+// testServer answers with the expenses above and records the requests it
+// gets. It is the server of the profile the commands use.
+func testServer(t *testing.T) *[]*http.Request {
+	reqs := []*http.Request{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		reqs = append(reqs, r)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/expenses":
+			json.NewEncoder(w).Encode(testExpenses)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/expenses/1":
+			json.NewEncoder(w).Encode(testExpenses[0])
+		case r.Method == http.MethodPost || r.Method == http.MethodPut:
+			ex := expense.Expense{}
+			json.Unmarshal(body, &ex)
+			if ex.Title == "" {
+				w.Header().Set("Content-Type", expense.MIMEApplicationProblemJSON)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":400,"detail":"title error : this field should not empty.","message":"title error : this field should not empty."}`))
+				return
+			}
+			ex.Id = 9
+			json.NewEncoder(w).Encode(ex)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("EXPENSECTL_CONFIG", filepath.Join(t.TempDir(), "config.yaml"))
+	t.Setenv("EXPENSECTL_URL", srv.URL+"/v1")
+	t.Setenv("EXPENSECTL_USERNAME", "admin")
+	t.Setenv("EXPENSECTL_PASSWORD", "admin")
+	return &reqs
+}
+
+func TestListAsCSV(t *testing.T) {
+	reqs := testServer(t)
+	out := &bytes.Buffer{}
+
+	err := run([]string{"list", "-tag", "travel", "-meta", "project=apollo", "-o", "csv"}, nil, out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "limit=100&meta.project=apollo&tag=travel", (*reqs)[0].URL.Query().Encode())
+	assert.Equal(t, `id,title,amount,currency,date,status,owner,tags,note,group_id,metadata
+1,taxi,250,THB,,draft,admin,travel,,,"{""km"":12}"
+2,"lunch, team",99.5,THB,,approved,bob,food;team,,3,
+`, out.String())
+}
+
+func TestListAsTable(t *testing.T) {
+	testServer(t)
+	out := &bytes.Buffer{}
+
+	err := run([]string{"list"}, nil, out)
+
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "ID  TITLE"))
+	assert.Contains(t, lines[2], "lunch, team")
+}
+
+func TestImportCSV(t *testing.T) {
+	reqs := testServer(t)
+	in := strings.NewReader("title,amount,tags,metadata\ntaxi,250,travel;work,\"{\"\"km\"\":12}\"\n,10,food,\n")
+	out := &bytes.Buffer{}
+
+	err := run([]string{"import", "-format", "csv", "-"}, in, out)
+
+	assert.EqualError(t, err, "1 of 2 expenses weren't imported")
+	assert.Len(t, *reqs, 2)
+	ex := expense.Expense{}
+	json.NewDecoder((*reqs)[0].Body).Decode(&ex)
+	assert.Equal(t, "taxi", ex.Title)
+	assert.Equal(t, float32(250), ex.Amount)
+	assert.Equal(t, []string{"travel", "work"}, ex.Tags)
+	assert.Equal(t, float64(12), ex.Metadata["km"])
+	assert.Equal(t, "created 9 taxi\nexpense 2 (): 400 Bad Request: title error : this field should not empty.\n", out.String())
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	testServer(t)
+	file := filepath.Join(t.TempDir(), "expenses.csv")
+
+	err := run([]string{"export", "-f", file}, nil, &bytes.Buffer{})
+	assert.Nil(t, err)
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal("unable to open the export", err)
+	}
+	defer f.Close()
+	exs, err := readCSV(f)
+
+	assert.Nil(t, err)
+	if assert.Len(t, exs, 2) {
+		assert.Equal(t, "lunch, team", exs[1].Title)
+		assert.Equal(t, []string{"food", "team"}, exs[1].Tags)
+		assert.Equal(t, 3, exs[1].GroupId)
+		assert.Equal(t, expense.Metadata{"km": float64(12)}, exs[0].Metadata)
+	}
+}
+
+func TestEditKeepsOtherFields(t *testing.T) {
+	reqs := testServer(t)
+
+	err := run([]string{"edit", "1", "-amount", "300", "-meta", "km=15"}, nil, &bytes.Buffer{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.MethodPut, (*reqs)[1].Method)
+	ex := expense.Expense{}
+	json.NewDecoder((*reqs)[1].Body).Decode(&ex)
+	assert.Equal(t, "taxi", ex.Title)
+	assert.Equal(t, float32(300), ex.Amount)
+	assert.Equal(t, float64(15), ex.Metadata["km"])
+}
+
+func TestConfigProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	out := &bytes.Buffer{}
+
+	assert.Nil(t, run([]string{"-config", path, "config", "set", "local", "-url", "http://localhost:2565/v1", "-username", "admin", "-password", "secret"}, nil, out))
+	assert.Nil(t, run([]string{"-config", path, "config", "set", "prod", "-url", "https://expenses.example.com/v1"}, nil, out))
+	assert.Nil(t, run([]string{"-config", path, "config", "use", "prod"}, nil, out))
+	assert.Nil(t, run([]string{"-config", path, "config", "show"}, nil, out))
+
+	assert.Equal(t, "  local\turl=http://localhost:2565/v1 username=admin password=********\n* prod\turl=https://expenses.example.com/v1 username= password=\n", out.String())
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	cfg, err := loadConfig(path)
+	assert.Nil(t, err)
+	p, err := cfg.profile("local")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", p.Password)
+	assert.EqualError(t, run([]string{"-config", path, "config", "use", "staging"}, nil, out), `there's no profile "staging"`)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+type writeFunc func(w io.Writer, exs []expense.Expense) error
+
+var writers = map[string]writeFunc{
+	"table": writeTable,
+	"json":  func(w io.Writer, exs []expense.Expense) error { return writeJSON(w, exs) },
+	"csv":   writeCSV,
+}
+
+// writer returns how to write format, which has to be one of formats.
+func writer(format string, formats ...string) (writeFunc, error) {
+	for _, f := range formats {
+		if f == format {
+			return writers[format], nil
+		}
+	}
+	return nil, fmt.Errorf("output format %q should be one of %s", format, strings.Join(formats, ", "))
+}
+
+func writeTable(w io.Writer, exs []expense.Expense) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tAMOUNT\tCURRENCY\tDATE\tSTATUS\tOWNER\tTAGS")
+	for _, ex := range exs {
+		fmt.Fprintf(tw, "%d\t%s\t%.2f\t%s\t%s\t%s\t%s\t%s\n", ex.Id, ex.Title, ex.Amount, ex.Currency, ex.Date, ex.Status, ex.Owner, strings.Join(ex.Tags, ","))
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// csvHeader are the columns of the CSV files export writes and import
+// reads. Tags are separated by ";" and metadata is a JSON object. Splits
+// are left out, export them as JSON to keep them.
+var csvHeader = []string{"id", "title", "amount", "currency", "date", "status", "owner", "tags", "note", "group_id", "metadata"}
+
+func writeCSV(w io.Writer, exs []expense.Expense) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, ex := range exs {
+		meta := ""
+		if len(ex.Metadata) > 0 {
+			b, err := json.Marshal(ex.Metadata)
+			if err != nil {
+				return err
+			}
+			meta = string(b)
+		}
+		group := ""
+		if ex.GroupId != 0 {
+			group = strconv.Itoa(ex.GroupId)
+		}
+		record := []string{strconv.Itoa(ex.Id), ex.Title, strconv.FormatFloat(float64(ex.Amount), 'f', -1, 32), ex.Currency, ex.Date.String(),
+			ex.Status, ex.Owner, strings.Join(ex.Tags, ";"), ex.Note, group, meta}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// readCSV reads expenses written by writeCSV. Columns are found by their
+// header, so they may come in any order and only title is needed.
+func readCSV(r io.Reader) ([]expense.Expense, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read the CSV header: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	if _, ok := col["title"]; !ok {
+		return nil, fmt.Errorf("the CSV header should have a title column")
+	}
+
+	exs := []expense.Expense{}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return exs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := col[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		ex := expense.Expense{Title: get("title"), Currency: get("currency"), Note: get("note"), Tags: []string{}}
+		if s := get("amount"); s != "" {
+			amount, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: amount %q should be a number", line, s)
+			}
+			ex.Amount = float32(amount)
+		}
+		if s := get("date"); s != "" {
+			if ex.Date, err = expense.ParseDate(s); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if s := get("group_id"); s != "" {
+			if ex.GroupId, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("line %d: group_id %q should be a group id", line, s)
+			}
+		}
+		for _, t := range strings.Split(get("tags"), ";") {
+			if t = strings.TrimSpace(t); t != "" {
+				ex.Tags = append(ex.Tags, t)
+			}
+		}
+		if s := get("metadata"); s != "" {
+			if err := json.Unmarshal([]byte(s), &ex.Metadata); err != nil {
+				return nil, fmt.Errorf("line %d: metadata should be a JSON object", line)
+			}
+		}
+		exs = append(exs, ex)
+	}
+}
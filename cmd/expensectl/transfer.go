@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+// runImport creates the expenses of a JSON or CSV file, as export writes
+// them. Ids, owners and statuses in the file are ignored: the expenses are
+// created as drafts of the profile user. One that fails doesn't stop the
+// others.
+func runImport(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "json or csv, taken from the file extension when empty")
+	pos, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errors.New("import takes one file, - for stdin")
+	}
+	if *format == "" {
+		*format = formatOf(pos[0])
+	}
+
+	r := cl.stdin
+	if pos[0] != "-" {
+		f, err := os.Open(pos[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	exs := []expense.Expense{}
+	switch *format {
+	case "json":
+		if err := json.NewDecoder(r).Decode(&exs); err != nil {
+			return fmt.Errorf("the file should be a JSON list of expenses: %w", err)
+		}
+	case "csv":
+		if exs, err = readCSV(r); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("import format %q should be json or csv", *format)
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i, ex := range exs {
+		created, err := c.CreateExpense(context.Background(), ex)
+		if err != nil {
+			failed++
+			fmt.Fprintf(cl.stdout, "expense %d (%s): %v\n", i+1, ex.Title, err)
+			continue
+		}
+		fmt.Fprintln(cl.stdout, "created", created.Id, created.Title)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d expenses weren't imported", failed, len(exs))
+	}
+	return nil
+}
+
+// runExport writes the expenses matching the filters in a file import
+// reads.
+func runExport(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	f := filterFlags(fs)
+	format := fs.String("format", "", "json or csv, taken from the file extension when empty, json on stdout")
+	file := fs.String("f", "-", "file to write, - for stdout")
+	if _, err := parse(fs, args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = formatOf(*file)
+	}
+	write, err := writer(*format, "json", "csv")
+	if err != nil {
+		return err
+	}
+
+	c, err := cl.client()
+	if err != nil {
+		return err
+	}
+	exs, err := c.ListExpenses(context.Background(), *f).All()
+	if err != nil {
+		return err
+	}
+
+	if *file == "-" {
+		return write(cl.stdout, exs)
+	}
+	out, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if err := write(out, exs); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cl.stdout, "exported %d expenses to %s\n", len(exs), *file)
+	return nil
+}
+
+// formatOf is the format of a file named as path, json unless it ends
+// with .csv.
+func formatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/config"
+	"github.com/Suvisuttikasame/assessment/customMiddleware"
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+// cli is what the commands share.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	cfg    *config.Config
+}
+
+type command struct {
+	usage string
+	run   func(cl *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"serve":   {"serve", runServe},
+	"migrate": {"migrate up | migrate down [-steps N] [-drop] | migrate status", runMigrate},
+	"seed":    {"seed -count N [-owner USERNAME] [-seed N]", runSeed},
+	"user":    {"user add [-role R] [-password P] USERNAME | user passwd [-password P] USERNAME", runUser},
+	"check":   {"check", runCheck},
+	"config":  {"config", runConfig},
+}
+
+// run runs the command of args, serve when there's none. The settings
+// flags come before the command.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	loader := config.Flags(fs)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintln(w, "usage: server [-config file] [settings] [command] [flags] [args]")
+		fmt.Fprintln(w, "\nWithout a command the servers start.")
+		fmt.Fprintln(w, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(w, "  "+commands[name].usage)
+		}
+		fmt.Fprintln(w, "\nsettings, over the config file and the environment:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	if args[0] == "help" {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, see -h", args[0])
+	}
+
+	cfg, err := loader.Load(os.Getenv)
+	if err != nil {
+		return err
+	}
+	logger, err := newLogger(os.Stderr, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return cmd.run(&cli{stdin: stdin, stdout: stdout, cfg: cfg}, args[1:])
+}
+
+// newLogger logs JSON lines at level and above. The logs go to stderr,
+// stdout is left to what the commands print and to the outbox events.
+func newLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})), nil
+}
+
+// connectDb connects expense.Db to the database without changing its
+// schema.
+var connectDb = func(db config.Database) error {
+	if err := expense.OpenDb(db.URL); err != nil {
+		return err
+	}
+	expense.Db.SetMaxOpenConns(db.MaxOpenConns)
+	expense.Db.SetMaxIdleConns(db.MaxIdleConns)
+	expense.Db.SetConnMaxLifetime(db.ConnMaxLifetime)
+	expense.Db.SetConnMaxIdleTime(db.ConnMaxIdleTime)
+	return nil
+}
+
+// runConfig prints the settings in effect, with their secrets hidden.
+func runConfig(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return cl.cfg.Write(cl.stdout)
+}
+
+func runMigrate(cl *cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate takes up, down or status")
+	}
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	steps := 1
+	drop := false
+	if args[0] == "down" {
+		fs.IntVar(&steps, "steps", 1, "how many migrations to revert")
+		fs.BoolVar(&drop, "drop", false, "revert migrations that drop records for good, such as expenses or the audit log")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("migrate %s takes no arguments", args[0])
+	}
+	if steps < 1 {
+		return errors.New("steps should be at least 1")
+	}
+	if err := connectDb(cl.cfg.Database); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := expense.MigrateUp(expense.Db)
+		for _, m := range done {
+			fmt.Fprintf(cl.stdout, "applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		// the tables of the middlewares aren't versioned, they are created
+		// as they are needed
+		if err := customMiddleware.CreateTables(expense.Db); err != nil {
+			return err
+		}
+	case "down":
+		done, err := expense.MigrateDown(expense.Db, steps, drop)
+		for _, m := range done {
+			fmt.Fprintf(cl.stdout, "reverted %d %s\n", m.Version, m.Name)
+		}
+		if de, ok := err.(*expense.MigrationDropError); ok {
+			return fmt.Errorf("%w, run migrate down with -drop to revert it", de)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		states, err := expense.MigrationStatus(expense.Db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(cl.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if s.Applied() {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	v, err := expense.SchemaVersion(expense.Db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cl.stdout, "schema version", v)
+	return nil
+}
+
+// runSeed stores synthetic expenses, to try the API or load test it.
+func runSeed(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := fs.Int("count", 0, "how many expenses to create")
+	owner := fs.String("owner", "admin", "user owning the expenses")
+	seed := fs.Int64("seed", 0, "seed of the random expenses, the current time when 0")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return errors.New("count should be at least 1")
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	if err := connectDb(cl.cfg.Database); err != nil {
+		return err
+	}
+
+	exs := expense.SyntheticExpenses(rand.New(rand.NewSource(*seed)), *count, *owner)
+	if err := expense.Seed(exs); err != nil {
+		return err
+	}
+	fmt.Fprintf(cl.stdout, "created %d expenses of %s, ids %d to %d\n", len(exs), *owner, exs[0].Id, exs[len(exs)-1].Id)
+	return nil
+}
+
+// runUser creates users and changes their passwords. A password that isn't
+// given with -password is read from the first line of stdin, which keeps it
+// out of the shell history.
+func runUser(cl *cli, args []string) error {
+	if len(args) == 0 {
+		return errors.New("user takes add or passwd")
+	}
+	fs := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	password := fs.String("password", "", "password, read from stdin when empty")
+	role := fs.String("role", string(expense.RoleViewer), "role of the user: viewer, editor, approver or admin")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("user %s takes the username", args[0])
+	}
+	username := fs.Arg(0)
+	if *password == "" {
+		p, err := readPassword(cl.stdin)
+		if err != nil {
+			return err
+		}
+		*password = p
+	}
+	if err := connectDb(cl.cfg.Database); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if err := expense.AddUser(expense.User{Username: username, Password: *password, Role: expense.Role(*role)}); err != nil {
+			return err
+		}
+		fmt.Fprintln(cl.stdout, "added", username)
+	case "passwd":
+		if err := expense.SetPassword(username, *password); err != nil {
+			return err
+		}
+		fmt.Fprintln(cl.stdout, "changed the password of", username)
+	default:
+		return fmt.Errorf("unknown user command %q", args[0])
+	}
+	return nil
+}
+
+func readPassword(r io.Reader) (string, error) {
+	if r == nil {
+		return "", errors.New("password should be given with -password or on stdin")
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("password should be given with -password or on stdin")
+	}
+	return line, nil
+}
+
+// checkTimeout bounds how long check waits for the database.
+const checkTimeout = 5 * time.Second
+
+// runCheck tells whether the database is reachable and its schema is the
+// one this build expects, as a readiness check before serving.
+func runCheck(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := connectDb(cl.cfg.Database); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	if err := expense.Db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database is unreachable: %w", err)
+	}
+	fmt.Fprintln(cl.stdout, "database is reachable")
+
+	v, err := expense.SchemaVersion(expense.Db)
+	if err != nil {
+		return err
+	}
+	latest := expense.LatestSchemaVersion()
+	switch {
+	case v < latest:
+		return fmt.Errorf("schema version %d is behind %d, run migrate up", v, latest)
+	case v > latest:
+		return fmt.Errorf("schema version %d is ahead of %d, this build is older than the database", v, latest)
+	}
+	fmt.Fprintln(cl.stdout, "schema version", v, "is up to date")
+	return nil
+}
@@ -0,0 +1,275 @@
+// Package config is the configuration of the server. Every setting has a
+// default, which a YAML or TOML file, then an environment variable and then
+// a flag override:
+//
+//	server -config server.yaml -server.port 2565 serve
+//
+// sets server.port from the flag whatever the file and PORT say.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config is every setting, the yaml and toml tags are the keys in the file,
+// env the environment variable and the flag is the keys joined with dots,
+// such as -server.read_timeout. Settings tagged secret are redacted when
+// printed.
+type Config struct {
+	Server   Server   `yaml:"server" toml:"server"`
+	Database Database `yaml:"database" toml:"database"`
+	Auth     Auth     `yaml:"auth" toml:"auth"`
+	Log      Log      `yaml:"log" toml:"log"`
+	Features Features `yaml:"features" toml:"features"`
+	Files    Files    `yaml:"files" toml:"files"`
+}
+
+type Server struct {
+	Port     int `yaml:"port" toml:"port" env:"PORT" usage:"port of the HTTP API"`
+	GRPCPort int `yaml:"grpc_port" toml:"grpc_port" env:"GRPC_PORT" usage:"port of the gRPC API"`
+	// The timeouts of the HTTP server, 0 is none.
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"SERVER_READ_TIMEOUT" usage:"longest time to read a request, 0 for none"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" usage:"longest time to write a response, 0 for none"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" usage:"longest time a keep-alive connection waits for a request, 0 for none"`
+	// ShutdownTimeout is how long requests in progress may take to finish on
+	// shut down.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long requests in progress may take to finish on shut down"`
+	// LegacySunset is when the routes without a version prefix stop
+	// answering.
+	LegacySunset Date `yaml:"legacy_sunset" toml:"legacy_sunset" env:"LEGACY_SUNSET" usage:"date the routes without a version prefix stop answering"`
+}
+
+type Database struct {
+	URL string `yaml:"url" toml:"url" env:"DATABASE_URL" secret:"true" usage:"postgres URL or key=value connection string"`
+	// The pool of connections, see sql.DB. 0 max_open_conns is no limit and
+	// 0 durations keep connections forever.
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"most connections open at once, 0 for no limit"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"most idle connections kept"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"longest time a connection is reused, 0 for forever"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" usage:"longest time a connection stays idle, 0 for forever"`
+}
+
+type Auth struct {
+	// RateLimitStore and IdempotencyStore are memory, or postgres so that
+	// they hold across replicas.
+	RateLimitStore     string        `yaml:"rate_limit_store" toml:"rate_limit_store" env:"RATE_LIMIT_STORE" usage:"where rate limits and failed logins are kept: memory or postgres"`
+	Rate               float64       `yaml:"rate" toml:"rate" env:"RATE_LIMIT" usage:"requests per second a client may make"`
+	Burst              int           `yaml:"burst" toml:"burst" env:"RATE_LIMIT_BURST" usage:"requests a client may make at once"`
+	LockoutMaxFailures int           `yaml:"lockout_max_failures" toml:"lockout_max_failures" env:"LOCKOUT_MAX_FAILURES" usage:"failed logins in a row that lock a credential out"`
+	LockoutBase        time.Duration `yaml:"lockout_base" toml:"lockout_base" env:"LOCKOUT_BASE" usage:"first lock out, doubled every further failure"`
+	LockoutMax         time.Duration `yaml:"lockout_max" toml:"lockout_max" env:"LOCKOUT_MAX" usage:"longest lock out"`
+	IdempotencyStore   string        `yaml:"idempotency_store" toml:"idempotency_store" env:"IDEMPOTENCY_STORE" usage:"where responses to Idempotency-Key requests are kept: memory or postgres"`
+	IdempotencyTTL     time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" usage:"how long idempotency keys are kept"`
+}
+
+type Log struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" usage:"lowest level logged: debug, info, warn or error"`
+}
+
+type Features struct {
+	GRPC         bool `yaml:"grpc" toml:"grpc" env:"FEATURE_GRPC" usage:"serve the gRPC API"`
+	LegacyRoutes bool `yaml:"legacy_routes" toml:"legacy_routes" env:"FEATURE_LEGACY_ROUTES" usage:"serve the routes without a version prefix until they are sunset"`
+	// Webhooks is whether this replica delivers webhooks.
+	Webhooks bool `yaml:"webhooks" toml:"webhooks" env:"FEATURE_WEBHOOKS" usage:"deliver webhooks from this replica"`
+}
+
+type Files struct {
+	ValidationRules string `yaml:"validation_rules" toml:"validation_rules" env:"VALIDATION_RULES" usage:"YAML or JSON file of validation rules"`
+	BankLayouts     string `yaml:"bank_layouts" toml:"bank_layouts" env:"BANK_LAYOUTS" usage:"YAML or JSON file of bank file layouts, overriding the built-in ones"`
+	// PDFFont is embedded in the PDF statements, which are in Courier
+	// without it and can't show Thai.
+	PDFFont string `yaml:"pdf_font" toml:"pdf_font" env:"PDF_FONT" usage:"TrueType font of the PDF statements, one with Thai and Latin glyphs such as Sarabun"`
+	// OutboxNDJSON is a file the events are also written to, one JSON event
+	// per line, - for stdout.
+	OutboxNDJSON string `yaml:"outbox_ndjson" toml:"outbox_ndjson" env:"OUTBOX_NDJSON" usage:"file the events are also written to, - for stdout"`
+}
+
+// Default is the configuration before any file, variable or flag. The
+// database URL has no default.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:            2565,
+			GRPCPort:        9090,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 10 * time.Second,
+			LegacySunset:    Date{time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)},
+		},
+		Database: Database{
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Auth: Auth{
+			RateLimitStore:     "memory",
+			Rate:               10,
+			Burst:              20,
+			LockoutMaxFailures: 5,
+			LockoutBase:        time.Minute,
+			LockoutMax:         time.Hour,
+			IdempotencyStore:   "memory",
+			IdempotencyTTL:     24 * time.Hour,
+		},
+		Log: Log{Level: "info"},
+		Features: Features{
+			GRPC:         true,
+			LegacyRoutes: true,
+			Webhooks:     true,
+		},
+	}
+}
+
+var (
+	stores    = []string{"memory", "postgres"}
+	logLevels = []string{"debug", "info", "warn", "error"}
+)
+
+// Validate returns every invalid setting at once, so that a deploy is fixed
+// in one go.
+func (c Config) Validate() error {
+	v := validator{}
+	v.port("server.port", c.Server.Port)
+	v.port("server.grpc_port", c.Server.GRPCPort)
+	if c.Features.GRPC && c.Server.GRPCPort == c.Server.Port {
+		v.add("server.grpc_port", "should differ from server.port")
+	}
+	v.notNegative("server.read_timeout", c.Server.ReadTimeout)
+	v.notNegative("server.write_timeout", c.Server.WriteTimeout)
+	v.notNegative("server.idle_timeout", c.Server.IdleTimeout)
+	v.positive("server.shutdown_timeout", float64(c.Server.ShutdownTimeout))
+	if c.Features.LegacyRoutes && c.Server.LegacySunset.IsZero() {
+		v.add("server.legacy_sunset", "is required while features.legacy_routes is on")
+	}
+
+	switch {
+	case c.Database.URL == "":
+		v.add("database.url", "is required")
+	case !strings.HasPrefix(c.Database.URL, "postgres://") && !strings.HasPrefix(c.Database.URL, "postgresql://") && !strings.Contains(c.Database.URL, "="):
+		v.add("database.url", "should be a postgres:// URL or a key=value connection string")
+	}
+	if c.Database.MaxOpenConns < 0 {
+		v.add("database.max_open_conns", "should not be negative")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		v.add("database.max_idle_conns", "should not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		v.add("database.max_idle_conns", "should not be more than database.max_open_conns")
+	}
+	v.notNegative("database.conn_max_lifetime", c.Database.ConnMaxLifetime)
+	v.notNegative("database.conn_max_idle_time", c.Database.ConnMaxIdleTime)
+
+	v.oneOf("auth.rate_limit_store", c.Auth.RateLimitStore, stores)
+	v.positive("auth.rate", c.Auth.Rate)
+	v.positive("auth.burst", float64(c.Auth.Burst))
+	v.positive("auth.lockout_max_failures", float64(c.Auth.LockoutMaxFailures))
+	v.positive("auth.lockout_base", float64(c.Auth.LockoutBase))
+	if c.Auth.LockoutMax < c.Auth.LockoutBase {
+		v.add("auth.lockout_max", "should not be less than auth.lockout_base")
+	}
+	v.oneOf("auth.idempotency_store", c.Auth.IdempotencyStore, stores)
+	v.positive("auth.idempotency_ttl", float64(c.Auth.IdempotencyTTL))
+
+	v.oneOf("log.level", c.Log.Level, logLevels)
+
+	v.file("files.validation_rules", c.Files.ValidationRules)
+	v.file("files.bank_layouts", c.Files.BankLayouts)
+	v.file("files.pdf_font", c.Files.PDFFont)
+	return v.err()
+}
+
+// validator collects what is wrong with the settings, each named with
+// where it is set.
+type validator []string
+
+func (v *validator) add(name, problem string) {
+	*v = append(*v, describe(name)+" "+problem)
+}
+
+func (v *validator) port(name string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(name, fmt.Sprintf("should be between 1 and 65535, got %d", port))
+	}
+}
+
+func (v *validator) positive(name string, n float64) {
+	if n <= 0 {
+		v.add(name, "should be more than 0")
+	}
+}
+
+func (v *validator) notNegative(name string, d time.Duration) {
+	if d < 0 {
+		v.add(name, "should not be negative")
+	}
+}
+
+func (v *validator) oneOf(name, value string, values []string) {
+	for _, s := range values {
+		if value == s {
+			return
+		}
+	}
+	v.add(name, fmt.Sprintf("should be one of %s, got %q", strings.Join(values, ", "), value))
+}
+
+func (v *validator) file(name, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.add(name, "should be a readable file: "+err.Error())
+	}
+}
+
+func (v validator) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return errors.New("invalid config:\n\t" + strings.Join(v, "\n\t"))
+}
+
+// describe names a setting with its variable, such as
+// "server.port (PORT)".
+func describe(name string) string {
+	for _, s := range settings(&Config{}) {
+		if s.name == name && s.env != "" {
+			return name + " (" + s.env + ")"
+		}
+	}
+	return name
+}
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day, written as 2006-01-02.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalText() ([]byte, error) {
+	if d.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(d.Format(dateLayout)), nil
+}
+
+func (d *Date) UnmarshalText(b []byte) error {
+	if len(b) == 0 {
+		*d = Date{}
+		return nil
+	}
+	t, err := time.Parse(dateLayout, string(b))
+	if err != nil {
+		return fmt.Errorf("%q should be a date such as 2027-04-30", b)
+	}
+	*d = Date{t}
+	return nil
+}
@@ -0,0 +1,166 @@
+//go:build unit
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// env is the environment of a test.
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func load(t *testing.T, args []string, vars map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	l := Flags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal("unable to parse the flags", err)
+	}
+	return l.Load(env(vars))
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal("unable to write the config file", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	//arrange
+	path := writeFile(t, "server.yaml", `
+server:
+  port: 8080
+  grpc_port: 8081
+  read_timeout: 3s
+  legacy_sunset: 2028-01-31
+database:
+  url: postgres://app:s3cret@db/expenses
+  max_open_conns: 50
+features:
+  webhooks: false
+`)
+
+	//action
+	cfg, err := load(t, []string{"-config", path, "-server.grpc_port", "7070"}, map[string]string{
+		"PORT":      "9000",
+		"GRPC_PORT": "9001",
+		"LOG_LEVEL": "debug",
+	})
+
+	//assert
+	if assert.Nil(t, err) {
+		assert.Equal(t, 9000, cfg.Server.Port)
+		assert.Equal(t, 7070, cfg.Server.GRPCPort)
+		assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout)
+		assert.Equal(t, 30*time.Second, cfg.Server.WriteTimeout)
+		assert.Equal(t, "2028-01-31", cfg.Server.LegacySunset.Format(dateLayout))
+		assert.Equal(t, 50, cfg.Database.MaxOpenConns)
+		assert.Equal(t, "debug", cfg.Log.Level)
+		assert.False(t, cfg.Features.Webhooks)
+		assert.True(t, cfg.Features.GRPC)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "server.toml", `
+[server]
+shutdown_timeout = "20s"
+legacy_sunset = "2028-01-31"
+
+[auth]
+rate_limit_store = "postgres"
+idempotency_ttl = "1h"
+`)
+
+	cfg, err := load(t, nil, map[string]string{"CONFIG_FILE": path, "DATABASE_URL": "host=db dbname=expenses"})
+
+	if assert.Nil(t, err) {
+		assert.Equal(t, 20*time.Second, cfg.Server.ShutdownTimeout)
+		assert.Equal(t, "postgres", cfg.Auth.RateLimitStore)
+		assert.Equal(t, time.Hour, cfg.Auth.IdempotencyTTL)
+		assert.Equal(t, "2028-01-31", cfg.Server.LegacySunset.Format(dateLayout))
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Run("should tell every invalid setting at once", func(t *testing.T) {
+		_, err := load(t, nil, map[string]string{"PORT": "70000", "LOG_LEVEL": "verbose"})
+
+		assert.EqualError(t, err, `invalid config:
+	server.port (PORT) should be between 1 and 65535, got 70000
+	database.url (DATABASE_URL) is required
+	log.level (LOG_LEVEL) should be one of debug, info, warn, error, got "verbose"`)
+	})
+
+	t.Run("should tell which variable doesn't parse", func(t *testing.T) {
+		_, err := load(t, nil, map[string]string{"DATABASE_URL": "postgres://db", "IDEMPOTENCY_TTL": "a day"})
+
+		assert.EqualError(t, err, `IDEMPOTENCY_TTL: "a day" should be a duration such as 10s or 5m`)
+	})
+
+	t.Run("should reject misspelled settings in the file", func(t *testing.T) {
+		path := writeFile(t, "server.yaml", "server:\n  prot: 8080\n")
+
+		_, err := load(t, []string{"-config", path}, map[string]string{"DATABASE_URL": "postgres://db"})
+
+		assert.ErrorContains(t, err, "field prot not found")
+	})
+
+	t.Run("should reject invalid flags as they are parsed", func(t *testing.T) {
+		fs := flag.NewFlagSet("server", flag.ContinueOnError)
+		fs.SetOutput(&bytes.Buffer{})
+		Flags(fs)
+
+		err := fs.Parse([]string{"-features.grpc=maybe"})
+
+		assert.EqualError(t, err, `invalid boolean value "maybe" for -features.grpc: "maybe" should be true or false`)
+	})
+}
+
+func TestWriteRedactsSecrets(t *testing.T) {
+	for _, url := range []string{
+		"postgres://app:s3cret@db/expenses",
+		"postgres://db/expenses?user=app&password=s3cret&sslmode=verify-full&sslpassword=s3cret",
+		"host=db user=app password=s3cret sslpassword=s3cret dbname=expenses",
+	} {
+		cfg, err := load(t, nil, map[string]string{"DATABASE_URL": url})
+		if err != nil {
+			t.Fatal("unable to load the config", err)
+		}
+		out := &bytes.Buffer{}
+
+		err = cfg.Write(out)
+
+		assert.Nil(t, err)
+		assert.NotContains(t, out.String(), "s3cret")
+		assert.Contains(t, out.String(), "xxxxx")
+		assert.Contains(t, out.String(), "legacy_sunset: \"2027-04-30\"")
+		assert.Equal(t, url, cfg.Database.URL)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"postgres://app:s3cret@db/expenses", "postgres://app:xxxxx@db/expenses"},
+		{"postgres://db/expenses?user=app&password=s3cret&sslmode=require", "postgres://db/expenses?user=app&password=xxxxx&sslmode=require"},
+		{"postgres://app@db/expenses?sslpassword=s3cret", "postgres://app@db/expenses?sslpassword=xxxxx"},
+		{"host=db password='s 3cret' sslpassword=s3cret", "host=db password=xxxxx sslpassword=xxxxx"},
+		{"s3cret", "xxxxx"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, redact(tt.secret))
+	}
+}
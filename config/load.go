@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a field of Config that is set, as opposed to a section.
+type setting struct {
+	// name is the keys in the file joined with dots, it names the flag.
+	name   string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the settings of c, their values are the fields of c.
+func settings(c *Config) []setting {
+	return walk(reflect.ValueOf(c).Elem(), "")
+}
+
+func walk(v reflect.Value, prefix string) []setting {
+	ss := []setting{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + f.Tag.Get("yaml")
+		// sections have no usage
+		if f.Tag.Get("usage") == "" {
+			ss = append(ss, walk(v.Field(i), name+".")...)
+			continue
+		}
+		ss = append(ss, setting{
+			name:   name,
+			env:    f.Tag.Get("env"),
+			usage:  f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return ss
+}
+
+func find(ss []setting, name string) (setting, bool) {
+	for _, s := range ss {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// set parses s into v, as given by a variable or a flag.
+func set(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q should be a duration such as 10s or 5m", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q should be a whole number", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q should be a number", s)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q should be true or false", s)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("settings of type %s aren't supported", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, _ := m.MarshalText()
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Loader loads the configuration with the flags of a flag set.
+type Loader struct {
+	path  string
+	flags []flagSetting
+}
+
+type flagSetting struct {
+	name  string
+	value string
+}
+
+// Flags adds -config and a flag per setting to fs. The flags given are
+// kept aside for Load to apply them last.
+func Flags(fs *flag.FlagSet) *Loader {
+	l := &Loader{}
+	fs.StringVar(&l.path, "config", "", "YAML or TOML config file, CONFIG_FILE when empty")
+	def := Default()
+	for _, s := range settings(&def) {
+		usage := s.usage
+		if s.env != "" {
+			usage += ", " + s.env + " when not given"
+		}
+		fs.Var(&flagValue{l: l, name: s.name, def: format(s.value), bool: s.value.Kind() == reflect.Bool}, s.name, usage)
+	}
+	return l
+}
+
+// flagValue records the value of a flag once it parses.
+type flagValue struct {
+	l    *Loader
+	name string
+	def  string
+	bool bool
+}
+
+// IsBoolFlag lets boolean settings be given as -features.grpc or
+// -features.grpc=false, as flag.Bool does.
+func (f *flagValue) IsBoolFlag() bool {
+	return f.bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(s string) error {
+	c := Default()
+	v, _ := find(settings(&c), f.name)
+	if err := set(v.value, s); err != nil {
+		return err
+	}
+	f.l.flags = append(f.l.flags, flagSetting{f.name, s})
+	return nil
+}
+
+// Load merges the defaults, the config file, the variables getenv returns
+// and the flags, and validates the result.
+func (l *Loader) Load(getenv func(string) string) (*Config, error) {
+	c := Default()
+	path := l.path
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := readFile(path, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	ss := settings(&c)
+	for _, s := range ss {
+		if s.env == "" {
+			continue
+		}
+		if env := getenv(s.env); env != "" {
+			if err := set(s.value, env); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, f := range l.flags {
+		s, _ := find(ss, f.name)
+		if err := set(s.value, f.value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", f.name, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// readFile sets the settings of the file at path on c, a YAML or TOML file
+// as told by its extension. Unknown settings are errors, they are most
+// likely misspelled.
+func readFile(path string, c *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if keys := md.Undecoded(); len(keys) > 0 {
+			return fmt.Errorf("%s: unknown settings %v", path, keys)
+		}
+	default:
+		return fmt.Errorf("config file %s should end with .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Redacted is c with its secrets hidden.
+func (c Config) Redacted() Config {
+	for _, s := range settings(&c) {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString(redact(s.value.String()))
+		}
+	}
+	return c
+}
+
+var dsnPassword = regexp.MustCompile(`password=('(\\.|[^'])*'|\S+)`)
+
+const redacted = "xxxxx"
+
+// queryPassword matches the password and sslpassword parameters of a URL
+// query, which lib/pq takes as well as the userinfo password.
+var queryPassword = regexp.MustCompile(`(^|&)((ssl)?password)=[^&]*`)
+
+// redact hides the passwords of a connection string, or all of a secret
+// that isn't one.
+func redact(s string) string {
+	if u, err := url.Parse(s); err == nil && u.Scheme != "" {
+		u.RawQuery = queryPassword.ReplaceAllString(u.RawQuery, "${1}${2}="+redacted)
+		return u.Redacted()
+	}
+	if strings.Contains(s, "=") {
+		return dsnPassword.ReplaceAllString(s, "password="+redacted)
+	}
+	return redacted
+}
+
+// LogValue logs c as its settings, with their secrets hidden.
+func (c Config) LogValue() slog.Value {
+	r := c.Redacted()
+	attrs := []slog.Attr{}
+	for _, s := range settings(&r) {
+		attrs = append(attrs, slog.String(s.name, format(s.value)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Write writes c as YAML with its secrets hidden, a file Load reads back
+// once the secrets are filled in.
+func (c Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
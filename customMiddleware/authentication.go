@@ -1,10 +1,17 @@
 package customMiddleware
 
-import "github.com/labstack/echo/v4"
+import (
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+)
 
+// Authentication checks the credentials against the users table and stores
+// the authenticated user on the context for the policy checks in package expense.
 func Authentication(username, password string, c echo.Context) (bool, error) {
-	if username == "admin" && password == "admin" {
-		return true, nil
+	u, err := expense.Authenticate(username, password)
+	if err != nil || u == nil {
+		return false, err
 	}
-	return false, nil
+	expense.SetCurrentUser(c, *u)
+	return true, nil
 }
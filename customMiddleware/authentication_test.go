@@ -6,13 +6,34 @@ import (
 	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Suvisuttikasame/assessment/expense"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/stretchr/testify/assert"
 )
 
+// adminHash is the bcrypt hash of "admin".
+const adminHash = "$2a$10$I1OwJXQ0A3CW6nW9Tnk8RuKgTBABC.nQb627K0Uqr/ogvy/tk.T0W"
+
+func mockAdmin(t *testing.T) {
+	var mock sqlmock.Sqlmock
+	var err error
+	expense.Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	t.Cleanup(func() { expense.Db.Close() })
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM users u`)).
+		WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "password", "role", "groups", "group_roles", "group_ids"}).
+			AddRow("admin", adminHash, "admin", "{}", "{}", "{}"))
+}
+
 func TestAuthentication(t *testing.T) {
 	e := echo.New()
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -23,14 +44,17 @@ func TestAuthentication(t *testing.T) {
 	})
 
 	t.Run("should return nil when username = admin & password = admin", func(t *testing.T) {
+		mockAdmin(t)
 		auth := "Basic" + " " + base64.StdEncoding.EncodeToString([]byte("admin:admin"))
 		req.Header.Set(echo.HeaderAuthorization, auth)
 		err := h(c)
 
 		assert.Nil(t, err)
+		assert.Equal(t, expense.RoleAdmin, expense.CurrentUser(c).Role)
 	})
 
 	t.Run("should return Unauthorized when username = admin & password = wrongpassword", func(t *testing.T) {
+		mockAdmin(t)
 		auth := "Basic" + " " + base64.StdEncoding.EncodeToString([]byte("admin:wrongpassword"))
 		req.Header.Set(echo.HeaderAuthorization, auth)
 		he := h(c).(*echo.HTTPError)
@@ -0,0 +1,39 @@
+package customMiddleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderDeprecation = "Deprecation"
+	HeaderSunset      = "Sunset"
+)
+
+// Deprecated marks the responses of routes that are going away: Deprecation
+// says so, Sunset (RFC 8594) says when they stop answering and Link points
+// to the same path under successor, the prefix of the version replacing
+// them. From sunset on the routes answer 410 Gone.
+func Deprecated(sunset time.Time, successor string) echo.MiddlewareFunc {
+	return deprecated(sunset, successor, time.Now)
+}
+
+func deprecated(sunset time.Time, successor string, now func() time.Time) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			h := c.Response().Header()
+			h.Set(HeaderDeprecation, "true")
+			if !sunset.IsZero() {
+				h.Set(HeaderSunset, sunset.UTC().Format(http.TimeFormat))
+			}
+			h.Add("Link", "<"+successor+c.Request().URL.Path+`>; rel="successor-version"`)
+			if !sunset.IsZero() && !now().Before(sunset) {
+				return &expense.Err{Status: http.StatusGone, Message: "this route was sunset on " + sunset.UTC().Format(time.DateOnly) + ", use " + successor + c.Request().URL.Path}
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecated(t *testing.T) {
+	sunset := time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+	get := func(now time.Time) *httptest.ResponseRecorder {
+		e := echo.New()
+		e.HTTPErrorHandler = expense.HTTPErrorHandler
+		e.GET("/expenses", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, []int{})
+		}, deprecated(sunset, "/v1", func() time.Time { return now }))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expenses", nil))
+		return rec
+	}
+
+	t.Run("should answer before the sunset", func(t *testing.T) {
+		rec := get(sunset.Add(-time.Second))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "true", rec.Header().Get(HeaderDeprecation))
+		assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", rec.Header().Get(HeaderSunset))
+		assert.Equal(t, `</v1/expenses>; rel="successor-version"`, rec.Header().Get("Link"))
+	})
+
+	t.Run("should answer 410 from the sunset on", func(t *testing.T) {
+		rec := get(sunset)
+		var r expense.Err
+
+		json.NewDecoder(rec.Body).Decode(&r)
+
+		assert.Equal(t, http.StatusGone, rec.Code)
+		assert.Equal(t, "this route was sunset on 2027-04-30, use /v1/expenses", r.Message)
+		assert.Equal(t, `</v1/expenses>; rel="successor-version"`, rec.Header().Get("Link"))
+	})
+}
@@ -0,0 +1,92 @@
+package customMiddleware
+
+import (
+	"context"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/random"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryAuth authenticates unary gRPC calls with validator, the one given to
+// the BasicAuth middleware, from the Basic credentials in their
+// authorization metadata.
+func UnaryAuth(validator middleware.BasicAuthValidator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, info.FullMethod, validator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth is UnaryAuth for streaming calls.
+func StreamAuth(validator middleware.BasicAuthValidator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), info.FullMethod, validator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ss, ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate returns ctx carrying the call context of the authenticated
+// user.
+func authenticate(ctx context.Context, method string, validator middleware.BasicAuthValidator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c := expense.NewCallContext(ctx, method, requestId(md))
+
+	username, password, ok := basicCredentials(first(md.Get(echo.HeaderAuthorization)))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "basic credentials are missing from the authorization metadata")
+	}
+	valid, err := validator(username, password, c)
+	if err != nil {
+		return nil, callError(ctx, c, err)
+	}
+	if !valid {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	return expense.WithCallContext(ctx, c), nil
+}
+
+// requestId is the x-request-id of md, or one made up as the RequestID
+// middleware does.
+func requestId(md metadata.MD) string {
+	if id := first(md.Get(echo.HeaderXRequestID)); id != "" {
+		return id
+	}
+	return random.String(32)
+}
+
+// callError converts err to a gRPC status, passing on the Retry-After header
+// set on c as metadata.
+func callError(ctx context.Context, c echo.Context, err error) error {
+	if wait := c.Response().Header().Get(echo.HeaderRetryAfter); wait != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(echo.HeaderRetryAfter, wait))
+	}
+	return expense.GRPCError(c, err)
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,169 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func basicMetadata(username, password string) context.Context {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", auth, "x-request-id", "abc"))
+}
+
+func TestUnaryAuth(t *testing.T) {
+	var authenticated echo.Context
+	validator := func(username, password string, c echo.Context) (bool, error) {
+		ok, err := Authentication(username, password, c)
+		authenticated = c
+		return ok, err
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/expense.v1.ExpenseService/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "test", nil
+	}
+
+	t.Run("should call the handler as admin when username = admin & password = admin", func(t *testing.T) {
+		mockAdmin(t)
+
+		res, err := UnaryAuth(validator)(basicMetadata("admin", "admin"), nil, info, handler)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "test", res)
+		assert.Equal(t, expense.RoleAdmin, expense.CurrentUser(authenticated).Role)
+		assert.Equal(t, "abc", authenticated.Request().Header.Get(echo.HeaderXRequestID))
+	})
+
+	t.Run("should return Unauthenticated when username = admin & password = wrongpassword", func(t *testing.T) {
+		mockAdmin(t)
+
+		_, err := UnaryAuth(validator)(basicMetadata("admin", "wrongpassword"), nil, info, handler)
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("should return Unauthenticated without credentials", func(t *testing.T) {
+		_, err := UnaryAuth(validator)(context.Background(), nil, info, handler)
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestStreamAuthLockout(t *testing.T) {
+	g := NewMemoryGuard(Lockout{MaxFailures: 1, Base: time.Minute, Max: time.Minute})
+	info := &grpc.StreamServerInfo{FullMethod: "/expense.v1.ExpenseService/List"}
+	called := false
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		called = true
+		return nil
+	}
+	mockAdmin(t)
+	StreamAuth(Authenticator(g))(nil, &authenticatedStream{ctx: basicMetadata("admin", "wrongpassword")}, info, handler)
+
+	err := StreamAuth(Authenticator(g))(nil, &authenticatedStream{ctx: basicMetadata("admin", "admin")}, info, handler)
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "too many failed logins", status.Convert(err).Message())
+	assert.False(t, called)
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	l := NewMemoryLimiter(1, 1)
+	info := &grpc.UnaryServerInfo{FullMethod: "/expense.v1.ExpenseService/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "test", nil
+	}
+	call := func(ip string, u expense.User) (interface{}, error) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 5000}})
+		c := expense.NewCallContext(ctx, info.FullMethod, "abc")
+		if u.Username != "" {
+			expense.SetCurrentUser(c, u)
+		}
+		return UnaryUserRateLimit(l)(expense.WithCallContext(ctx, c), nil, info, handler)
+	}
+
+	t.Run("should key the peer bucket by the address of the peer", func(t *testing.T) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+
+		_, first := UnaryRateLimit(l)(ctx, nil, info, handler)
+		_, second := UnaryRateLimit(l)(ctx, nil, info, handler)
+
+		assert.Nil(t, first)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(second))
+		assert.Equal(t, "rate limit exceeded", status.Convert(second).Message())
+	})
+
+	t.Run("should not count unauthenticated calls against the user", func(t *testing.T) {
+		_, err := call("10.0.0.2", expense.User{})
+		assert.Nil(t, err)
+
+		res, err := call("10.0.0.2", expense.User{Username: "alice"})
+		assert.Nil(t, err)
+		assert.Equal(t, "test", res)
+	})
+
+	t.Run("should return ResourceExhausted once the user has used up its bucket, from any peer", func(t *testing.T) {
+		_, err := call("10.0.0.3", expense.User{Username: "alice"})
+
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
+func TestGRPCRecover(t *testing.T) {
+	out := &bytes.Buffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, nil)))
+
+	t.Run("should answer a panic of a unary call with Internal", func(t *testing.T) {
+		out.Reset()
+		info := &grpc.UnaryServerInfo{FullMethod: "/expense.v1.ExpenseService/Get"}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			panic("nil map")
+		}
+
+		_, err := UnaryRecover()(basicMetadata("admin", "admin"), nil, info, handler)
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Equal(t, "the server couldn't process the request", status.Convert(err).Message())
+		lines := logLines(t, out)
+		if assert.Len(t, lines, 1) {
+			assert.Equal(t, "panic", lines[0]["msg"])
+			assert.Equal(t, "nil map", lines[0]["error"])
+			assert.Equal(t, "abc", lines[0]["request_id"])
+			assert.Equal(t, info.FullMethod, lines[0]["rpc"])
+			assert.Contains(t, lines[0]["stack"], "grpcRecover.go")
+		}
+	})
+
+	t.Run("should answer a panic of a streaming call with Internal", func(t *testing.T) {
+		out.Reset()
+		info := &grpc.StreamServerInfo{FullMethod: "/expense.v1.ExpenseService/List"}
+		handler := func(srv interface{}, ss grpc.ServerStream) error {
+			panic(errors.New("closed stream"))
+		}
+
+		err := StreamRecover()(nil, &authenticatedStream{ctx: basicMetadata("admin", "admin")}, info, handler)
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+		lines := logLines(t, out)
+		if assert.Len(t, lines, 1) {
+			assert.Equal(t, "closed stream", lines[0]["error"])
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package customMiddleware
+
+import (
+	"context"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryRateLimit is RateLimit for unary gRPC calls, keyed by the address of
+// the peer. It goes before UnaryAuth, so that guessing passwords over gRPC is
+// throttled as it is over HTTP.
+func UnaryRateLimit(l Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := allowPeer(ctx, info.FullMethod, l); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimit is UnaryRateLimit for streaming calls.
+func StreamRateLimit(l Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allowPeer(ss.Context(), info.FullMethod, l); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryUserRateLimit is UserRateLimit for unary gRPC calls. It goes after
+// UnaryAuth, which stores the call context of the user.
+func UnaryUserRateLimit(l Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := allowUser(ctx, l); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamUserRateLimit is UnaryUserRateLimit for streaming calls.
+func StreamUserRateLimit(l Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allowUser(ss.Context(), l); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func allowPeer(ctx context.Context, method string, l Limiter) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	c := expense.NewCallContext(ctx, method, requestId(md))
+	if err := allow(c, l, "ip:"+c.RealIP()); err != nil {
+		return callError(ctx, c, err)
+	}
+	return nil
+}
+
+func allowUser(ctx context.Context, l Limiter) error {
+	c := expense.CallContext(ctx)
+	if username := expense.CurrentUser(c).Username; username != "" {
+		if err := allow(c, l, "user:"+username); err != nil {
+			return callError(ctx, c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package customMiddleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryRecover answers a unary gRPC call that panics with an Internal status,
+// logging the panic with LogPanic as middleware.Recover does for HTTP
+// requests. It goes first, so that it covers the other interceptors too.
+func UnaryRecover() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ctx, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecover is UnaryRecover for streaming calls.
+func StreamRecover() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// recovered logs the panic r of a call of method and returns the status the
+// call is answered with, which leaves the cause out.
+func recovered(ctx context.Context, method string, r interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	LogPanic(expense.NewCallContext(ctx, method, requestId(md)), err, debug.Stack())
+	return status.Error(codes.Internal, "the server couldn't process the request")
+}
@@ -0,0 +1,289 @@
+package customMiddleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	DefaultIdempotencyTTL    = 24 * time.Hour
+	maxIdempotencyKeyLength  = 255
+)
+
+var (
+	ErrIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyInProgress = errors.New("idempotency key is in use by a request in progress")
+)
+
+// StoredResponse is the response kept for an idempotency key.
+type StoredResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyStore keeps the responses of requests by idempotency key.
+type IdempotencyStore interface {
+	// Begin reserves key for a request with fingerprint until ttl passes. It
+	// returns the stored response when a request with key already completed.
+	Begin(key, fingerprint string, ttl time.Duration) (*StoredResponse, error)
+	Complete(key string, res StoredResponse) error
+	// Release drops the reservation of a request that failed, so it can be retried.
+	Release(key string) error
+}
+
+type idempotencyRecord struct {
+	fingerprint string
+	res         *StoredResponse
+	expiresAt   time.Time
+}
+
+// MemoryIdempotencyStore keeps the keys in process, they are per replica.
+type MemoryIdempotencyStore struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]*idempotencyRecord
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{now: time.Now, keys: map[string]*idempotencyRecord{}}
+}
+
+func (s *MemoryIdempotencyStore) Begin(key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	r, ok := s.keys[key]
+	if !ok || !r.expiresAt.After(now) {
+		s.keys[key] = &idempotencyRecord{fingerprint: fingerprint, expiresAt: now.Add(ttl)}
+		return nil, nil
+	}
+	return existing(r.fingerprint, fingerprint, r.res)
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, res StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.keys[key]; ok {
+		r.res = &res
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+// ExpireKeys drops the expired keys every interval until ctx is done. Begin
+// ignores an expired key already, this only frees its memory.
+func (s *MemoryIdempotencyStore) ExpireKeys(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		s.expire()
+	}
+}
+
+func (s *MemoryIdempotencyStore) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, r := range s.keys {
+		if !r.expiresAt.After(now) {
+			delete(s.keys, k)
+		}
+	}
+}
+
+// existing decides what to do with a request whose key is already stored.
+func existing(stored, fingerprint string, res *StoredResponse) (*StoredResponse, error) {
+	if stored != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if res == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+	return res, nil
+}
+
+// PostgresIdempotencyStore keeps the keys in the idempotency_keys table so
+// that retries are recognised by every replica.
+type PostgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresIdempotencyStore(db *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+func (s *PostgresIdempotencyStore) Begin(key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	if _, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= now()`); err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.Exec(`INSERT INTO idempotency_keys (key, fingerprint, expires_at)
+		VALUES ($1, $2, now() + $3 * INTERVAL '1 second') ON CONFLICT (key) DO NOTHING`, key, fingerprint, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, nil
+	}
+
+	var stored string
+	var status int
+	sr := StoredResponse{}
+	err = s.db.QueryRow(`SELECT fingerprint, status, content_type, body FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&stored, &status, &sr.ContentType, &sr.Body)
+	if err != nil {
+		return nil, err
+	}
+	if status == 0 {
+		return existing(stored, fingerprint, nil)
+	}
+	sr.Status = status
+	return existing(stored, fingerprint, &sr)
+}
+
+func (s *PostgresIdempotencyStore) Complete(key string, res StoredResponse) error {
+	_, err := s.db.Exec(`UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4 WHERE key = $1`,
+		key, res.Status, res.ContentType, res.Body)
+	return err
+}
+
+func (s *PostgresIdempotencyStore) Release(key string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1`, key)
+	return err
+}
+
+// Idempotency honors the Idempotency-Key header: the response to the first
+// request with a key is stored for ttl and replayed to its retries. Keys are
+// scoped to the authenticated user. A retry with a different body gets 422,
+// one arriving while the first is still running gets 409. Responses with a
+// 5xx status are not stored, so the request can be retried.
+func Idempotency(store IdempotencyStore, ttl time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(HeaderIdempotencyKey)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return &expense.Err{Status: http.StatusBadRequest, Message: "Idempotency-Key should not be longer than 255 characters"}
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return err
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+			key = expense.CurrentUser(c).Username + ":" + key
+
+			stored, err := store.Begin(key, fingerprint(c, body), ttl)
+			switch err {
+			case nil:
+			case ErrIdempotencyMismatch:
+				return &expense.Err{Status: http.StatusUnprocessableEntity, Message: "Idempotency-Key was already used with a different request"}
+			case ErrIdempotencyInProgress:
+				return &expense.Err{Status: http.StatusConflict, Message: "a request with this Idempotency-Key is in progress"}
+			default:
+				return err
+			}
+			if stored != nil {
+				c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+				return c.Blob(stored.Status, stored.ContentType, stored.Body)
+			}
+
+			// a panicking handler answers nothing, let the request be retried
+			defer func() {
+				if r := recover(); r != nil {
+					release(c, store, key)
+					panic(r)
+				}
+			}()
+
+			rec := &recorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			if err = next(c); err != nil {
+				// answer the error here, so that a 4xx is stored like any
+				// other response
+				c.Error(err)
+			}
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError || !c.Response().Committed {
+				release(c, store, key)
+				return nil
+			}
+			// the response is already sent, a failure to store it only costs the replay
+			err = store.Complete(key, StoredResponse{
+				Status:      status,
+				ContentType: c.Response().Header().Get(echo.HeaderContentType),
+				Body:        rec.body.Bytes(),
+			})
+			if err != nil {
+				expense.Logger(c).Error("unable to store idempotent response", expense.ErrorAttrs(err)...)
+			}
+			return nil
+		}
+	}
+}
+
+func release(c echo.Context, store IdempotencyStore, key string) {
+	if err := store.Release(key); err != nil {
+		expense.Logger(c).Error("unable to release idempotency key", expense.ErrorAttrs(err)...)
+	}
+}
+
+// versionPrefix is the prefix of a versioned route.
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+/`)
+
+// fingerprint identifies a request by its method, route, path parameters and
+// body. The route is taken without its version prefix: the routes without
+// one are aliases of a version, a retry may go to either.
+func fingerprint(c echo.Context, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Request().Method + " " + versionPrefix.ReplaceAllString(c.Path(), "/") + "\n"))
+	values := c.ParamValues()
+	for i, name := range c.ParamNames() {
+		if i < len(values) {
+			h.Write([]byte(name + "=" + values[i] + "\n"))
+		}
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder keeps a copy of the body written through it.
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,238 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	status := http.StatusCreated
+	store := NewMemoryIdempotencyStore()
+	h := Idempotency(store, time.Hour)(func(c echo.Context) error {
+		calls++
+		return c.JSON(status, map[string]int{"id": calls})
+	})
+	post := func(key, body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(HeaderIdempotencyKey, key)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		expense.SetCurrentUser(c, expense.User{Username: "alice", Role: expense.RoleEditor})
+		if err := h(c); err != nil {
+			expense.HTTPErrorHandler(err, c)
+		}
+		return rec
+	}
+
+	t.Run("should run the handler on the first request", func(t *testing.T) {
+		rec := post("k1", `{"title":"a"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, `{"id":1}`, rec.Body.String())
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should replay the stored response to a retry", func(t *testing.T) {
+		rec := post("k1", `{"title":"a"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, `{"id":1}`, rec.Body.String())
+		assert.Equal(t, "true", rec.Header().Get(HeaderIdempotentReplayed))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should return 422 when the key comes with a different body", func(t *testing.T) {
+		rec := post("k1", `{"title":"b"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should not store a server error", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		post("k2", `{"title":"a"}`)
+		status = http.StatusCreated
+		rec := post("k2", `{"title":"a"}`)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("should pass requests without a key", func(t *testing.T) {
+		post("", `{"title":"a"}`)
+		post("", `{"title":"a"}`)
+
+		assert.Equal(t, 5, calls)
+	})
+
+	t.Run("should store a client error returned by the handler", func(t *testing.T) {
+		h := Idempotency(store, time.Hour)(func(c echo.Context) error {
+			calls++
+			return &expense.Err{Status: http.StatusBadRequest, Message: "title error : this field should not empty."}
+		})
+		for i := 0; i < 2; i++ {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{}`))
+			req.Header.Set(HeaderIdempotencyKey, "k3")
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			expense.SetCurrentUser(c, expense.User{Username: "alice", Role: expense.RoleEditor})
+			e.HTTPErrorHandler = expense.HTTPErrorHandler
+
+			assert.Nil(t, h(c))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, expense.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		}
+		assert.Equal(t, 6, calls)
+	})
+}
+
+// failingStore fails to store responses.
+type failingStore struct {
+	*MemoryIdempotencyStore
+}
+
+func (failingStore) Complete(key string, res StoredResponse) error {
+	return errors.New("pq: connection refused")
+}
+
+func TestIdempotencyLogsStoreErrors(t *testing.T) {
+	//arrange
+	out := &bytes.Buffer{}
+	e := loggedEcho(out)
+	e.POST("/expenses", func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, map[string]int{"id": 1})
+	}, Idempotency(failingStore{NewMemoryIdempotencyStore()}, time.Hour))
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{"title":"a"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	req.Header.Set(HeaderIdempotencyKey, "k1")
+	rec := httptest.NewRecorder()
+
+	//action
+	e.ServeHTTP(rec, req)
+
+	//assert
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	lines := logLines(t, out)
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, "ERROR", lines[0]["level"])
+		assert.Equal(t, "unable to store idempotent response", lines[0]["msg"])
+		assert.Equal(t, "pq: connection refused", lines[0]["error"])
+		assert.Equal(t, "req-1", lines[0]["request_id"])
+	}
+}
+
+func TestIdempotencyReleasesOnPanic(t *testing.T) {
+	//arrange
+	calls := 0
+	h := Idempotency(NewMemoryIdempotencyStore(), time.Hour)(func(c echo.Context) error {
+		calls++
+		if calls == 1 {
+			panic("nil map")
+		}
+		return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+	})
+	post := func() (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{"title":"a"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(HeaderIdempotencyKey, "k1")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		expense.SetCurrentUser(c, expense.User{Username: "alice", Role: expense.RoleEditor})
+		return rec, h(c)
+	}
+
+	//action
+	assert.PanicsWithValue(t, "nil map", func() { post() })
+	rec, err := post()
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestFingerprint(t *testing.T) {
+	request := func(route, id, body string) string {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+		c.SetPath(route)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		return fingerprint(c, []byte(body))
+	}
+
+	assert.Equal(t, request("/v1/expenses/:id/approve", "1", "{}"), request("/expenses/:id/approve", "1", "{}"))
+	assert.NotEqual(t, request("/v1/expenses/:id/approve", "1", "{}"), request("/v1/expenses/:id/approve", "2", "{}"))
+	assert.NotEqual(t, request("/v1/expenses/:id/approve", "1", "{}"), request("/v1/expenses/:id/reject", "1", "{}"))
+}
+
+func TestMemoryIdempotencyStoreExpireKeys(t *testing.T) {
+	clk := &clock{t: time.Unix(0, 0)}
+	s := NewMemoryIdempotencyStore()
+	s.now = clk.now
+	s.Begin("old", "f", time.Minute)
+	s.Begin("new", "f", time.Hour)
+
+	clk.t = clk.t.Add(time.Minute)
+	s.expire()
+
+	assert.Len(t, s.keys, 1)
+	assert.Contains(t, s.keys, "new")
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	clk := &clock{t: time.Unix(0, 0)}
+	s := NewMemoryIdempotencyStore()
+	s.now = clk.now
+
+	s.Begin("k", "f1", time.Minute)
+	s.Complete("k", StoredResponse{Status: http.StatusCreated})
+	_, err := s.Begin("k", "f2", time.Minute)
+	assert.Equal(t, ErrIdempotencyMismatch, err)
+
+	clk.t = clk.t.Add(time.Minute)
+	res, err := s.Begin("k", "f2", time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+}
+
+func TestPostgresIdempotencyStoreInProgress(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM idempotency_keys WHERE expires_at <= now()`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO idempotency_keys`)).
+		WithArgs("alice:k", "f", float64(3600)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT fingerprint, status, content_type, body FROM idempotency_keys WHERE key = $1`)).
+		WithArgs("alice:k").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "status", "content_type", "body"}).AddRow("f", 0, "", []byte{}))
+
+	_, err = NewPostgresIdempotencyStore(db).Begin("alice:k", "f", time.Hour)
+
+	assert.Equal(t, ErrIdempotencyInProgress, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
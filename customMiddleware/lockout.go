@@ -0,0 +1,175 @@
+package customMiddleware
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Lockout locks a credential out after MaxFailures failed logins in a row.
+// Every further failure doubles the lock, starting at Base and capped at Max.
+type Lockout struct {
+	MaxFailures int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+func (l Lockout) duration(failures int) time.Duration {
+	if failures < l.MaxFailures {
+		return 0
+	}
+	d := l.Base
+	for i := l.MaxFailures; i < failures && d < l.Max; i++ {
+		d *= 2
+	}
+	if d > l.Max {
+		d = l.Max
+	}
+	return d
+}
+
+// LoginGuard records failed logins per key.
+type LoginGuard interface {
+	// Locked returns how long key stays locked out, zero when it isn't.
+	Locked(key string) (time.Duration, error)
+	Failed(key string) error
+	Succeeded(key string) error
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// maxGuardKeys bounds the map before the keys that are no longer locked out
+// and failed last over Lockout.Max ago are dropped.
+const maxGuardKeys = 10000
+
+// MemoryGuard keeps the failures in process, lockouts are per replica.
+type MemoryGuard struct {
+	lockout Lockout
+	now     func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]*failures
+}
+
+func NewMemoryGuard(l Lockout) *MemoryGuard {
+	return &MemoryGuard{lockout: l, now: time.Now, keys: map[string]*failures{}}
+}
+
+func (g *MemoryGuard) Locked(key string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.keys[key]; ok && f.lockedUntil.After(g.now()) {
+		return f.lockedUntil.Sub(g.now()), nil
+	}
+	return 0, nil
+}
+
+func (g *MemoryGuard) Failed(key string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if len(g.keys) > maxGuardKeys {
+		for k, f := range g.keys {
+			if !f.lockedUntil.After(now) && now.Sub(f.last) > g.lockout.Max {
+				delete(g.keys, k)
+			}
+		}
+	}
+
+	f, ok := g.keys[key]
+	if !ok {
+		f = &failures{}
+		g.keys[key] = f
+	}
+	f.count++
+	f.last = now
+	if d := g.lockout.duration(f.count); d > 0 {
+		f.lockedUntil = now.Add(d)
+	}
+	return nil
+}
+
+func (g *MemoryGuard) Succeeded(key string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.keys, key)
+	return nil
+}
+
+// PostgresGuard keeps the failures in the login_failures table so that the
+// lockout holds across replicas.
+type PostgresGuard struct {
+	db      *sql.DB
+	lockout Lockout
+}
+
+func NewPostgresGuard(db *sql.DB, l Lockout) *PostgresGuard {
+	return &PostgresGuard{db: db, lockout: l}
+}
+
+func (g *PostgresGuard) Locked(key string) (time.Duration, error) {
+	var secs float64
+	err := g.db.QueryRow(`SELECT GREATEST(EXTRACT(EPOCH FROM locked_until - now()), 0) FROM login_failures WHERE key = $1 AND locked_until IS NOT NULL`, key).Scan(&secs)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return time.Duration(secs * float64(time.Second)), err
+}
+
+func (g *PostgresGuard) Failed(key string) error {
+	var count int
+	err := g.db.QueryRow(`INSERT INTO login_failures (key, failures) VALUES ($1, 1)
+		ON CONFLICT (key) DO UPDATE SET failures = login_failures.failures + 1
+		RETURNING failures`, key).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if d := g.lockout.duration(count); d > 0 {
+		_, err = g.db.Exec(`UPDATE login_failures SET locked_until = now() + $2 * INTERVAL '1 second' WHERE key = $1`, key, d.Seconds())
+	}
+	return err
+}
+
+func (g *PostgresGuard) Succeeded(key string) error {
+	_, err := g.db.Exec(`DELETE FROM login_failures WHERE key = $1`, key)
+	return err
+}
+
+// Authenticator wraps Authentication with g. Failures are counted per
+// username and client IP, so that nobody can lock a user out from elsewhere.
+// A locked out login gets 429 without its password being checked.
+func Authenticator(g LoginGuard) middleware.BasicAuthValidator {
+	return func(username, password string, c echo.Context) (bool, error) {
+		key := loginKey(username, c)
+		wait, err := g.Locked(key)
+		if err != nil {
+			return false, err
+		}
+		if wait > 0 {
+			return false, tooManyRequests(c, wait, "too many failed logins")
+		}
+
+		ok, err := Authentication(username, password, c)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, g.Failed(key)
+		}
+		return true, g.Succeeded(key)
+	}
+}
+
+// loginKey is the key failed logins of username from the client of c are
+// counted under.
+func loginKey(username string, c echo.Context) string {
+	return "user:" + username + " ip:" + c.RealIP()
+}
@@ -0,0 +1,52 @@
+package customMiddleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestLogger gives every request a logger carrying its request ID, which
+// the handlers get with expense.Logger, and writes an access log once the
+// request is answered. It goes after middleware.RequestID.
+func RequestLogger(l *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			rl := l.With(slog.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)))
+			expense.SetLogger(c, rl)
+
+			if err := next(c); err != nil {
+				// answer the error here, so that its status is logged
+				c.Error(err)
+			}
+
+			req, res := c.Request(), c.Response()
+			level := slog.LevelInfo
+			if res.Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			rl.LogAttrs(req.Context(), level, "request",
+				slog.String("method", req.Method),
+				slog.String("path", req.URL.Path),
+				slog.String("route", c.Path()),
+				slog.Int("status", res.Status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int64("bytes_out", res.Size),
+				slog.String("remote_ip", c.RealIP()),
+				slog.String("user", expense.CurrentUser(c).Username),
+			)
+			return nil
+		}
+	}
+}
+
+// LogPanic logs a panic middleware.Recover caught with its stack, as its
+// LogErrorFunc.
+func LogPanic(c echo.Context, err error, stack []byte) error {
+	expense.Logger(c).Error("panic", slog.String("error", err.Error()), slog.String("stack", string(stack)))
+	return err
+}
@@ -0,0 +1,123 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+// logLines decodes the JSON lines logged to out.
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	lines := []map[string]interface{}{}
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		line := map[string]interface{}{}
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal("unable to decode the log", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func loggedEcho(out *bytes.Buffer) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = expense.HTTPErrorHandler
+	e.Use(middleware.RequestID())
+	e.Use(RequestLogger(slog.New(slog.NewJSONHandler(out, nil))))
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{LogErrorFunc: LogPanic}))
+	return e
+}
+
+func TestRequestLogger(t *testing.T) {
+	t.Run("should log the request with its status and latency", func(t *testing.T) {
+		//arrange
+		out := &bytes.Buffer{}
+		e := loggedEcho(out)
+		e.GET("/expenses/:id", func(c echo.Context) error {
+			expense.Logger(c).Debug("not logged at info")
+			expense.Logger(c).Info("reading expense", slog.String("id", c.Param("id")))
+			return c.String(http.StatusOK, "ok")
+		})
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+		req.Header.Set(echo.HeaderXRequestID, "req-1")
+		rec := httptest.NewRecorder()
+
+		//action
+		e.ServeHTTP(rec, req)
+
+		//assert
+		lines := logLines(t, out)
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, "reading expense", lines[0]["msg"])
+			assert.Equal(t, "req-1", lines[0]["request_id"])
+			assert.Equal(t, "INFO", lines[1]["level"])
+			assert.Equal(t, "request", lines[1]["msg"])
+			assert.Equal(t, "req-1", lines[1]["request_id"])
+			assert.Equal(t, "GET", lines[1]["method"])
+			assert.Equal(t, "/expenses/1", lines[1]["path"])
+			assert.Equal(t, "/expenses/:id", lines[1]["route"])
+			assert.Equal(t, float64(http.StatusOK), lines[1]["status"])
+			assert.Equal(t, float64(2), lines[1]["bytes_out"])
+			assert.Contains(t, lines[1], "latency_ms")
+		}
+	})
+
+	t.Run("should log server errors with their cause", func(t *testing.T) {
+		//arrange
+		out := &bytes.Buffer{}
+		e := loggedEcho(out)
+		e.GET("/expenses", func(c echo.Context) error {
+			return &expense.Err{Status: http.StatusInternalServerError, Message: "unable to query expenses", Internal: errors.New("pq: connection refused")}
+		})
+		rec := httptest.NewRecorder()
+
+		//action
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expenses", nil))
+
+		//assert
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		lines := logLines(t, out)
+		if assert.Len(t, lines, 2) {
+			assert.Equal(t, "unable to query expenses", lines[0]["msg"])
+			assert.Equal(t, "pq: connection refused", lines[0]["error"])
+			assert.Equal(t, rec.Header().Get(echo.HeaderXRequestID), lines[0]["request_id"])
+			assert.Equal(t, "ERROR", lines[1]["level"])
+			assert.Equal(t, float64(http.StatusInternalServerError), lines[1]["status"])
+		}
+	})
+
+	t.Run("should log panics with their stack", func(t *testing.T) {
+		//arrange
+		out := &bytes.Buffer{}
+		e := loggedEcho(out)
+		e.GET("/expenses", func(c echo.Context) error {
+			panic("boom")
+		})
+		rec := httptest.NewRecorder()
+
+		//action
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/expenses", nil))
+
+		//assert
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		lines := logLines(t, out)
+		if assert.NotEmpty(t, lines) {
+			assert.Equal(t, "panic", lines[0]["msg"])
+			assert.Equal(t, "boom", lines[0]["error"])
+			assert.Contains(t, lines[0]["stack"], "logging_test.go")
+			assert.Equal(t, float64(http.StatusInternalServerError), lines[len(lines)-1]["status"])
+		}
+	})
+}
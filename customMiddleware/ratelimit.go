@@ -0,0 +1,203 @@
+package customMiddleware
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+)
+
+// Limiter is a token bucket per key.
+type Limiter interface {
+	// Allow takes a token from the bucket of key. When the bucket is empty it
+	// returns false and how long until the next token is available.
+	Allow(key string) (bool, time.Duration, error)
+}
+
+// bucket refills at rate tokens per second up to burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// MemoryLimiter keeps the buckets in process, limits are per replica.
+type MemoryLimiter struct {
+	rate, burst float64
+	now         func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryLimiter(rate float64, burst int) *MemoryLimiter {
+	return &MemoryLimiter{rate: rate, burst: float64(burst), now: time.Now, buckets: map[string]*bucket{}}
+}
+
+// maxIdleBuckets bounds the map before full buckets, which carry no state,
+// are dropped.
+const maxIdleBuckets = 10000
+
+func (l *MemoryLimiter) Allow(key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.buckets) > maxIdleBuckets {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	ok, wait := b.take(now, l.rate, l.burst)
+	return ok, wait, nil
+}
+
+// PostgresLimiter keeps the buckets in the rate_limits table so that the limit
+// holds across replicas. Time is taken from the database clock.
+type PostgresLimiter struct {
+	db          *sql.DB
+	rate, burst float64
+}
+
+func NewPostgresLimiter(db *sql.DB, rate float64, burst int) *PostgresLimiter {
+	return &PostgresLimiter{db: db, rate: rate, burst: float64(burst)}
+}
+
+func (l *PostgresLimiter) Allow(key string) (bool, time.Duration, error) {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2, now()) ON CONFLICT (key) DO NOTHING`, key, l.burst)
+	if err != nil {
+		return false, 0, err
+	}
+
+	var elapsed float64
+	b := bucket{}
+	err = tx.QueryRow(`SELECT tokens, EXTRACT(EPOCH FROM now() - updated_at) FROM rate_limits WHERE key = $1 FOR UPDATE`, key).Scan(&b.tokens, &elapsed)
+	if err != nil {
+		return false, 0, err
+	}
+	now := time.Now()
+	b.last = now.Add(-time.Duration(elapsed * float64(time.Second)))
+	ok, wait := b.take(now, l.rate, l.burst)
+
+	_, err = tx.Exec(`UPDATE rate_limits SET tokens = $2, updated_at = now() WHERE key = $1`, key, b.tokens)
+	if err != nil {
+		return false, 0, err
+	}
+	return ok, wait, tx.Commit()
+}
+
+// RateLimit answers 429 once a client IP has used up its bucket in l.
+func RateLimit(l Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := allow(c, l, "ip:"+c.RealIP()); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// UserRateLimit answers 429 once the authenticated user has used up its
+// bucket in l. It goes after the BasicAuth middleware, so that credentials
+// nobody checked can't use up another user's bucket.
+func UserRateLimit(l Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if username := expense.CurrentUser(c).Username; username != "" {
+				if err := allow(c, l, "user:"+username); err != nil {
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// allow takes a token of key from l, or returns 429.
+func allow(c echo.Context, l Limiter, key string) error {
+	ok, wait, err := l.Allow(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return tooManyRequests(c, wait, "rate limit exceeded")
+	}
+	return nil
+}
+
+// basicCredentials reads the username and password of a Basic authorization
+// header.
+func basicCredentials(auth string) (string, string, bool) {
+	const prefix = "basic "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(b), ":")
+}
+
+func tooManyRequests(c echo.Context, wait time.Duration, message string) error {
+	c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return echo.NewHTTPError(http.StatusTooManyRequests, message)
+}
+
+// CreateTables creates the tables used by the Postgres implementations.
+func CreateTables(db *sql.DB) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS rate_limits(
+					key TEXT PRIMARY KEY,
+					tokens FLOAT NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS login_failures(
+					key TEXT PRIMARY KEY,
+					failures INT NOT NULL,
+					locked_until TIMESTAMPTZ)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys(
+					key TEXT PRIMARY KEY,
+					fingerprint TEXT NOT NULL,
+					status INT NOT NULL DEFAULT 0,
+					content_type TEXT NOT NULL DEFAULT '',
+					body BYTEA NOT NULL DEFAULT '',
+					expires_at TIMESTAMPTZ NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
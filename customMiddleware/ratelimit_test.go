@@ -0,0 +1,231 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestMemoryLimiter(t *testing.T) {
+	clk := &clock{t: time.Unix(0, 0)}
+	l := NewMemoryLimiter(1, 2)
+	l.now = clk.now
+
+	t.Run("should allow a burst then refuse with the time to the next token", func(t *testing.T) {
+		ok, _, _ := l.Allow("a")
+		assert.True(t, ok)
+		ok, _, _ = l.Allow("a")
+		assert.True(t, ok)
+		ok, wait, err := l.Allow("a")
+
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, wait)
+	})
+
+	t.Run("should keep keys apart", func(t *testing.T) {
+		ok, _, _ := l.Allow("b")
+		assert.True(t, ok)
+	})
+
+	t.Run("should refill over time", func(t *testing.T) {
+		clk.t = clk.t.Add(time.Second)
+		ok, _, _ := l.Allow("a")
+		assert.True(t, ok)
+	})
+}
+
+func TestPostgresLimiter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO rate_limits`)).WithArgs("ip:1.2.3.4", float64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT tokens, EXTRACT(EPOCH FROM now() - updated_at) FROM rate_limits WHERE key = $1 FOR UPDATE`)).
+		WithArgs("ip:1.2.3.4").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens", "elapsed"}).AddRow(0.25, 0.25))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE rate_limits SET tokens = $2, updated_at = now() WHERE key = $1`)).
+		WithArgs("ip:1.2.3.4", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ok, wait, err := NewPostgresLimiter(db, 1, 2).Allow("ip:1.2.3.4")
+
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.InDelta(t, 500*time.Millisecond, wait, float64(time.Millisecond))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRateLimit(t *testing.T) {
+	e := echo.New()
+	h := RateLimit(NewMemoryLimiter(1, 1))(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+	request := func(username string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.1")
+		if username != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":x")))
+		}
+		rec := httptest.NewRecorder()
+		return rec, h(e.NewContext(req, rec))
+	}
+
+	t.Run("should pass the first request", func(t *testing.T) {
+		_, err := request("")
+		assert.Nil(t, err)
+	})
+
+	t.Run("should return 429 with Retry-After once the bucket is empty", func(t *testing.T) {
+		rec, err := request("")
+		he := err.(*echo.HTTPError)
+
+		assert.Equal(t, http.StatusTooManyRequests, he.Code)
+		assert.Equal(t, "1", rec.Header().Get(echo.HeaderRetryAfter))
+	})
+
+	t.Run("should still count the IP when credentials are sent", func(t *testing.T) {
+		_, err := request("alice")
+		he := err.(*echo.HTTPError)
+
+		assert.Equal(t, http.StatusTooManyRequests, he.Code)
+	})
+}
+
+func TestUserRateLimit(t *testing.T) {
+	l := NewMemoryLimiter(1, 1)
+	h := RateLimit(l)(UserRateLimit(l)(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	}))
+	request := func(ip string, u expense.User) error {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderXRealIP, ip)
+		// credentials nobody checked don't count against the user
+		req.Header.Set(echo.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:x")))
+		c := e.NewContext(req, httptest.NewRecorder())
+		if u.Username != "" {
+			expense.SetCurrentUser(c, u)
+		}
+		return h(c)
+	}
+
+	t.Run("should not count unauthenticated requests against the user", func(t *testing.T) {
+		assert.Nil(t, request("10.0.0.1", expense.User{}))
+		assert.Nil(t, request("10.0.0.2", expense.User{Username: "alice"}))
+	})
+
+	t.Run("should return 429 once the user has used up its bucket, from any IP", func(t *testing.T) {
+		err := request("10.0.0.3", expense.User{Username: "alice"})
+
+		assert.Equal(t, http.StatusTooManyRequests, err.(*echo.HTTPError).Code)
+	})
+}
+
+func TestLockoutDuration(t *testing.T) {
+	l := Lockout{MaxFailures: 3, Base: time.Minute, Max: 5 * time.Minute}
+
+	assert.Equal(t, time.Duration(0), l.duration(2))
+	assert.Equal(t, time.Minute, l.duration(3))
+	assert.Equal(t, 2*time.Minute, l.duration(4))
+	assert.Equal(t, 4*time.Minute, l.duration(5))
+	assert.Equal(t, 5*time.Minute, l.duration(6))
+	assert.Equal(t, 5*time.Minute, l.duration(100))
+}
+
+func TestAuthenticator(t *testing.T) {
+	clk := &clock{t: time.Unix(0, 0)}
+	g := NewMemoryGuard(Lockout{MaxFailures: 2, Base: time.Minute, Max: time.Hour})
+	g.now = clk.now
+	h := middleware.BasicAuth(Authenticator(g))(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+	loginFrom := func(ip, password string) (*httptest.ResponseRecorder, error) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderXRealIP, ip)
+		req.Header.Set(echo.HeaderAuthorization, "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:"+password)))
+		rec := httptest.NewRecorder()
+		return rec, h(e.NewContext(req, rec))
+	}
+	login := func(password string) (*httptest.ResponseRecorder, error) {
+		return loginFrom("10.0.0.1", password)
+	}
+
+	t.Run("should return Unauthorized until the failures reach the limit", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			mockAdmin(t)
+			_, err := login("wrongpassword")
+
+			assert.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+		}
+	})
+
+	t.Run("should return 429 with Retry-After while locked out, even with the right password", func(t *testing.T) {
+		rec, err := login("admin")
+
+		assert.Equal(t, http.StatusTooManyRequests, err.(*echo.HTTPError).Code)
+		assert.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+	})
+
+	t.Run("should let the user log in from another IP", func(t *testing.T) {
+		mockAdmin(t)
+		_, err := loginFrom("10.0.0.2", "admin")
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("should double the lock on the next failure", func(t *testing.T) {
+		clk.t = clk.t.Add(time.Minute)
+		mockAdmin(t)
+		login("wrongpassword")
+		rec, _ := login("admin")
+
+		assert.Equal(t, "120", rec.Header().Get(echo.HeaderRetryAfter))
+	})
+
+	t.Run("should reset after a successful login", func(t *testing.T) {
+		clk.t = clk.t.Add(2 * time.Minute)
+		mockAdmin(t)
+		_, err := login("admin")
+		assert.Nil(t, err)
+
+		mockAdmin(t)
+		_, err = login("wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, err.(*echo.HTTPError).Code)
+	})
+}
+
+func TestMemoryGuardDropsStaleKeys(t *testing.T) {
+	clk := &clock{t: time.Unix(0, 0)}
+	g := NewMemoryGuard(Lockout{MaxFailures: 5, Base: time.Minute, Max: time.Hour})
+	g.now = clk.now
+	for i := 0; i <= maxGuardKeys; i++ {
+		g.Failed(strconv.Itoa(i))
+	}
+
+	clk.t = clk.t.Add(2 * time.Hour)
+	g.Failed("last")
+
+	assert.Len(t, g.keys, 1)
+}
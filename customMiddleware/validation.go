@@ -0,0 +1,56 @@
+package customMiddleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/Suvisuttikasame/assessment/openapi"
+	"github.com/labstack/echo/v4"
+)
+
+// maxJSONBody is the largest JSON body ValidateRequest reads, bodies of other
+// types, such as receipt uploads, are left to their handler.
+const maxJSONBody = 1 << 20
+
+// ValidateRequest refuses requests whose parameters or JSON body don't match
+// the operation doc describes for their route, listing every mismatch.
+// Routes doc doesn't describe are let through.
+func ValidateRequest(doc *openapi.Document) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			op, ok := doc.Operation(c.Request().Method, c.Path())
+			if !ok {
+				return next(c)
+			}
+
+			v := doc.ValidateParams(op, c.Param, c.QueryParams())
+			req := c.Request()
+			if op.RequestBody != nil && req.Body != nil && jsonBody(req) {
+				body, err := io.ReadAll(http.MaxBytesReader(c.Response(), req.Body, maxJSONBody))
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return &expense.Err{Status: http.StatusRequestEntityTooLarge, Message: "body error : this field should not larger than 1 MB."}
+				}
+				if err != nil {
+					return &expense.Err{Status: http.StatusBadRequest, Message: "unable to read request body"}
+				}
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				v = append(v, doc.ValidateBody(op, req.Header.Get(echo.HeaderContentType), body)...)
+			}
+
+			if len(v) > 0 {
+				return &expense.Err{Status: http.StatusBadRequest, Message: v.Error(), Errors: v}
+			}
+			return next(c)
+		}
+	}
+}
+
+func jsonBody(req *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	return t == echo.MIMEApplicationJSON
+}
@@ -0,0 +1,76 @@
+//go:build unit
+
+package customMiddleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/Suvisuttikasame/assessment/openapi"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRequest(t *testing.T) {
+	doc, err := openapi.Spec()
+	if err != nil {
+		t.Fatal("unable to parse the openapi document", err)
+	}
+	e := echo.New()
+	e.HTTPErrorHandler = expense.HTTPErrorHandler
+	e.Use(ValidateRequest(doc))
+	e.PUT("/expenses/:id", func(c echo.Context) error {
+		b, _ := io.ReadAll(c.Request().Body)
+		return c.String(http.StatusOK, string(b))
+	})
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("should pass a valid request with its body to the handler", func(t *testing.T) {
+		body := `{"title":"taxi","amount":250,"tags":["travel"]}`
+
+		rec := put("/expenses/1", body)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("should refuse an invalid request with every error", func(t *testing.T) {
+		var p expense.Problem
+
+		rec := put("/expenses/abc", `{"title":"taxi","amount":"250","tags":["travel"]}`)
+		json.NewDecoder(rec.Body).Decode(&p)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "id error : this field should be a number.; amount error : this field should be a number.", p.Detail)
+		assert.Len(t, p.Errors, 2)
+	})
+
+	t.Run("should refuse a JSON body larger than 1 MB", func(t *testing.T) {
+		rec := put("/expenses/1", `{"title":"taxi","amount":250,"tags":["travel"],"note":"`+strings.Repeat("x", maxJSONBody)+`"}`)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("should leave bodies that are not JSON to the handler", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(strings.Repeat("x", maxJSONBody+1)))
+		req.Header.Set(echo.HeaderContentType, "application/octet-stream")
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, maxJSONBody+1, rec.Body.Len())
+	})
+}
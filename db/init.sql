@@ -1,6 +1,263 @@
+-- The schema of a new database: the Up statements of the migrations in
+-- expense/migrate.go in order, the admin account and the tables of
+-- customMiddleware.CreateTables. Keep it in step with them, TestInitSQL
+-- checks that every migration is here.
+
 CREATE TABLE IF NOT EXISTS expenses(
 					id SERIAL PRIMARY KEY,
 					title TEXT,
 					AMOUNT FLOAT,
 					NOTE TEXT,
-					TAGS TEXT[]);
\ No newline at end of file
+					TAGS TEXT[]);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS users(
+					username TEXT PRIMARY KEY,
+					password TEXT NOT NULL,
+					role TEXT NOT NULL);
+
+CREATE TABLE IF NOT EXISTS groups(
+					id SERIAL PRIMARY KEY,
+					name TEXT NOT NULL UNIQUE,
+					role TEXT NOT NULL DEFAULT '');
+
+CREATE TABLE IF NOT EXISTS group_members(
+					group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+					username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+					PRIMARY KEY (group_id, username));
+
+CREATE TABLE IF NOT EXISTS audit_log(
+					id BIGSERIAL PRIMARY KEY,
+					actor TEXT NOT NULL,
+					action TEXT NOT NULL,
+					entity TEXT NOT NULL,
+					entity_id INT NOT NULL,
+					request_id TEXT NOT NULL DEFAULT '',
+					before JSONB,
+					after JSONB,
+					changed_fields TEXT[] NOT NULL DEFAULT '{}',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+					BEGIN
+						RAISE EXCEPTION 'audit_log is append-only';
+					END;
+					$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_change ON audit_log;
+
+CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+					FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+					FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+CREATE TABLE IF NOT EXISTS expense_revisions(
+					expense_id INT NOT NULL,
+					revision INT NOT NULL,
+					data JSONB NOT NULL,
+					action TEXT NOT NULL,
+					actor TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					PRIMARY KEY (expense_id, revision));
+
+CREATE TABLE IF NOT EXISTS webhooks(
+					id SERIAL PRIMARY KEY,
+					url TEXT NOT NULL,
+					events TEXT[] NOT NULL,
+					secret TEXT NOT NULL,
+					active BOOLEAN NOT NULL DEFAULT TRUE,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries(
+					id BIGSERIAL PRIMARY KEY,
+					webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+					event TEXT NOT NULL,
+					payload JSONB NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					attempts INT NOT NULL DEFAULT 0,
+					next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					last_error TEXT NOT NULL DEFAULT '',
+					response_code INT NOT NULL DEFAULT 0,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS outbox(
+					id BIGSERIAL PRIMARY KEY,
+					aggregate_id INT NOT NULL,
+					sequence INT NOT NULL,
+					event TEXT NOT NULL,
+					payload JSONB NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					published_at TIMESTAMPTZ,
+					UNIQUE (aggregate_id, sequence));
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
+
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries (webhook_id, event_id);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS payer TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS participants JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS settlements(
+					id SERIAL PRIMARY KEY,
+					payer TEXT NOT NULL,
+					payee TEXT NOT NULL,
+					amount FLOAT NOT NULL,
+					note TEXT NOT NULL DEFAULT '',
+					created_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS group_id INT REFERENCES groups(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS expenses_group_idx ON expenses (group_id);
+
+CREATE TABLE IF NOT EXISTS group_invitations(
+					id SERIAL PRIMARY KEY,
+					group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+					username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+					invited_by TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					responded_at TIMESTAMPTZ);
+
+CREATE UNIQUE INDEX IF NOT EXISTS group_invitations_pending_idx ON group_invitations (group_id, username) WHERE status = 'pending';
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'draft';
+
+CREATE INDEX IF NOT EXISTS expenses_status_idx ON expenses (status);
+
+CREATE TABLE IF NOT EXISTS expense_transitions(
+					id BIGSERIAL PRIMARY KEY,
+					expense_id INT NOT NULL,
+					from_status TEXT NOT NULL,
+					to_status TEXT NOT NULL,
+					comment TEXT NOT NULL DEFAULT '',
+					actor TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS expense_transitions_expense_idx ON expense_transitions (expense_id);
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS date DATE NOT NULL DEFAULT CURRENT_DATE;
+
+CREATE TABLE IF NOT EXISTS expense_reports(
+					id SERIAL PRIMARY KEY,
+					title TEXT NOT NULL,
+					period_start DATE NOT NULL,
+					period_end DATE NOT NULL,
+					owner TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'draft',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS expense_report_items(
+					report_id INT NOT NULL REFERENCES expense_reports(id) ON DELETE CASCADE,
+					expense_id INT NOT NULL UNIQUE REFERENCES expenses(id) ON DELETE CASCADE,
+					PRIMARY KEY (report_id, expense_id));
+
+CREATE TABLE IF NOT EXISTS payees(
+					username TEXT PRIMARY KEY REFERENCES users(username) ON DELETE CASCADE,
+					account_name TEXT NOT NULL,
+					bank_code TEXT NOT NULL,
+					account_number TEXT NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE TABLE IF NOT EXISTS payment_batches(
+					id SERIAL PRIMARY KEY,
+					currency TEXT NOT NULL,
+					layout TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'open',
+					created_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					paid_at TIMESTAMPTZ);
+
+CREATE TABLE IF NOT EXISTS payment_batch_payments(
+					batch_id INT NOT NULL REFERENCES payment_batches(id) ON DELETE CASCADE,
+					username TEXT NOT NULL,
+					account_name TEXT NOT NULL,
+					bank_code TEXT NOT NULL,
+					account_number TEXT NOT NULL,
+					amount FLOAT NOT NULL,
+					PRIMARY KEY (batch_id, username));
+
+CREATE TABLE IF NOT EXISTS payment_batch_items(
+					batch_id INT NOT NULL REFERENCES payment_batches(id) ON DELETE CASCADE,
+					expense_id INT NOT NULL UNIQUE REFERENCES expenses(id) ON DELETE CASCADE,
+					username TEXT NOT NULL,
+					PRIMARY KEY (batch_id, expense_id));
+
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS expenses_metadata_idx ON expenses USING GIN (metadata);
+
+CREATE TABLE IF NOT EXISTS field_definitions(
+					name TEXT PRIMARY KEY,
+					type TEXT NOT NULL,
+					required BOOLEAN NOT NULL DEFAULT false,
+					enum TEXT[] NOT NULL DEFAULT '{}');
+
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS group_id INT REFERENCES groups(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS receipts(
+					id SERIAL PRIMARY KEY,
+					expense_id INT NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+					filename TEXT NOT NULL,
+					content_type TEXT NOT NULL,
+					size INT NOT NULL,
+					content BYTEA NOT NULL,
+					uploaded_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+
+CREATE INDEX IF NOT EXISTS receipts_expense_idx ON receipts (expense_id);
+
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
+
+CREATE TABLE IF NOT EXISTS outbox_sequences(
+					aggregate_id INT PRIMARY KEY,
+					sequence INT NOT NULL);
+
+INSERT INTO outbox_sequences (aggregate_id, sequence)
+				SELECT aggregate_id, MAX(sequence) FROM outbox GROUP BY aggregate_id
+				ON CONFLICT DO NOTHING;
+
+INSERT INTO outbox_sequences (aggregate_id, sequence)
+				SELECT expense_id, MAX(revision) FROM expense_revisions GROUP BY expense_id
+				ON CONFLICT DO NOTHING;
+
+-- admin/admin, same account as expense.CreateTables seeds on an empty users table
+INSERT INTO users (username, password, role)
+	SELECT 'admin', '$2a$10$I1OwJXQ0A3CW6nW9Tnk8RuKgTBABC.nQb627K0Uqr/ogvy/tk.T0W', 'admin'
+	WHERE NOT EXISTS (SELECT 1 FROM users);
+
+CREATE TABLE IF NOT EXISTS rate_limits(
+					key TEXT PRIMARY KEY,
+					tokens FLOAT NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL);
+
+CREATE TABLE IF NOT EXISTS login_failures(
+					key TEXT PRIMARY KEY,
+					failures INT NOT NULL,
+					locked_until TIMESTAMPTZ);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+					key TEXT PRIMARY KEY,
+					fingerprint TEXT NOT NULL,
+					status INT NOT NULL DEFAULT 0,
+					content_type TEXT NOT NULL DEFAULT '',
+					body BYTEA NOT NULL DEFAULT '',
+					expires_at TIMESTAMPTZ NOT NULL);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,265 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StatusDraft      = "draft"
+	StatusSubmitted  = "submitted"
+	StatusApproved   = "approved"
+	StatusRejected   = "rejected"
+	StatusReimbursed = "reimbursed"
+)
+
+// Transition is one step of an expense through the approval workflow.
+type Transition struct {
+	Id        int64     `json:"id"`
+	ExpenseId int       `json:"expense_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Comment   string    `json:"comment"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type workflowStep struct {
+	from   []string
+	to     string
+	action Action
+}
+
+// workflow lists the steps an expense may take, by name. Rejected expenses
+// go back to their owner and may be submitted again.
+var workflow = map[string]workflowStep{
+	"submit":    {[]string{StatusDraft, StatusRejected}, StatusSubmitted, ActionUpdate},
+	"approve":   {[]string{StatusSubmitted}, StatusApproved, ActionApprove},
+	"reject":    {[]string{StatusSubmitted}, StatusRejected, ActionApprove},
+	"reimburse": {[]string{StatusApproved}, StatusReimbursed, ActionReimburse},
+}
+
+// locked reports whether the expense is waiting for approval or went through
+// it, and can't be edited. A rejected expense can be edited again.
+func (e *Expense) locked() bool {
+	return e.Status == StatusSubmitted || e.Status == StatusApproved || e.Status == StatusReimbursed
+}
+
+// WorkflowError is returned when an expense is not in a status the step
+// starts from.
+type WorkflowError struct {
+	Reason string
+}
+
+func (e *WorkflowError) Error() string {
+	return e.Reason
+}
+
+// transition moves ex, locked in tx, through the step name and records it
+// with comment. It returns an *AccessDenied or a *WorkflowError when the
+// step is not allowed.
+func transition(tx *sql.Tx, c echo.Context, ex *Expense, name, comment string) error {
+	step := workflow[name]
+	if err := Authorize(CurrentUser(c), step.action, ex); err != nil {
+		return err
+	}
+	if !contains(step.from, ex.Status) {
+		return &WorkflowError{Reason: fmt.Sprintf("can't %s expense %d, it is %s", name, ex.Id, ex.Status)}
+	}
+	if name == "reject" && comment == "" {
+		return &WorkflowError{Reason: "comment error : a rejection should say why."}
+	}
+
+	before := *ex
+	if _, err := tx.Exec(`UPDATE expenses SET status = $2 WHERE id = $1`, ex.Id, step.to); err != nil {
+		return err
+	}
+	ex.Status = step.to
+	_, err := tx.Exec(`INSERT INTO expense_transitions (expense_id, from_status, to_status, comment, actor) VALUES ($1, $2, $3, $4, $5)`,
+		ex.Id, before.Status, ex.Status, comment, CurrentUser(c).Username)
+	if err != nil {
+		return err
+	}
+	return recordChange(tx, c, name, &before, ex)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func SubmitExpense(c echo.Context) error {
+	return transitionExpense(c, "submit")
+}
+
+func ApproveExpense(c echo.Context) error {
+	return transitionExpense(c, "approve")
+}
+
+func RejectExpense(c echo.Context) error {
+	return transitionExpense(c, "reject")
+}
+
+func ReimburseExpense(c echo.Context) error {
+	return transitionExpense(c, "reimburse")
+}
+
+// transitionExpense moves the expense in the path through the step name,
+// with the comment given in the body.
+func transitionExpense(c echo.Context, name string) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, workflow[name].action, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	body := struct {
+		Comment string `json:"comment"`
+	}{}
+	if err := c.Bind(&body); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	ex := Expense{}
+	err = scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, c.Param("id")), &ex)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan expense", err)
+	}
+
+	if err := heldExpense(tx, &ex); err != nil {
+		return transitionError(err)
+	}
+	if err := transition(tx, c, &ex, name, body.Comment); err != nil {
+		return transitionError(err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, ex)
+}
+
+// heldExpense returns a *WorkflowError when ex takes its steps with
+// something else. An expense in an open payment batch is reimbursed when the
+// batch is paid; reimbursing it alone would pay it twice, the bank file
+// still listing it. An expense in a report past its draft moves with the
+// report, which expects all of its expenses at the report's status.
+func heldExpense(tx *sql.Tx, ex *Expense) error {
+	var batch int
+	err := tx.QueryRow(`SELECT i.batch_id FROM payment_batch_items i JOIN payment_batches b ON b.id = i.batch_id
+		WHERE i.expense_id = $1 AND b.status = $2`, ex.Id, BatchOpen).Scan(&batch)
+	switch err {
+	case nil:
+		return &WorkflowError{Reason: fmt.Sprintf("expense %d is in open payment batch %d, it is reimbursed when the batch is paid", ex.Id, batch)}
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+
+	var report int
+	var status string
+	err = tx.QueryRow(`SELECT r.id, r.status FROM expense_report_items i JOIN expense_reports r ON r.id = i.report_id
+		WHERE i.expense_id = $1 AND r.status <> $2`, ex.Id, StatusDraft).Scan(&report, &status)
+	switch err {
+	case nil:
+		return &WorkflowError{Reason: fmt.Sprintf("expense %d is in %s report %d, it takes its steps with the report", ex.Id, status, report)}
+	case sql.ErrNoRows:
+		return nil
+	default:
+		return err
+	}
+}
+
+func transitionError(err error) error {
+	switch err.(type) {
+	case *AccessDenied:
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	case *WorkflowError:
+		return &Err{Status: http.StatusConflict, Message: err.Error()}
+	}
+	return internalErr("unable to change status", err)
+}
+
+func GetExpenseTransitions(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	ex := Expense{}
+	err := scanExpense(Db.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1`, c.Param("id")), &ex)
+	if err == nil && Authorize(u, ActionView, &ex) != nil {
+		err = sql.ErrNoRows
+	}
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan expense", err)
+	}
+
+	rows, err := Db.Query(`SELECT id, expense_id, from_status, to_status, comment, actor, created_at
+		FROM expense_transitions WHERE expense_id = $1 ORDER BY id`, ex.Id)
+	if err != nil {
+		return internalErr("unable to query transitions", err)
+	}
+	defer rows.Close()
+
+	ts := []Transition{}
+	for rows.Next() {
+		t := Transition{}
+		if err := rows.Scan(&t.Id, &t.ExpenseId, &t.From, &t.To, &t.Comment, &t.Actor, &t.CreatedAt); err != nil {
+			return internalErr("unable to scan transition", err)
+		}
+		ts = append(ts, t)
+	}
+	return c.JSON(http.StatusOK, ts)
+}
+
+// GetApprovalQueue lists the submitted expenses waiting for an approver,
+// oldest first. The approver's own expenses are left out, someone else
+// approves them.
+func GetApprovalQueue(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionApprove, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	where, args, err := expenseFilter(c, u)
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	args = append(args, u.Username)
+	rows, err := Db.Query(`SELECT `+expenseColumns+` FROM expenses WHERE status = 'submitted' AND `+where+fmt.Sprintf(` AND owner <> $%d ORDER BY id`, len(args)), args...)
+	if err != nil {
+		return internalErr("unable to query expenses", err)
+	}
+	defer rows.Close()
+
+	exs := []Expense{}
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return internalErr("unable to scan expense", err)
+		}
+		exs = append(exs, ex)
+	}
+	return c.JSON(http.StatusOK, exs)
+}
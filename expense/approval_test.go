@@ -0,0 +1,239 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func transitionContext(path, id, body string, u User) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, u)
+	c.SetPath(path)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return c, rec
+}
+
+func expectLockedExpense(mock sqlmock.Sqlmock, owner, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), owner, "", "", []byte("[]"), 0, status, "THB", testDate, []byte("{}")))
+}
+
+// expectNotHeld answers that the expense is in no open payment batch and in
+// no report past its draft.
+func expectNotHeld(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payment_batch_items i JOIN payment_batches b ON b.id = i.batch_id`)).
+		WithArgs(1, BatchOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"batch_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_report_items i JOIN expense_reports r ON r.id = i.report_id`)).
+		WithArgs(1, StatusDraft).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}))
+}
+
+func TestApproveExpense(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	expectLockedExpense(mock, "alice", StatusSubmitted)
+	expectNotHeld(mock)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE expenses SET status = $2 WHERE id = $1`)).
+		WithArgs(1, StatusApproved).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_transitions`)).
+		WithArgs(1, StatusSubmitted, StatusApproved, "looks good", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs("admin", "approve", 1, "", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"status"})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WithArgs(1, sqlmock.AnyArg(), "approve", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WithArgs(1, EventExpenseUpdated, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	c, rec := transitionContext("/expenses/:id/approve", "1", `{"comment":"looks good"}`, testAdmin)
+	ex := Expense{}
+
+	//action
+	err = serve(ApproveExpense, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&ex)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, StatusApproved, ex.Status)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestTransitionRules(t *testing.T) {
+	cases := []struct {
+		name    string
+		step    func(echo.Context) error
+		user    User
+		status  string
+		body    string
+		code    int
+		message string
+	}{
+		{"can't approve a draft", ApproveExpense, testAdmin, StatusDraft, `{}`, http.StatusConflict, "can't approve expense 1, it is draft"},
+		{"reject needs a comment", RejectExpense, testAdmin, StatusSubmitted, `{}`, http.StatusConflict, "comment error : a rejection should say why."},
+		{"editor can't approve", ApproveExpense, User{Username: "alice", Role: RoleEditor}, StatusSubmitted, `{}`, http.StatusForbidden, "role editor is not allowed to approve expenses, requires approver"},
+		{"can't reimburse before approval", ReimburseExpense, testAdmin, StatusSubmitted, `{}`, http.StatusConflict, "can't reimburse expense 1, it is submitted"},
+		{"approver can't approve own expense", ApproveExpense, User{Username: "alice", Role: RoleApprover}, StatusSubmitted, `{}`, http.StatusForbidden, `expense 1 belongs to "alice", someone else should approve or reject it`},
+		{"admin can't reject own expense", RejectExpense, User{Username: "alice", Role: RoleAdmin}, StatusSubmitted, `{"comment":"no receipt"}`, http.StatusForbidden, `expense 1 belongs to "alice", someone else should approve or reject it`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mock sqlmock.Sqlmock
+			var err error
+			Db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal("unable to create mock db", err)
+			}
+			defer Db.Close()
+			mock.ExpectBegin()
+			expectLockedExpense(mock, "alice", tc.status)
+			expectNotHeld(mock)
+			mock.ExpectRollback()
+
+			c, rec := transitionContext("/expenses/:id/step", "1", tc.body, tc.user)
+			var r Err
+
+			err = serve(tc.step, c)
+			assert.Nil(t, err)
+			json.NewDecoder(rec.Body).Decode(&r)
+
+			assert.Equal(t, tc.code, rec.Code)
+			assert.Equal(t, tc.message, r.Message)
+		})
+	}
+}
+
+func TestReimburseExpenseInPaymentBatch(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectBegin()
+	expectLockedExpense(mock, "alice", StatusApproved)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payment_batch_items i JOIN payment_batches b ON b.id = i.batch_id`)).
+		WithArgs(1, BatchOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"batch_id"}).AddRow(4))
+	mock.ExpectRollback()
+	c, rec := transitionContext("/expenses/:id/reimburse", "1", `{}`, testAdmin)
+	var r Err
+
+	//action
+	err = serve(ReimburseExpense, c)
+	assert.Nil(t, err)
+	json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "expense 1 is in open payment batch 4, it is reimbursed when the batch is paid", r.Message)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveExpenseInReport(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectBegin()
+	expectLockedExpense(mock, "alice", StatusSubmitted)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payment_batch_items i JOIN payment_batches b ON b.id = i.batch_id`)).
+		WithArgs(1, BatchOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"batch_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_report_items i JOIN expense_reports r ON r.id = i.report_id`)).
+		WithArgs(1, StatusDraft).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(2, StatusSubmitted))
+	mock.ExpectRollback()
+	c, rec := transitionContext("/expenses/:id/approve", "1", `{}`, testAdmin)
+	var r Err
+
+	//action
+	err = serve(ApproveExpense, c)
+	assert.Nil(t, err)
+	json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "expense 1 is in submitted report 2, it takes its steps with the report", r.Message)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateLockedExpense(t *testing.T) {
+	for _, status := range []string{StatusSubmitted, StatusApproved, StatusReimbursed} {
+		t.Run(status, func(t *testing.T) {
+			//arrange
+			var mock sqlmock.Sqlmock
+			var err error
+			Db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal("unable to create mock db", err)
+			}
+			defer Db.Close()
+
+			mock.ExpectBegin()
+			expectLockedExpense(mock, "admin", status)
+			mock.ExpectRollback()
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"title":"taxi","amount":300,"tags":["travel"]}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			SetCurrentUser(c, testAdmin)
+			c.SetPath("/expenses/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			var r Err
+
+			//action
+			err = serve(UpdateExpensesById, c)
+			assert.Nil(t, err)
+			err = json.NewDecoder(rec.Body).Decode(&r)
+
+			//assert
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.Equal(t, "expense is "+status+" and can't be edited", r.Message)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package expense
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+type AuditEntry struct {
+	Id            int64           `json:"id"`
+	Actor         string          `json:"actor"`
+	Action        string          `json:"action"`
+	Entity        string          `json:"entity"`
+	EntityId      int             `json:"entity_id"`
+	RequestId     string          `json:"request_id"`
+	Before        json.RawMessage `json:"before"`
+	After         json.RawMessage `json:"after"`
+	ChangedFields []string        `json:"changed_fields"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// requestId returns the id given to the request by the RequestID middleware.
+func requestId(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+// writeAudit records a mutation of an expense in tx, so the entry is only kept
+// when the mutation is. before is nil on create and after is nil on delete.
+func writeAudit(tx *sql.Tx, c echo.Context, action string, before, after *Expense) error {
+	b, err := jsonOrNull(before)
+	if err != nil {
+		return err
+	}
+	a, err := jsonOrNull(after)
+	if err != nil {
+		return err
+	}
+	changed, err := changedFields(b, a)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO audit_log (actor, action, entity, entity_id, request_id, before, after, changed_fields)
+		VALUES ($1, $2, 'expense', $3, $4, $5, $6, $7)`,
+		CurrentUser(c).Username, action, changedExpense(before, after).Id, requestId(c), nullJSON(b), nullJSON(a), pq.Array(changed))
+	return err
+}
+
+// changedExpense returns the side of a mutation that exists.
+func changedExpense(before, after *Expense) *Expense {
+	if after == nil {
+		return before
+	}
+	return after
+}
+
+func jsonOrNull(ex *Expense) ([]byte, error) {
+	if ex == nil {
+		return nil, nil
+	}
+	return json.Marshal(ex)
+}
+
+// nullJSON turns b into a JSONB parameter, NULL when b is nil.
+func nullJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+// changedFields lists, sorted, the top level JSON fields that differ between
+// before and after.
+func changedFields(before, after []byte) ([]string, error) {
+	b, a := map[string]interface{}{}, map[string]interface{}{}
+	if before != nil {
+		if err := json.Unmarshal(before, &b); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &a); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := []string{}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || !reflect.DeepEqual(bv, v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAudit lists audit entries, newest first. It accepts the filters actor,
+// action, entity, entity_id, request_id, from and to (RFC 3339), and limit.
+func GetAudit(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionViewAudit, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	// entries of expenses filed under a group are seen by its members
+	visible, args := visibleSnapshots(u, 1, "before", "after")
+	where := []string{visible}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	for _, f := range []string{"actor", "action", "entity", "request_id"} {
+		if v := c.QueryParam(f); v != "" {
+			add(f+" = ?", v)
+		}
+	}
+	if v := c.QueryParam("entity_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return &Err{Status: http.StatusBadRequest, Message: "entity_id error : this field should be a number."}
+		}
+		add("entity_id = ?", id)
+	}
+	for _, f := range []struct{ param, cond string }{{"from", "created_at >= ?"}, {"to", "created_at < ?"}} {
+		if v := c.QueryParam(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return &Err{Status: http.StatusBadRequest, Message: f.param + " error : this field should be an RFC 3339 time."}
+			}
+			add(f.cond, t)
+		}
+	}
+
+	limit := defaultAuditLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			return &Err{Status: http.StatusBadRequest, Message: "limit error : this field should be between 1 and " + strconv.Itoa(maxAuditLimit) + "."}
+		}
+		limit = n
+	}
+
+	q := `SELECT id, actor, action, entity, entity_id, request_id, before, after, changed_fields, created_at FROM audit_log WHERE ` + strings.Join(where, ` AND `)
+	q += ` ORDER BY id DESC LIMIT ` + strconv.Itoa(limit)
+
+	rows, err := Db.Query(q, args...)
+	if err != nil {
+		return internalErr("unable to query audit log", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		a := AuditEntry{}
+		var before, after []byte
+		err := rows.Scan(&a.Id, &a.Actor, &a.Action, &a.Entity, &a.EntityId, &a.RequestId, &before, &after, pq.Array(&a.ChangedFields), &a.CreatedAt)
+		if err != nil {
+			return internalErr("unable to scan audit entry", err)
+		}
+		a.Before, a.After = before, after
+		entries = append(entries, a)
+	}
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,85 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedFields(t *testing.T) {
+	t.Run("should list every field on create", func(t *testing.T) {
+		fields, err := changedFields(nil, []byte(`{"id":1,"title":"a"}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"id", "title"}, fields)
+	})
+
+	t.Run("should list only the fields that differ on update", func(t *testing.T) {
+		fields, err := changedFields([]byte(`{"id":1,"title":"a","tags":["x"]}`), []byte(`{"id":1,"title":"a","tags":["x","y"]}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"tags"}, fields)
+	})
+}
+
+func TestGetAuditUnit(t *testing.T) {
+	//arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/audit?actor=admin&entity_id=1&from=2022-12-01T00:00:00Z", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "carol", Role: RoleApprover, GroupIds: []int64{7}})
+
+	var mock sqlmock.Sqlmock
+	var err error
+	rt := []AuditEntry{}
+	at := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, actor, action, entity, entity_id, request_id, before, after, changed_fields, created_at FROM audit_log WHERE ($1 OR (COALESCE((before->>'group_id')::int = ANY($2), true) AND COALESCE((after->>'group_id')::int = ANY($2), true))) AND actor = $3 AND entity_id = $4 AND created_at >= $5 ORDER BY id DESC LIMIT 100`)).
+		WithArgs(false, pq.Array([]int64{7}), "admin", 1, time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action", "entity", "entity_id", "request_id", "before", "after", "changed_fields", "created_at"}).
+			AddRow(2, "admin", "update", "expense", 1, "req-1", []byte(`{"amount":1}`), []byte(`{"amount":2}`), pq.Array([]string{"amount"}), at))
+
+	//action
+	err = serve(GetAudit, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&rt)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, rt, 1)
+	assert.Equal(t, "req-1", rt[0].RequestId)
+	assert.JSONEq(t, `{"amount":1}`, string(rt[0].Before))
+	assert.Equal(t, []string{"amount"}, rt[0].ChangedFields)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuditForbidden(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/audit", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleEditor})
+
+	err := serve(GetAudit, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
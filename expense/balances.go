@@ -0,0 +1,273 @@
+package expense
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Settlement records a payment from one person to another that clears debt.
+type Settlement struct {
+	Id        int       `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    float64   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Note      string    `json:"note"`
+	GroupId   int       `json:"group_id,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Balance is what a person is owed in a currency, negative when they owe.
+type Balance struct {
+	Name     string  `json:"name"`
+	Currency string  `json:"currency"`
+	Net      float64 `json:"net"`
+}
+
+// Transfer is a payment that settles up the balances of a currency.
+type Transfer struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+type Balances struct {
+	Balances  []Balance  `json:"balances"`
+	Transfers []Transfer `json:"transfers"`
+}
+
+func (s *Settlement) validation() error {
+	if s.From == "" {
+		return fmt.Errorf("from error : this field should not empty.")
+	}
+	if s.To == "" {
+		return fmt.Errorf("to error : this field should not empty.")
+	}
+	if s.From == s.To {
+		return fmt.Errorf("to error : this field should not be the same as from.")
+	}
+	if toCents(s.Amount) <= 0 {
+		return fmt.Errorf("amount error : this field should more than 0.")
+	}
+	if s.Currency != "" && !currencyPattern.MatchString(s.Currency) {
+		return fmt.Errorf("currency error : this field should be a 3 letter ISO 4217 code.")
+	}
+	return nil
+}
+
+// computeBalances nets what every participant owes the payer of each shared
+// expense against the settlements made so far, in each currency on its own.
+// Balances are sorted by currency and name, people who are settled up in a
+// currency are left out of it.
+func computeBalances(exs []Expense, sts []Settlement) []Balance {
+	type account struct{ currency, name string }
+	net := map[account]int64{}
+	for _, ex := range exs {
+		for _, p := range ex.Participants {
+			if p.Name == ex.Payer {
+				continue
+			}
+			owed := toCents(p.Owed)
+			net[account{ex.Currency, ex.Payer}] += owed
+			net[account{ex.Currency, p.Name}] -= owed
+		}
+	}
+	for _, s := range sts {
+		amount := toCents(s.Amount)
+		net[account{s.Currency, s.From}] += amount
+		net[account{s.Currency, s.To}] -= amount
+	}
+
+	bs := []Balance{}
+	for a, cents := range net {
+		if cents != 0 {
+			bs = append(bs, Balance{Name: a.name, Currency: a.currency, Net: fromCents(cents)})
+		}
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].Currency != bs[j].Currency {
+			return bs[i].Currency < bs[j].Currency
+		}
+		return bs[i].Name < bs[j].Name
+	})
+	return bs
+}
+
+// settleUp settles the balances of each currency on their own, as sorted by
+// computeBalances.
+func settleUp(bs []Balance) []Transfer {
+	ts := []Transfer{}
+	for len(bs) > 0 {
+		n := 1
+		for n < len(bs) && bs[n].Currency == bs[0].Currency {
+			n++
+		}
+		ts = append(ts, settleCurrency(bs[:n])...)
+		bs = bs[n:]
+	}
+	return ts
+}
+
+// settleCurrency pays the largest creditor from the largest debtor until
+// everyone is settled, which takes at most one transfer less than there are
+// people. bs are balances of a single currency.
+func settleCurrency(bs []Balance) []Transfer {
+	type party struct {
+		name  string
+		cents int64
+	}
+	var debtors, creditors []party
+	for _, b := range bs {
+		c := toCents(b.Net)
+		switch {
+		case c < 0:
+			debtors = append(debtors, party{b.Name, -c})
+		case c > 0:
+			creditors = append(creditors, party{b.Name, c})
+		}
+	}
+	byAmount := func(ps []party) {
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].cents > ps[j].cents })
+	}
+
+	ts := []Transfer{}
+	for len(debtors) > 0 && len(creditors) > 0 {
+		byAmount(debtors)
+		byAmount(creditors)
+		d, cr := &debtors[0], &creditors[0]
+		amount := d.cents
+		if cr.cents < amount {
+			amount = cr.cents
+		}
+		ts = append(ts, Transfer{From: d.name, To: cr.name, Currency: bs[0].Currency, Amount: fromCents(amount)})
+		d.cents -= amount
+		cr.cents -= amount
+		if d.cents == 0 {
+			debtors = debtors[1:]
+		}
+		if cr.cents == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return ts
+}
+
+const selectSettlement = `SELECT id, payer, payee, amount, currency, note, COALESCE(group_id, 0), created_by, created_at FROM settlements`
+
+func scanSettlement(row scanner, s *Settlement) error {
+	return row.Scan(&s.Id, &s.From, &s.To, &s.Amount, &s.Currency, &s.Note, &s.GroupId, &s.CreatedBy, &s.CreatedAt)
+}
+
+// querySettlements reads the settlements u may see, of group ?group= when it
+// is given.
+func querySettlements(c echo.Context, u User) ([]Settlement, error) {
+	where, args, err := groupFilter(c, u)
+	if err != nil {
+		return nil, &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	rows, err := Db.Query(selectSettlement+` WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, internalErr("unable to query settlements", err)
+	}
+	defer rows.Close()
+
+	sts := []Settlement{}
+	for rows.Next() {
+		s := Settlement{}
+		if err := scanSettlement(rows, &s); err != nil {
+			return nil, internalErr("unable to scan settlement", err)
+		}
+		sts = append(sts, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalErr("unable to query settlements", err)
+	}
+	return sts, nil
+}
+
+func GetBalances(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	where, args, err := expenseFilter(c, u)
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	rows, err := Db.Query(`SELECT `+expenseColumns+` FROM expenses WHERE jsonb_array_length(participants) > 0 AND `+where, args...)
+	if err != nil {
+		return internalErr("unable to query shared expenses", err)
+	}
+	defer rows.Close()
+
+	exs := []Expense{}
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return internalErr("can't scan expense", err)
+		}
+		exs = append(exs, ex)
+	}
+	if err := rows.Err(); err != nil {
+		return internalErr("unable to read shared expenses", err)
+	}
+
+	sts, err := querySettlements(c, u)
+	if err != nil {
+		return err
+	}
+
+	bs := computeBalances(exs, sts)
+	return c.JSON(http.StatusOK, Balances{Balances: bs, Transfers: settleUp(bs)})
+}
+
+func CreateSettlement(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionCreate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	s := Settlement{}
+	if err := c.Bind(&s); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := s.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := AuthorizeSettlement(u, &s); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if s.Currency == "" {
+		s.Currency = DefaultCurrency
+	}
+	s.CreatedBy = u.Username
+
+	row := Db.QueryRow(`INSERT INTO settlements (payer, payee, amount, currency, note, group_id, created_by) VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7) RETURNING id, created_at`,
+		s.From, s.To, s.Amount, s.Currency, s.Note, s.GroupId, s.CreatedBy)
+	if err := row.Scan(&s.Id, &s.CreatedAt); err != nil {
+		return internalErr("unable to create settlement", err)
+	}
+	return c.JSON(http.StatusCreated, s)
+}
+
+// GetSettlements lists the settlements the current user may see, of group
+// ?group= when it is given.
+func GetSettlements(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	sts, err := querySettlements(c, u)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, sts)
+}
@@ -0,0 +1,140 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var settlementRows = []string{"id", "payer", "payee", "amount", "currency", "note", "group_id", "created_by", "created_at"}
+
+func TestComputeSplit(t *testing.T) {
+	cases := []struct {
+		name   string
+		split  string
+		amount float32
+		values []float64
+		owed   []float64
+	}{
+		{"equal split gives the leftover cent to the first", SplitEqual, 100, []float64{0, 0, 0}, []float64{33.34, 33.33, 33.33}},
+		{"exact split keeps the values", SplitExact, 100, []float64{70, 30}, []float64{70, 30}},
+		{"percent split", SplitPercent, 250, []float64{50, 30, 20}, []float64{125, 75, 50}},
+		{"shares split", SplitShares, 100, []float64{2, 1}, []float64{66.67, 33.33}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ex := Expense{Title: "dinner", Amount: tc.amount, Tags: []string{"food"}, Split: tc.split}
+			for i, v := range tc.values {
+				ex.Participants = append(ex.Participants, Participant{Name: string(rune('a' + i)), Value: v})
+			}
+
+			assert.Nil(t, ex.validation())
+			ex.computeSplit()
+
+			for i, p := range ex.Participants {
+				assert.Equal(t, tc.owed[i], p.Owed)
+			}
+		})
+	}
+}
+
+func TestSplitValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		split   string
+		parts   Participants
+		message string
+	}{
+		{"exact values off the amount", SplitExact, Participants{{Name: "a", Value: 60}, {Name: "b", Value: 30}}, "participants error : exact values should add up to the amount 100.00."},
+		{"percent not adding up to 100", SplitPercent, Participants{{Name: "a", Value: 60}, {Name: "b", Value: 30}}, "participants error : percent values should add up to 100."},
+		{"duplicated participant", SplitEqual, Participants{{Name: "a"}, {Name: "a"}}, "participants error : a is listed more than once."},
+		{"unknown split", "halves", Participants{{Name: "a"}}, "split error : this field should be one of equal, exact, percent or shares."},
+		{"split without participants", SplitEqual, nil, "participants error : this field should have at least 1 when split is set."},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ex := Expense{Title: "dinner", Amount: 100, Tags: []string{"food"}, Split: tc.split, Participants: tc.parts}
+
+			err := ex.validation()
+
+			assert.EqualError(t, err, tc.message)
+		})
+	}
+}
+
+func TestSettleUp(t *testing.T) {
+	exs := []Expense{
+		{Payer: "alice", Currency: "THB", Participants: Participants{{Name: "alice", Owed: 30}, {Name: "bob", Owed: 30}, {Name: "carol", Owed: 30}}},
+		{Payer: "bob", Currency: "THB", Participants: Participants{{Name: "alice", Owed: 10}, {Name: "bob", Owed: 10}}},
+	}
+	sts := []Settlement{{From: "carol", To: "alice", Amount: 10, Currency: "THB"}}
+
+	bs := computeBalances(exs, sts)
+
+	assert.Equal(t, []Balance{{"alice", "THB", 40}, {"bob", "THB", -20}, {"carol", "THB", -20}}, bs)
+	assert.Equal(t, []Transfer{{"bob", "alice", "THB", 20}, {"carol", "alice", "THB", 20}}, settleUp(bs))
+}
+
+func TestSettleUpPerCurrency(t *testing.T) {
+	exs := []Expense{
+		{Payer: "alice", Currency: "THB", Participants: Participants{{Name: "alice", Owed: 300}, {Name: "bob", Owed: 300}}},
+		{Payer: "bob", Currency: "USD", Participants: Participants{{Name: "alice", Owed: 10}, {Name: "bob", Owed: 10}}},
+	}
+	sts := []Settlement{{From: "bob", To: "alice", Amount: 100, Currency: "THB"}}
+
+	bs := computeBalances(exs, sts)
+
+	assert.Equal(t, []Balance{{"alice", "THB", 200}, {"bob", "THB", -200}, {"alice", "USD", -10}, {"bob", "USD", 10}}, bs)
+	assert.Equal(t, []Transfer{{"bob", "alice", "THB", 200}, {"alice", "bob", "USD", 10}}, settleUp(bs))
+}
+
+func TestGetBalancesUnit(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE jsonb_array_length(participants) > 0`)).
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "dinner", 90, "", pq.Array([]string{"food"}), "admin", "alice", "equal",
+				[]byte(`[{"name":"alice","owed":30},{"name":"bob","owed":30},{"name":"carol","owed":30}]`), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM settlements WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) ORDER BY id`)).
+		WithArgs(true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(settlementRows).
+			AddRow(1, "carol", "alice", 30, "THB", "", 0, "admin", time.Now()))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/balances", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	var r Balances
+
+	//action
+	err = serve(GetBalances, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []Balance{{"alice", "THB", 30}, {"bob", "THB", -30}}, r.Balances)
+	assert.Equal(t, []Transfer{{"bob", "alice", "THB", 30}}, r.Transfers)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
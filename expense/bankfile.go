@@ -0,0 +1,375 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	LayoutFixed = "fixed"
+	LayoutCSV   = "csv"
+)
+
+const (
+	EncodingUTF8   = "utf-8"
+	EncodingTIS620 = "tis-620"
+)
+
+// BankLayout describes a bulk payment file: an optional header record, one
+// detail record per payment and an optional trailer record.
+type BankLayout struct {
+	Name   string `json:"name" yaml:"name"`
+	Format string `json:"format" yaml:"format"`
+	// Encoding is the character set of the file, utf-8 when empty. Widths
+	// count bytes in it: Thai letters take 3 bytes in utf-8 and 1 in tis-620,
+	// which Thai banks commonly take.
+	Encoding string `json:"encoding,omitempty" yaml:"encoding"`
+	// Constants holds values the bank needs that don't come from the batch,
+	// such as the account the payments are made from.
+	Constants map[string]string `json:"constants,omitempty" yaml:"constants"`
+	Header    []LayoutField     `json:"header,omitempty" yaml:"header"`
+	Detail    []LayoutField     `json:"detail" yaml:"detail"`
+	Trailer   []LayoutField     `json:"trailer,omitempty" yaml:"trailer"`
+}
+
+// LayoutField is one field of a record. Value names what goes in the field,
+// see recordValues, or is a literal when it starts with "=". Width, in bytes,
+// Align and Pad apply to fixed width files; Name is the column of a CSV file.
+type LayoutField struct {
+	Name  string `json:"name,omitempty" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+	Width int    `json:"width,omitempty" yaml:"width"`
+	Align string `json:"align,omitempty" yaml:"align"`
+	Pad   string `json:"pad,omitempty" yaml:"pad"`
+}
+
+// thBankLayout is modelled on the header, detail and trailer direct credit
+// files Thai banks take for bulk transfers. Field widths vary per bank, so
+// check them against the bank's spec and override the layout if needed. The
+// company constants are the deployment's own and have to be given by an
+// override, see CheckBankLayout.
+var thBankLayout = BankLayout{
+	Name:      "th-bank",
+	Format:    LayoutFixed,
+	Encoding:  EncodingTIS620,
+	Constants: map[string]string{"company_account": "", "company_name": ""},
+	Header: []LayoutField{
+		{Value: "=H", Width: 1},
+		{Value: "batch_id", Width: 6, Align: "right", Pad: "0"},
+		{Value: "date_ddmmyyyy", Width: 8},
+		{Value: "company_account", Width: 10, Align: "right", Pad: "0"},
+		{Value: "company_name", Width: 40},
+		{Value: "count", Width: 6, Align: "right", Pad: "0"},
+		{Value: "total_satang", Width: 15, Align: "right", Pad: "0"},
+	},
+	Detail: []LayoutField{
+		{Value: "=D", Width: 1},
+		{Value: "seq", Width: 6, Align: "right", Pad: "0"},
+		{Value: "bank_code", Width: 3, Align: "right", Pad: "0"},
+		{Value: "account_number", Width: 11, Align: "right", Pad: "0"},
+		{Value: "amount_satang", Width: 15, Align: "right", Pad: "0"},
+		{Value: "account_name", Width: 50},
+		{Value: "reference", Width: 20},
+	},
+	Trailer: []LayoutField{
+		{Value: "=T", Width: 1},
+		{Value: "count", Width: 6, Align: "right", Pad: "0"},
+		{Value: "total_satang", Width: 15, Align: "right", Pad: "0"},
+	},
+}
+
+var csvLayout = BankLayout{
+	Name:   "csv",
+	Format: LayoutCSV,
+	Detail: []LayoutField{
+		{Name: "bank_code", Value: "bank_code"},
+		{Name: "account_number", Value: "account_number"},
+		{Name: "account_name", Value: "account_name"},
+		{Name: "amount", Value: "amount"},
+		{Name: "reference", Value: "reference"},
+	},
+}
+
+var (
+	bankLayoutsMu sync.RWMutex
+	bankLayouts   = map[string]BankLayout{
+		thBankLayout.Name: thBankLayout,
+		csvLayout.Name:    csvLayout,
+	}
+)
+
+// DefaultBankLayout is used by batches created without a layout.
+const DefaultBankLayout = "th-bank"
+
+func (l *BankLayout) validation() error {
+	if l.Name == "" {
+		return fmt.Errorf("name error : this field should not empty.")
+	}
+	if l.Format != LayoutFixed && l.Format != LayoutCSV {
+		return fmt.Errorf("layout %s: format should be fixed or csv", l.Name)
+	}
+	if l.Encoding != "" && l.Encoding != EncodingUTF8 && l.Encoding != EncodingTIS620 {
+		return fmt.Errorf("layout %s: encoding should be utf-8 or tis-620", l.Name)
+	}
+	if len(l.Detail) == 0 {
+		return fmt.Errorf("layout %s: detail should have at least 1 field", l.Name)
+	}
+	for _, fs := range [][]LayoutField{l.Header, l.Detail, l.Trailer} {
+		for _, f := range fs {
+			if l.Format == LayoutFixed && f.Width <= 0 {
+				return fmt.Errorf("layout %s: field %s should have a width", l.Name, f.Value)
+			}
+			if len(f.Pad) > 1 {
+				return fmt.Errorf("layout %s: pad of field %s should be a single character", l.Name, f.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadBankLayouts adds the layouts in the YAML or JSON file at path, told
+// apart by its extension, replacing the built-in layouts of the same name.
+func LoadBankLayouts(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ls := []BankLayout{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&ls)
+	default:
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&ls)
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, l := range ls {
+		if err := l.validation(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	bankLayoutsMu.Lock()
+	defer bankLayoutsMu.Unlock()
+	for _, l := range ls {
+		bankLayouts[l.Name] = l
+	}
+	return nil
+}
+
+// CheckBankLayout returns an error when the layout name is unknown or misses
+// one of its constants, which would fail every bank file written with it.
+func CheckBankLayout(name string) error {
+	l, ok := bankLayout(name)
+	if !ok {
+		return fmt.Errorf("layout %s is unknown", name)
+	}
+	missing := []string{}
+	for k, c := range l.Constants {
+		if c == "" {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("layout %s: constants %s should not empty", name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func bankLayout(name string) (BankLayout, bool) {
+	bankLayoutsMu.RLock()
+	defer bankLayoutsMu.RUnlock()
+	l, ok := bankLayouts[name]
+	return l, ok
+}
+
+// recordValues are the values the fields of a record may take.
+func recordValues(l BankLayout, b *PaymentBatch, seq int, p *Payment) map[string]string {
+	total := int64(0)
+	for _, p := range b.Payments {
+		total += toCents(p.Amount)
+	}
+	date := b.CreatedAt
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	v := map[string]string{}
+	for k, c := range l.Constants {
+		v[k] = c
+	}
+	v["batch_id"] = strconv.Itoa(b.Id)
+	v["currency"] = b.Currency
+	v["date"] = date.Format("20060102")
+	v["date_ddmmyyyy"] = date.Format("02012006")
+	v["count"] = strconv.Itoa(len(b.Payments))
+	v["total"] = fmt.Sprintf("%.2f", fromCents(total))
+	v["total_satang"] = strconv.FormatInt(total, 10)
+	if p != nil {
+		v["seq"] = strconv.Itoa(seq)
+		v["username"] = p.Username
+		v["account_name"] = p.AccountName
+		v["bank_code"] = p.BankCode
+		v["account_number"] = p.AccountNumber
+		v["amount"] = fmt.Sprintf("%.2f", p.Amount)
+		v["amount_satang"] = strconv.FormatInt(toCents(p.Amount), 10)
+		v["reference"] = fmt.Sprintf("EXP%d-%d", b.Id, seq)
+	}
+	return v
+}
+
+// BankFileError is returned by WriteBankFile when the batch doesn't fit the
+// layout.
+type BankFileError struct {
+	Reason string
+}
+
+func (e *BankFileError) Error() string {
+	return e.Reason
+}
+
+// value is what goes in the field. Fields that aren't literals must have
+// one, a blank account or company name makes a file the bank rejects.
+func (f LayoutField) value(values map[string]string) (string, error) {
+	if strings.HasPrefix(f.Value, "=") {
+		return f.Value[1:], nil
+	}
+	if values[f.Value] == "" {
+		return "", &BankFileError{Reason: f.Value + " is empty"}
+	}
+	return values[f.Value], nil
+}
+
+// encode converts s to the encoding of the file.
+func encode(encoding, s string) ([]byte, error) {
+	if encoding != EncodingTIS620 {
+		return []byte(s), nil
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80:
+			b = append(b, byte(r))
+		// tis-620 puts the Thai block, U+0E01 to U+0E5B, at 0xA1 to 0xFB
+		case r >= 0x0E01 && r <= 0x0E5B:
+			b = append(b, byte(r-0x0E01+0xA1))
+		default:
+			return nil, &BankFileError{Reason: fmt.Sprintf("%q can't be written in tis-620", r)}
+		}
+	}
+	return b, nil
+}
+
+// fixed pads the encoded value b to the width of the field. A value that
+// doesn't fit is an error, cutting an account number would send the money
+// elsewhere.
+func (f LayoutField) fixed(b []byte) ([]byte, error) {
+	if len(b) > f.Width {
+		return nil, &BankFileError{Reason: fmt.Sprintf("%s is %d bytes, longer than its field of %d", f.Value, len(b), f.Width)}
+	}
+	pad := f.Pad
+	if pad == "" {
+		pad = " "
+	}
+	fill := bytes.Repeat([]byte(pad), f.Width-len(b))
+	if f.Align == "right" {
+		return append(fill, b...), nil
+	}
+	return append(b, fill...), nil
+}
+
+// WriteBankFile renders batch b in layout l. Lines end with CRLF, as bank
+// upload portals expect. It returns a *BankFileError when a value of b
+// doesn't fit l.
+func WriteBankFile(l BankLayout, b *PaymentBatch) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if l.Format == LayoutCSV {
+		w := csv.NewWriter(buf)
+		w.UseCRLF = true
+		header := []string{}
+		for _, f := range l.Detail {
+			header = append(header, f.Name)
+		}
+		if err := w.Write(header); err != nil {
+			return nil, err
+		}
+		for i := range b.Payments {
+			values := recordValues(l, b, i+1, &b.Payments[i])
+			row := []string{}
+			for _, f := range l.Detail {
+				v, err := f.value(values)
+				if err != nil {
+					return nil, paymentError(b, i, err)
+				}
+				row = append(row, v)
+			}
+			if err := w.Write(row); err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+		return encode(l.Encoding, buf.String())
+	}
+
+	record := func(fs []LayoutField, values map[string]string) error {
+		if len(fs) == 0 {
+			return nil
+		}
+		for _, f := range fs {
+			v, err := f.value(values)
+			if err != nil {
+				return err
+			}
+			e, err := encode(l.Encoding, v)
+			if err != nil {
+				return err
+			}
+			field, err := f.fixed(e)
+			if err != nil {
+				return err
+			}
+			buf.Write(field)
+		}
+		buf.WriteString("\r\n")
+		return nil
+	}
+	if err := record(l.Header, recordValues(l, b, 0, nil)); err != nil {
+		return nil, &BankFileError{Reason: "header: " + err.Error()}
+	}
+	for i := range b.Payments {
+		if err := record(l.Detail, recordValues(l, b, i+1, &b.Payments[i])); err != nil {
+			return nil, paymentError(b, i, err)
+		}
+	}
+	if err := record(l.Trailer, recordValues(l, b, 0, nil)); err != nil {
+		return nil, &BankFileError{Reason: "trailer: " + err.Error()}
+	}
+	return buf.Bytes(), nil
+}
+
+func paymentError(b *PaymentBatch, i int, err error) error {
+	return &BankFileError{Reason: fmt.Sprintf("payment %d to %s: %s", i+1, b.Payments[i].Username, err)}
+}
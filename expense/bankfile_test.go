@@ -0,0 +1,178 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testBatch = PaymentBatch{
+	Id:        7,
+	Currency:  "THB",
+	CreatedAt: time.Date(2023, 1, 5, 9, 0, 0, 0, time.UTC),
+	Payments: []Payment{
+		{Username: "alice", AccountName: "Alice A.", BankCode: "4", AccountNumber: "1234567890", Amount: 350.5},
+		{Username: "bob", AccountName: "Bob B.", BankCode: "14", AccountNumber: "9876543210", Amount: 100},
+	},
+}
+
+func TestWriteBankFileFixed(t *testing.T) {
+	l := thBankLayout
+	l.Constants = map[string]string{"company_account": "555", "company_name": "ACME"}
+
+	file, err := WriteBankFile(l, &testBatch)
+
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(file), "\r\n"), "\r\n")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, "H00000705012023"+"0000000555"+"ACME"+strings.Repeat(" ", 36)+"000002"+"000000000045050", lines[0])
+	assert.Equal(t, "D000001004"+"01234567890"+"000000000035050"+"Alice A."+strings.Repeat(" ", 42)+"EXP7-1"+strings.Repeat(" ", 14), lines[1])
+	assert.Equal(t, "T000002000000000045050", lines[3])
+	for _, line := range lines[1:3] {
+		assert.Len(t, line, 106)
+	}
+}
+
+func TestWriteBankFileCSV(t *testing.T) {
+	file, err := WriteBankFile(csvLayout, &testBatch)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "bank_code,account_number,account_name,amount,reference\r\n"+
+		"4,1234567890,Alice A.,350.50,EXP7-1\r\n"+
+		"14,9876543210,Bob B.,100.00,EXP7-2\r\n", string(file))
+}
+
+func TestLayoutFieldFixed(t *testing.T) {
+	tests := []struct {
+		field LayoutField
+		value string
+		want  string
+	}{
+		{LayoutField{Width: 5}, "ab", "ab   "},
+		{LayoutField{Width: 5, Align: "right", Pad: "0"}, "42", "00042"},
+		{LayoutField{Width: 3}, "abc", "abc"},
+	}
+	for _, tt := range tests {
+		b, err := tt.field.fixed([]byte(tt.value))
+
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, string(b))
+	}
+}
+
+func TestWriteBankFileErrors(t *testing.T) {
+	l := thBankLayout
+	l.Constants = map[string]string{"company_account": "555", "company_name": "ACME"}
+	batch := func(p Payment) *PaymentBatch {
+		p.Username, p.Amount = "alice", 100
+		return &PaymentBatch{Id: 7, Currency: "THB", CreatedAt: testBatch.CreatedAt, Payments: []Payment{p}}
+	}
+
+	t.Run("should refuse an account number longer than its field", func(t *testing.T) {
+		_, err := WriteBankFile(l, batch(Payment{AccountName: "Alice A.", BankCode: "004", AccountNumber: "123456789012"}))
+
+		assert.IsType(t, &BankFileError{}, err)
+		assert.EqualError(t, err, "payment 1 to alice: account_number is 12 bytes, longer than its field of 11")
+	})
+
+	t.Run("should refuse a header without the company", func(t *testing.T) {
+		_, err := WriteBankFile(thBankLayout, batch(Payment{AccountName: "Alice A.", BankCode: "004", AccountNumber: "1234567890"}))
+
+		assert.EqualError(t, err, "header: company_account is empty")
+	})
+
+	t.Run("should count the width of Thai names in tis-620 bytes", func(t *testing.T) {
+		file, err := WriteBankFile(l, batch(Payment{AccountName: "สมชาย ใจดี", BankCode: "004", AccountNumber: "1234567890"}))
+
+		assert.Nil(t, err)
+		lines := bytes.Split(bytes.TrimSuffix(file, []byte("\r\n")), []byte("\r\n"))
+		assert.Len(t, lines[1], 106)
+		assert.Equal(t, []byte{0xCA, 0xC1, 0xAA, 0xD2, 0xC2}, lines[1][36:41])
+	})
+
+	t.Run("should refuse a Thai name longer than its field in utf-8", func(t *testing.T) {
+		u := l
+		u.Encoding = EncodingUTF8
+		_, err := WriteBankFile(u, batch(Payment{AccountName: strings.Repeat("ก", 17), BankCode: "004", AccountNumber: "1234567890"}))
+
+		assert.EqualError(t, err, "payment 1 to alice: account_name is 51 bytes, longer than its field of 50")
+	})
+}
+
+func TestPayeeValidation(t *testing.T) {
+	tests := []struct {
+		payee Payee
+		err   string
+	}{
+		{Payee{AccountName: "Alice A.", BankCode: "004", AccountNumber: "1234567890"}, ""},
+		{Payee{AccountName: "Alice A.", BankCode: "4", AccountNumber: "1234567890"}, "bank_code error : this field should be 3 digits."},
+		{Payee{AccountName: "Alice A.", BankCode: "004", AccountNumber: "12345"}, "account_number error : this field should be 10 to 12 digits."},
+		{Payee{AccountName: "Alice A.", BankCode: "004", AccountNumber: "1234567890123"}, "account_number error : this field should be 10 to 12 digits."},
+	}
+	for _, tt := range tests {
+		err := tt.payee.validation()
+
+		if tt.err == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.EqualError(t, err, tt.err)
+		}
+	}
+}
+
+func TestLoadBankLayouts(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(good, []byte(`[{"name":"test-bank","format":"csv","detail":[{"name":"acc","value":"account_number"}]}]`), 0644)
+	os.WriteFile(bad, []byte(`[{"name":"broken","format":"fixed","detail":[{"value":"account_number"}]}]`), 0644)
+
+	assert.Nil(t, LoadBankLayouts(good))
+	l, ok := bankLayout("test-bank")
+	assert.True(t, ok)
+	assert.Equal(t, LayoutCSV, l.Format)
+
+	err := LoadBankLayouts(bad)
+	assert.EqualError(t, err, bad+": layout broken: field account_number should have a width")
+	_, ok = bankLayout("broken")
+	assert.False(t, ok)
+}
+
+func TestLoadBankLayoutsYAML(t *testing.T) {
+	defer func() { bankLayouts[thBankLayout.Name] = thBankLayout }()
+	path := filepath.Join(t.TempDir(), "layouts.yaml")
+	os.WriteFile(path, []byte(`- name: th-bank
+  format: fixed
+  encoding: tis-620
+  constants:
+    company_account: "1234567890"
+    company_name: ACME
+  detail:
+    - value: account_number
+      width: 11
+      align: right
+      pad: "0"
+`), 0644)
+
+	assert.Nil(t, LoadBankLayouts(path))
+	l, _ := bankLayout("th-bank")
+	assert.Equal(t, "ACME", l.Constants["company_name"])
+	assert.Equal(t, 11, l.Detail[0].Width)
+	assert.Nil(t, CheckBankLayout("th-bank"))
+
+	os.WriteFile(path, []byte("- name: th-bank\n  format: fixed\n  detial: []\n"), 0644)
+	assert.ErrorContains(t, LoadBankLayouts(path), "field detial not found")
+}
+
+func TestCheckBankLayout(t *testing.T) {
+	assert.EqualError(t, CheckBankLayout(DefaultBankLayout), "layout th-bank: constants company_account, company_name should not empty")
+	assert.EqualError(t, CheckBankLayout("swift"), "layout swift is unknown")
+	assert.Nil(t, CheckBankLayout("csv"))
+}
@@ -8,21 +8,66 @@ import (
 )
 
 func CreateExpenses(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionCreate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
 	ex := Expense{}
 	err := c.Bind(&ex)
 
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	ex, err = createExpense(c, ex)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, ex)
+}
+
+// createExpense validates and stores a new expense of the current user. The
+// REST and GraphQL APIs both create expenses through it.
+func createExpense(c echo.Context, ex Expense) (Expense, error) {
+	u := CurrentUser(c)
+	err := ex.validation()
+	if err != nil {
+		return ex, invalid(err)
+	}
+	ex.Owner = u.Username
+	ex.Status = StatusDraft
+	if ex.Currency == "" {
+		ex.Currency = DefaultCurrency
+	}
+	if ex.Date.IsZero() {
+		ex.Date = Today()
+	}
+	if err := Authorize(u, ActionCreate, &ex); err != nil {
+		return ex, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if ex.Payer == "" && len(ex.Participants) > 0 {
+		ex.Payer = ex.Owner
 	}
+	ex.computeSplit()
 
-	err = ex.validation()
+	tx, err := Db.Begin()
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return ex, internalErr("unable to begin transaction", err)
 	}
-	row := Db.QueryRow("INSERT INTO expenses (title, amount, note, tags) VALUES ($1, $2, $3, $4) RETURNING id", ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags))
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO expenses (title, amount, note, tags, owner, payer, split, participants, group_id, currency, date, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, $11, $12) RETURNING id",
+		ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags), ex.Owner, ex.Payer, ex.Split, ex.Participants, ex.GroupId, ex.Currency, ex.Date, ex.Metadata)
 	err = row.Scan(&ex.Id)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		return ex, internalErr("unable to create expense", err)
 	}
-	return c.JSON(http.StatusCreated, ex)
+	if err = recordChange(tx, c, "create", nil, &ex); err != nil {
+		return ex, internalErr("unable to record change", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return ex, internalErr("unable to commit transaction", err)
+	}
+	return ex, nil
 }
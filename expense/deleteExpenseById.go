@@ -0,0 +1,58 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+func DeleteExpensesById(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionDelete, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	if err := deleteExpense(c, c.Param("id")); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteExpense deletes the expense id, which has to be deletable by the
+// current user. The REST and gRPC APIs both delete expenses through it.
+func deleteExpense(c echo.Context, id string) error {
+	u := CurrentUser(c)
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	cur := Expense{}
+	err = scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, id), &cur)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "deleted expense's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan expense", err)
+	}
+	if err := Authorize(u, ActionDelete, &cur); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if cur.locked() {
+		return &Err{Status: http.StatusConflict, Message: "expense is " + cur.Status + " and can't be deleted"}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM expenses WHERE id = $1`, cur.Id); err != nil {
+		return internalErr("unable to delete expense", err)
+	}
+	if err = recordChange(tx, c, "delete", &cur, nil); err != nil {
+		return internalErr("unable to record change", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return nil
+}
@@ -1,30 +1,90 @@
 package expense
 
 import (
-	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/lib/pq"
 )
 
+// DefaultCurrency is given to expenses created without a currency.
+const DefaultCurrency = "THB"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
 type Expense struct {
-	Id     int      `json:"id"`
-	Title  string   `json:"title"`
-	Amount float32  `json:"amount"`
-	Note   string   `json:"note"`
-	Tags   []string `json:"tags"`
+	Id           int          `json:"id"`
+	Title        string       `json:"title"`
+	Amount       float32      `json:"amount"`
+	Note         string       `json:"note"`
+	Tags         []string     `json:"tags"`
+	Owner        string       `json:"owner"`
+	Payer        string       `json:"payer,omitempty"`
+	Split        string       `json:"split,omitempty"`
+	Participants Participants `json:"participants,omitempty"`
+	GroupId      int          `json:"group_id,omitempty"`
+	Status       string       `json:"status"`
+	Currency     string       `json:"currency"`
+	Date         Date         `json:"date"`
+	Metadata     Metadata     `json:"metadata,omitempty"`
 }
 
+// Err is the error handlers return, HTTPErrorHandler turns it into the
+// response. Internal is the cause of a server error; it is logged but never
+// sent to the client.
 type Err struct {
-	Message string `json:"message"`
+	Status   int          `json:"-"`
+	Message  string       `json:"message"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	Internal error        `json:"-"`
+}
+
+func (e *Err) Error() string {
+	if e.Internal != nil {
+		return e.Message + ": " + e.Internal.Error()
+	}
+	return e.Message
+}
+
+func (e *Err) Unwrap() error {
+	return e.Internal
 }
 
+// internalErr is a server error, message says what failed.
+func internalErr(message string, err error) *Err {
+	return &Err{Status: http.StatusInternalServerError, Message: message, Internal: err}
+}
+
+// expenseColumns lists the columns scanned by scanExpense, in order. An
+// expense filed under no group has group id 0.
+const expenseColumns = `id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExpense(row scanner, ex *Expense) error {
+	return row.Scan(&ex.Id, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags), &ex.Owner, &ex.Payer, &ex.Split, &ex.Participants, &ex.GroupId, &ex.Status, &ex.Currency, &ex.Date, &ex.Metadata)
+}
+
+// validation checks every field, then the configured rules, and returns a
+// ValidationError listing all that are wrong.
 func (e *Expense) validation() error {
+	v := ValidationError{}
 	if e.Title == "" {
-		return fmt.Errorf("title error : this field should not empty.")
+		v.add("title", CodeRequired, "title error : this field should not empty.")
 	}
 	if e.Amount < 0 {
-		return fmt.Errorf("amount error : this field should not less than 0.")
+		v.add("amount", CodeMin, "amount error : this field should not less than 0.")
 	}
 	if len(e.Tags) == 0 {
-		return fmt.Errorf("tags error : this field should have at least 1.")
+		v.add("tags", CodeRequired, "tags error : this field should have at least 1.")
+	}
+	if e.Currency != "" && !currencyPattern.MatchString(e.Currency) {
+		v.add("currency", CodeFormat, "currency error : this field should be a 3 letter ISO 4217 code.")
 	}
-	return nil
+	e.validateMetadata(&v)
+	e.validateSplit(&v)
+	currentRules().Check(e, &v)
+	return v.err()
 }
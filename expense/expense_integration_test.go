@@ -30,8 +30,10 @@ const (
 
 func TestCreateExpenses(t *testing.T) {
 	eh := echo.New()
+	eh.HTTPErrorHandler = HTTPErrorHandler
 	go func(e *echo.Echo) {
 		InitTestDb(t)
+		e.Use(asAdmin)
 
 		e.POST("/expenses", CreateExpenses)
 		e.Start(":2565")
@@ -77,8 +79,10 @@ func TestCreateExpenses(t *testing.T) {
 
 func TestGetExpensesById(t *testing.T) {
 	eh := echo.New()
+	eh.HTTPErrorHandler = HTTPErrorHandler
 	go func(e *echo.Echo) {
 		InitTestDb(t)
+		e.Use(asAdmin)
 
 		e.GET("/expenses/:id", GetExpensesById)
 		e.POST("/expenses", CreateExpenses)
@@ -145,8 +149,10 @@ func TestGetExpensesById(t *testing.T) {
 
 func TestUpdateExpensesById(t *testing.T) {
 	eh := echo.New()
+	eh.HTTPErrorHandler = HTTPErrorHandler
 	go func(e *echo.Echo) {
 		InitTestDb(t)
+		e.Use(asAdmin)
 
 		e.PUT("/expenses/:id", UpdateExpensesById)
 		e.POST("/expenses", CreateExpenses)
@@ -197,8 +203,10 @@ func TestUpdateExpensesById(t *testing.T) {
 
 func TestGetExpenses(t *testing.T) {
 	eh := echo.New()
+	eh.HTTPErrorHandler = HTTPErrorHandler
 	go func(e *echo.Echo) {
 		InitTestDb(t)
+		e.Use(asAdmin)
 
 		e.GET("/expenses", GetExpenses)
 		e.POST("/expenses", CreateExpenses)
@@ -244,14 +252,7 @@ func InitTestDb(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	createTb := `CREATE TABLE IF NOT EXISTS expenses(
-		id SERIAL PRIMARY KEY,
-		title TEXT,
-		AMOUNT FLOAT,
-		NOTE TEXT,
-		TAGS TEXT[])`
-
-	_, err = Db.Exec(createTb)
+	err = CreateTables(Db)
 
 	if err != nil {
 		t.Fatal("can not create table expense", err)
@@ -273,6 +274,14 @@ func SeedExpense(t *testing.T, body string) Expense {
 	return ex
 }
 
+// asAdmin stands in for the authentication middleware.
+func asAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		SetCurrentUser(c, User{Username: "admin", Role: RoleAdmin})
+		return next(c)
+	}
+}
+
 type Response struct {
 	*http.Response
 	err error
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/labstack/echo/v4"
@@ -16,6 +17,21 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var testAdmin = User{Username: "admin", Role: RoleAdmin}
+
+// serve runs h as echo does, answering the error it returns with
+// HTTPErrorHandler.
+func serve(h echo.HandlerFunc, c echo.Context) error {
+	if err := h(c); err != nil {
+		HTTPErrorHandler(err, c)
+	}
+	return nil
+}
+
+var testDate = time.Date(2022, 12, 24, 0, 0, 0, 0, time.UTC)
+
+var expenseRows = []string{"id", "title", "amount", "note", "tags", "owner", "payer", "split", "participants", "group_id", "status", "currency", "date", "metadata"}
+
 func TestCreateExpensesValidation(t *testing.T) {
 	t.Run("should return title error : this field should not empty. when title input is empty", func(t *testing.T) {
 		//arrange
@@ -30,10 +46,11 @@ func TestCreateExpensesValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
 		var r Err
 
 		//action
-		err := CreateExpenses(c)
+		err := serve(CreateExpenses, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
@@ -56,10 +73,11 @@ func TestCreateExpensesValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
 		var r Err
 
 		//action
-		err := CreateExpenses(c)
+		err := serve(CreateExpenses, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
@@ -82,10 +100,11 @@ func TestCreateExpensesValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
 		var r Err
 
 		//action
-		err := CreateExpenses(c)
+		err := serve(CreateExpenses, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
@@ -95,11 +114,63 @@ func TestCreateExpensesValidation(t *testing.T) {
 		assert.Equal(t, "tags error : this field should have at least 1.", r.Message)
 	})
 
+	t.Run("should list every field error when several inputs are wrong", func(t *testing.T) {
+		//arrange
+		e := echo.New()
+		reqBody := bytes.NewBufferString(`{
+			"title": "",
+			"amount": -199,
+			"note": "buy a new phone",
+			"tags": [],
+			"currency": "baht"
+		}`)
+		req := httptest.NewRequest(http.MethodPost, "/expenses", reqBody)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
+		var r Err
+
+		//action
+		err := serve(CreateExpenses, c)
+		assert.Nil(t, err)
+		err = json.NewDecoder(rec.Body).Decode(&r)
+
+		//assert
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, []FieldError{
+			{Field: "title", Code: CodeRequired, Message: "title error : this field should not empty."},
+			{Field: "amount", Code: CodeMin, Message: "amount error : this field should not less than 0."},
+			{Field: "tags", Code: CodeRequired, Message: "tags error : this field should have at least 1."},
+			{Field: "currency", Code: CodeFormat, Message: "currency error : this field should be a 3 letter ISO 4217 code."},
+		}, r.Errors)
+		assert.Equal(t, "title error : this field should not empty.; amount error : this field should not less than 0.; "+
+			"tags error : this field should have at least 1.; currency error : this field should be a 3 letter ISO 4217 code.", r.Message)
+	})
+
+}
+
+// mockEditableExpense mocks a database holding expense 1 as a draft of admin,
+// the expense being locked for an update that is rolled back.
+func mockEditableExpense(t *testing.T) sqlmock.Sqlmock {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	mock.ExpectBegin()
+	expectLockedExpense(mock, "admin", StatusDraft)
+	mock.ExpectRollback()
+	return mock
 }
 
 func TestUpdateExpensesByIdValidation(t *testing.T) {
 	t.Run("should return title error : this field should not empty. when title input is empty", func(t *testing.T) {
 		//arrange
+		mock := mockEditableExpense(t)
+		defer Db.Close()
 		e := echo.New()
 		id := "1"
 		reqBody := bytes.NewBufferString(`{
@@ -112,21 +183,27 @@ func TestUpdateExpensesByIdValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
 		var r Err
 
 		//action
-		err := UpdateExpensesById(c)
+		err := serve(UpdateExpensesById, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
 		//assert
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
 		assert.Equal(t, "title error : this field should not empty.", r.Message)
 	})
 
 	t.Run("should return amount error : this field should not less than 0. when amount input is minus value", func(t *testing.T) {
 		//arrange
+		mock := mockEditableExpense(t)
+		defer Db.Close()
 		e := echo.New()
 		id := "1"
 		reqBody := bytes.NewBufferString(`{
@@ -139,21 +216,27 @@ func TestUpdateExpensesByIdValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
 		var r Err
 
 		//action
-		err := UpdateExpensesById(c)
+		err := serve(UpdateExpensesById, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
 		//assert
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
 		assert.Equal(t, "amount error : this field should not less than 0.", r.Message)
 	})
 
 	t.Run("should return tags error : this field should have at least 1. when tags input is empty", func(t *testing.T) {
 		//arrange
+		mock := mockEditableExpense(t)
+		defer Db.Close()
 		e := echo.New()
 		id := "1"
 		reqBody := bytes.NewBufferString(`{
@@ -166,16 +249,20 @@ func TestUpdateExpensesByIdValidation(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
+		SetCurrentUser(c, testAdmin)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
 		var r Err
 
 		//action
-		err := UpdateExpensesById(c)
+		err := serve(UpdateExpensesById, c)
 		assert.Nil(t, err)
 		err = json.NewDecoder(rec.Body).Decode(&r)
 
 		//assert
 		assert.Nil(t, err)
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Nil(t, mock.ExpectationsWereMet())
 		assert.Equal(t, "tags error : this field should have at least 1.", r.Message)
 	})
 }
@@ -193,6 +280,7 @@ func TestCreateExpenseUnit(t *testing.T) {
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
 
 	var mock sqlmock.Sqlmock
 	var err error
@@ -210,12 +298,23 @@ func TestCreateExpenseUnit(t *testing.T) {
 	}
 	defer Db.Close()
 
-	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expenses (title, amount, note, tags) VALUES ($1, $2, $3, $4) RETURNING id`)).
-		WithArgs(md.Title, md.Amount, md.Note, pq.Array(&md.Tags)).
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expenses (title, amount, note, tags, owner, payer, split, participants, group_id, currency, date, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, $11, $12) RETURNING id`)).
+		WithArgs(md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "admin", "", "", Participants(nil), 0, "THB", sqlmock.AnyArg(), Metadata(nil)).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs("admin", "create", 1, "", nil, sqlmock.AnyArg(), pq.Array([]string{"amount", "currency", "date", "id", "note", "owner", "status", "tags", "title"})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WithArgs(1, sqlmock.AnyArg(), "create", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WithArgs(1, "expense.created", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	//action
-	err = CreateExpenses(c)
+	err = serve(CreateExpenses, c)
 	assert.Nil(t, err)
 	err = json.NewDecoder(rec.Body).Decode(&rt)
 
@@ -227,6 +326,7 @@ func TestCreateExpenseUnit(t *testing.T) {
 	assert.Equal(t, float32(39000), rt.Amount)
 	assert.Equal(t, "buy a new phone", rt.Note)
 	assert.Equal(t, []string{"gadget", "shopping"}, rt.Tags)
+	assert.Equal(t, "admin", rt.Owner)
 
 }
 
@@ -237,6 +337,7 @@ func TestGetExpenseByIdUnit(t *testing.T) {
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
 	c.SetPath("/:id")
 	c.SetParamNames("id")
 	c.SetParamValues("1")
@@ -257,14 +358,14 @@ func TestGetExpenseByIdUnit(t *testing.T) {
 	}
 	defer Db.Close()
 
-	prep := mock.ExpectPrepare(regexp.QuoteMeta(`SELECT * FROM expenses WHERE id = $1`))
+	prep := mock.ExpectPrepare(regexp.QuoteMeta(`SELECT id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata FROM expenses WHERE id = $1`))
 
 	prep.ExpectQuery().
 		WithArgs("1").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags)))
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
 
 	//action
-	err = GetExpensesById(c)
+	err = serve(GetExpensesById, c)
 	assert.Nil(t, err)
 	err = json.NewDecoder(rec.Body).Decode(&rt)
 
@@ -291,6 +392,7 @@ func TestUpdateExpensesByIdUnit(t *testing.T) {
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
 	c.SetPath("/:id")
 	c.SetParamNames("id")
 	c.SetParamValues("1")
@@ -311,13 +413,27 @@ func TestUpdateExpensesByIdUnit(t *testing.T) {
 	}
 	defer Db.Close()
 
-	prep := mock.ExpectPrepare(regexp.QuoteMeta(`UPDATE expenses SET title = $1, amount = $2, note = $3, tags = $4 WHERE id = $5 RETURNING *`))
-	prep.ExpectQuery().
-		WithArgs(md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "1").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags)))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "old title", 100, md.Note, pq.Array(&md.Tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE expenses SET title = $1, amount = $2, note = $3, tags = $4, payer = $5, split = $6, participants = $7, group_id = NULLIF($8, 0),
+		currency = COALESCE(NULLIF($9, ''), currency), date = COALESCE($10, date), metadata = $11 WHERE id = $12 RETURNING id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata`)).
+		WithArgs(md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "", "", Participants(nil), 0, "THB", NewDate(testDate), Metadata(nil), 1).
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs("admin", "update", 1, "", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"amount", "title"})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WithArgs(1, sqlmock.AnyArg(), "update", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WithArgs(1, "expense.updated", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	//action
-	err = UpdateExpensesById(c)
+	err = serve(UpdateExpensesById, c)
 	assert.Nil(t, err)
 	err = json.NewDecoder(rec.Body).Decode(&rt)
 
@@ -338,6 +454,7 @@ func TestGetExpensesUnit(t *testing.T) {
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
 
 	var mock sqlmock.Sqlmock
 	var err error
@@ -355,13 +472,13 @@ func TestGetExpensesUnit(t *testing.T) {
 	}
 	defer Db.Close()
 
-	prep := mock.ExpectPrepare(regexp.QuoteMeta(`SELECT * FROM expenses`))
+	prep := mock.ExpectPrepare(regexp.QuoteMeta(`SELECT id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata FROM expenses`))
 
 	prep.ExpectQuery().
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags)))
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, md.Title, md.Amount, md.Note, pq.Array(&md.Tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
 
 	//action
-	err = GetExpenses(c)
+	err = serve(GetExpenses, c)
 	assert.Nil(t, err)
 	err = json.NewDecoder(rec.Body).Decode(&rt)
 
@@ -370,3 +487,144 @@ func TestGetExpensesUnit(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 	assert.NotEqual(t, 0, len(rt))
 }
+
+func TestGetExpensesFilters(t *testing.T) {
+	//arrange
+	setFields([]FieldDefinition{{Name: "project", Type: FieldString}})
+	defer setFields(nil)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/?status=draft&owner=admin&from=2022-12-01&to=2022-12-31&meta.project=apollo", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND status = $3 AND owner = $4 AND date >= $5 AND date <= $6 AND metadata @> $7::jsonb`)).
+		ExpectQuery().
+		WithArgs(true, sqlmock.AnyArg(), StatusDraft, "admin", NewDate(time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)), NewDate(time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC)), `{"project":"apollo"}`).
+		WillReturnRows(sqlmock.NewRows(expenseRows))
+
+	//action
+	err = serve(GetExpenses, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetExpensesPage(t *testing.T) {
+	//arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/expenses?tag=travel&limit=1&after=4", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	rows := sqlmock.NewRows(expenseRows)
+	for _, id := range []int{5, 6} {
+		rows.AddRow(id, "taxi", 250, "", pq.Array([]string{"travel"}), "admin", "", "", []byte("[]"), 0, StatusDraft, "THB", testDate, []byte("{}"))
+	}
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND $3 = ANY(tags) AND id > $4 ORDER BY id LIMIT $5`)).
+		WillBeClosed().
+		ExpectQuery().
+		WithArgs(true, sqlmock.AnyArg(), "travel", 4, 2).
+		WillReturnRows(rows).
+		RowsWillBeClosed()
+	rt := []Expense{}
+
+	//action
+	err = serve(GetExpenses, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&rt)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	if assert.Len(t, rt, 1) {
+		assert.Equal(t, 5, rt[0].Id)
+	}
+	assert.Equal(t, `</v1/expenses?after=5&limit=1&tag=travel>; rel="next"`, rec.Header().Get("Link"))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetExpensesPageLimit(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/expenses?limit=101", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	r := Err{}
+
+	err := serve(GetExpenses, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "limit error : this field should be between 1 and 100.", r.Message)
+}
+
+func TestDeleteExpensesByIdUnit(t *testing.T) {
+	//arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	c.SetPath("/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	var mock sqlmock.Sqlmock
+	var err error
+	tags := []string{"gadget", "shopping"}
+
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "buy a new phone", 39000, "buy a new phone", pq.Array(&tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM expenses WHERE id = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs("admin", "delete", 1, "", sqlmock.AnyArg(), nil, pq.Array([]string{"amount", "currency", "date", "id", "note", "owner", "status", "tags", "title"})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WithArgs(1, sqlmock.AnyArg(), "delete", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WithArgs(1, "expense.deleted", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	//action
+	err = serve(DeleteExpensesById, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
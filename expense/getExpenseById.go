@@ -5,28 +5,45 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
 )
 
 func GetExpensesById(c echo.Context) error {
-	id := c.Param("id")
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
 
-	stmt, err := Db.Prepare(`SELECT * FROM expenses WHERE id = $1`)
+	ex, err := getExpense(u, c.Param("id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "unable to setup query statement" + err.Error()})
+		return err
 	}
+	return c.JSON(http.StatusOK, ex)
+
+}
+
+// getExpense reads the expense id as u sees it. The REST and gRPC APIs both
+// read expenses through it.
+func getExpense(u User, id string) (Expense, error) {
+	stmt, err := Db.Prepare(`SELECT ` + expenseColumns + ` FROM expenses WHERE id = $1`)
+	if err != nil {
+		return Expense{}, internalErr("unable to setup query statement", err)
+	}
+	defer stmt.Close()
 
 	row := stmt.QueryRow(id)
 	ex := Expense{}
 
-	err = row.Scan(&ex.Id, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags))
+	err = scanExpense(row, &ex)
 	switch err {
 	case sql.ErrNoRows:
-		return c.JSON(http.StatusNotFound, Err{Message: "expense's not found"})
+		return ex, &Err{Status: http.StatusNotFound, Message: "expense's not found"}
 	case nil:
-		return c.JSON(http.StatusOK, ex)
+		// expenses of groups the user is not a member of are not visible
+		if Authorize(u, ActionView, &ex) != nil {
+			return Expense{}, &Err{Status: http.StatusNotFound, Message: "expense's not found"}
+		}
+		return ex, nil
 	default:
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't scan expense:" + err.Error()})
+		return ex, internalErr("can't scan expense", err)
 	}
-
 }
@@ -1,31 +1,130 @@
 package expense
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
 func GetExpenses(c echo.Context) error {
-	stmt, err := Db.Prepare(`SELECT * FROM expenses`)
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	f, err := expenseFilterParams(c)
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	where, args, err := f.where(u)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "unable to setup query statement" + err.Error()})
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	limit, err := pageParams(c, &where, &args)
+	if err != nil {
+		return err
 	}
 
-	rows, err := stmt.Query()
+	stmt, err := Db.Prepare(`SELECT ` + expenseColumns + ` FROM expenses WHERE ` + where)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "unable to query statement" + err.Error()})
+		return internalErr("unable to setup query statement", err)
 	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return internalErr("unable to query statement", err)
+	}
+	defer rows.Close()
 
 	exs := []Expense{}
 
 	for rows.Next() {
 		ex := Expense{}
+		err = scanExpense(rows, &ex)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, Err{Message: "unable to scan expense" + err.Error()})
+			return internalErr("unable to scan expense", err)
+		}
+		if limit > 0 && len(exs) == limit {
+			setNextPage(c, exs[len(exs)-1].Id)
+			break
 		}
 		exs = append(exs, ex)
 	}
+	if err := rows.Err(); err != nil {
+		return internalErr("unable to read expenses", err)
+	}
 	return c.JSON(http.StatusOK, exs)
 
 }
+
+// pageParams narrows where to the page asked for by the limit and after
+// query parameters: at most limit expenses in id order, after the expense
+// id after. One more row than limit is asked for, to tell whether there is
+// a next page. It returns 0 when no limit is asked for.
+func pageParams(c echo.Context, where *string, args *[]interface{}) (int, error) {
+	if s := c.QueryParam("after"); s != "" {
+		after, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, &Err{Status: http.StatusBadRequest, Message: "after error : this field should be an expense id."}
+		}
+		*args = append(*args, after)
+		*where += fmt.Sprintf(" AND id > $%d", len(*args))
+	}
+	s := c.QueryParam("limit")
+	if s == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, &Err{Status: http.StatusBadRequest, Message: "limit error : this field should be between 1 and " + strconv.Itoa(maxPageSize) + "."}
+	}
+	*args = append(*args, limit+1)
+	*where += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(*args))
+	return limit, nil
+}
+
+// setNextPage links the response to the page after the expense id last.
+func setNextPage(c echo.Context, last int) {
+	q := c.QueryParams()
+	q.Set("after", strconv.Itoa(last))
+	next := url.URL{Path: c.Request().URL.Path, RawQuery: q.Encode()}
+	c.Response().Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
+
+// expenseFilterParams reads the filter of the expense listing from the
+// query: group, status, tag, owner, currency, from, to and meta.<field>, as
+// in the GraphQL ExpenseFilter.
+func expenseFilterParams(c echo.Context) (*expenseFilterInput, error) {
+	f := &expenseFilterInput{}
+	if g := c.QueryParam("group"); g != "" {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return nil, fmt.Errorf("group error : this field should be a group id.")
+		}
+		group := int32(id)
+		f.Group = &group
+	}
+	for _, p := range []struct {
+		name string
+		v    **string
+	}{{"status", &f.Status}, {"tag", &f.Tag}, {"owner", &f.Owner}, {"currency", &f.Currency}, {"from", &f.From}, {"to", &f.To}} {
+		if s := c.QueryParam(p.name); s != "" {
+			*p.v = &s
+		}
+	}
+	meta := []struct{ Field, Value string }{}
+	for k := range c.QueryParams() {
+		if strings.HasPrefix(k, "meta.") {
+			meta = append(meta, struct{ Field, Value string }{strings.TrimPrefix(k, "meta."), c.QueryParam(k)})
+		}
+	}
+	if len(meta) > 0 {
+		f.Meta = &meta
+	}
+	return f, nil
+}
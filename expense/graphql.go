@@ -0,0 +1,685 @@
+package expense
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/labstack/echo/v4"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const graphqlSchema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+"Any JSON value, used for the custom metadata of expenses."
+scalar JSON
+
+type Query {
+	expense(id: ID!): Expense
+	"Expenses in id order, first at a time (1 to 100) starting after the cursor."
+	expenses(filter: ExpenseFilter, first: Int = 20, after: String): ExpenseConnection!
+	"Count and totals by tag and by owner of the expenses matching filter."
+	summary(filter: ExpenseFilter): Summary!
+}
+
+type Mutation {
+	createExpense(input: ExpenseInput!): Expense!
+	updateExpense(id: ID!, input: ExpenseInput!): Expense!
+}
+
+"Dates are formatted as YYYY-MM-DD, from and to are inclusive."
+input ExpenseFilter {
+	group: Int
+	status: String
+	tag: String
+	owner: String
+	currency: String
+	from: String
+	to: String
+	meta: [MetaFilter!]
+}
+
+input MetaFilter {
+	field: String!
+	value: String!
+}
+
+input ExpenseInput {
+	title: String!
+	amount: Float!
+	note: String
+	tags: [String!]!
+	payer: String
+	split: String
+	participants: [ParticipantInput!]
+	"On update, 0 takes the expense out of its group and null keeps it there."
+	groupId: Int
+	currency: String
+	date: String
+	metadata: JSON
+}
+
+input ParticipantInput {
+	name: String!
+	value: Float
+}
+
+type Expense {
+	id: ID!
+	title: String!
+	amount: Float!
+	note: String!
+	tags: [String!]!
+	owner: String!
+	payer: String
+	split: String
+	participants: [Participant!]!
+	groupId: Int
+	status: String!
+	currency: String!
+	date: String
+	metadata: JSON!
+	transitions: [Transition!]!
+}
+
+type Participant {
+	name: String!
+	value: Float
+	owed: Float!
+}
+
+type Transition {
+	from: String!
+	to: String!
+	comment: String!
+	actor: String!
+	createdAt: String!
+}
+
+type ExpenseConnection {
+	nodes: [Expense!]!
+	pageInfo: PageInfo!
+}
+
+type PageInfo {
+	endCursor: String
+	hasNextPage: Boolean!
+}
+
+type Summary {
+	count: Int!
+	totals: [CurrencyTotal!]!
+	byTag: [SummaryLine!]!
+	byOwner: [SummaryLine!]!
+}
+
+type SummaryLine {
+	key: String!
+	count: Int!
+	totals: [CurrencyTotal!]!
+}
+
+type CurrencyTotal {
+	currency: String!
+	total: Float!
+}
+`
+
+const (
+	maxQueryDepth      = 10
+	maxQueryComplexity = 1000
+	maxPageSize        = 100
+)
+
+var gqlSchema = graphql.MustParseSchema(graphqlSchema, &gqlResolver{}, graphql.MaxDepth(maxQueryDepth))
+
+// gqlRequest is what the resolvers of one GraphQL request share.
+type gqlRequest struct {
+	c           echo.Context
+	expenses    *loader[Expense]
+	transitions *loader[[]Transition]
+}
+
+type gqlRequestKey struct{}
+
+func newGqlRequest(c echo.Context) *gqlRequest {
+	u := CurrentUser(c)
+	return &gqlRequest{
+		c:           c,
+		expenses:    newLoader(func(ids []int) (map[int]Expense, error) { return fetchExpenses(u, ids) }),
+		transitions: newLoader(fetchTransitions),
+	}
+}
+
+func gqlFrom(ctx context.Context) *gqlRequest {
+	return ctx.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// fail turns err into the error of a field. Server errors are logged and
+// not told, as in the REST responses.
+func (r *gqlRequest) fail(err error) error {
+	if e, ok := err.(*Err); ok && e.Status < http.StatusInternalServerError {
+		return e
+	}
+	logServerError(r.c, err)
+	return &Err{Status: http.StatusInternalServerError, Message: "the server couldn't process the request"}
+}
+
+// Extensions gives GraphQL clients the status and the field errors of e.
+func (e *Err) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"status": e.Status}
+	if len(e.Errors) > 0 {
+		ext["errors"] = e.Errors
+	}
+	return ext
+}
+
+// ImplementsGraphQLType makes Metadata the JSON scalar of the GraphQL API.
+func (m *Metadata) ImplementsGraphQLType(name string) bool {
+	return name == "JSON"
+}
+
+// UnmarshalGraphQL reads metadata given in a query. It goes through
+// encoding/json so that numbers are float64, as in the REST API.
+func (m *Metadata) UnmarshalGraphQL(input interface{}) error {
+	if _, ok := input.(map[string]interface{}); !ok {
+		return fmt.Errorf("metadata should be an object")
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, m)
+}
+
+type gqlResolver struct{}
+
+func (*gqlResolver) Expense(ctx context.Context, args struct{ ID graphql.ID }) (*expenseResolver, error) {
+	r := gqlFrom(ctx)
+	id, err := strconv.Atoi(string(args.ID))
+	if err != nil {
+		return nil, &Err{Status: http.StatusBadRequest, Message: "id error : this field should be a number."}
+	}
+	ex, ok, err := r.expenses.load(id)
+	if err != nil {
+		return nil, r.fail(err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &expenseResolver{ex}, nil
+}
+
+type expenseFilterInput struct {
+	Group    *int32
+	Status   *string
+	Tag      *string
+	Owner    *string
+	Currency *string
+	From     *string
+	To       *string
+	Meta     *[]struct{ Field, Value string }
+}
+
+// where is the condition of the expenses u can see that match f.
+func (f *expenseFilterInput) where(u User) (string, []interface{}, error) {
+	where, args := visibleExpenses(u, 1)
+	if f == nil {
+		return where, args, nil
+	}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where += fmt.Sprintf(" AND "+cond, len(args))
+	}
+
+	if f.Group != nil {
+		add("group_id = $%d", int(*f.Group))
+	}
+	for _, c := range []struct {
+		cond string
+		v    *string
+	}{{"status = $%d", f.Status}, {"$%d = ANY(tags)", f.Tag}, {"owner = $%d", f.Owner}, {"currency = $%d", f.Currency}} {
+		if c.v != nil {
+			add(c.cond, *c.v)
+		}
+	}
+	for _, d := range []struct {
+		field, cond string
+		v           *string
+	}{{"from", "date >= $%d", f.From}, {"to", "date <= $%d", f.To}} {
+		if d.v == nil {
+			continue
+		}
+		date, err := ParseDate(*d.v)
+		if err != nil {
+			return "", nil, &Err{Status: http.StatusBadRequest, Message: d.field + " error : this field should be a date as YYYY-MM-DD."}
+		}
+		add(d.cond, date)
+	}
+	if f.Meta != nil {
+		meta := map[string]string{}
+		for _, m := range *f.Meta {
+			meta[m.Field] = m.Value
+		}
+		cond, metaArgs, err := metadataCondition(meta, args)
+		if err != nil {
+			return "", nil, &Err{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+		where, args = where+cond, metaArgs
+	}
+	return where, args, nil
+}
+
+func (*gqlResolver) Expenses(ctx context.Context, args struct {
+	Filter *expenseFilterInput
+	First  int32
+	After  *string
+}) (*expenseConnection, error) {
+	r := gqlFrom(ctx)
+	if args.First < 1 || args.First > maxPageSize {
+		return nil, &Err{Status: http.StatusBadRequest, Message: "first error : this field should be between 1 and " + strconv.Itoa(maxPageSize) + "."}
+	}
+	where, params, err := args.Filter.where(CurrentUser(r.c))
+	if err != nil {
+		return nil, err
+	}
+	if args.After != nil {
+		after, err := decodeCursor(*args.After)
+		if err != nil {
+			return nil, &Err{Status: http.StatusBadRequest, Message: "after error : this field should be an endCursor."}
+		}
+		params = append(params, after)
+		where += fmt.Sprintf(" AND id > $%d", len(params))
+	}
+	params = append(params, args.First+1)
+
+	rows, err := Db.QueryContext(ctx, `SELECT `+expenseColumns+` FROM expenses WHERE `+where+fmt.Sprintf(` ORDER BY id LIMIT $%d`, len(params)), params...)
+	if err != nil {
+		return nil, r.fail(internalErr("unable to query expenses", err))
+	}
+	defer rows.Close()
+
+	conn := &expenseConnection{}
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return nil, r.fail(internalErr("unable to scan expense", err))
+		}
+		if len(conn.nodes) == int(args.First) {
+			conn.hasNext = true
+			break
+		}
+		conn.nodes = append(conn.nodes, &expenseResolver{ex})
+	}
+	return conn, nil
+}
+
+func (*gqlResolver) Summary(ctx context.Context, args struct{ Filter *expenseFilterInput }) (*summaryResolver, error) {
+	r := gqlFrom(ctx)
+	where, params, err := args.Filter.where(CurrentUser(r.c))
+	if err != nil {
+		return nil, err
+	}
+	s, err := summarize(where, params...)
+	if err != nil {
+		return nil, r.fail(internalErr("unable to summarize expenses", err))
+	}
+	return &summaryResolver{s}, nil
+}
+
+type expenseInput struct {
+	Title        string
+	Amount       float64
+	Note         *string
+	Tags         []string
+	Payer        *string
+	Split        *string
+	Participants *[]struct {
+		Name  string
+		Value *float64
+	}
+	GroupId  *int32
+	Currency *string
+	Date     *string
+	Metadata *Metadata
+}
+
+// expense is the Expense in, as the REST API would have bound it.
+func (in *expenseInput) expense() (Expense, error) {
+	ex := Expense{Title: in.Title, Amount: float32(in.Amount), Tags: in.Tags}
+	for _, s := range []struct {
+		to   *string
+		from *string
+	}{{&ex.Note, in.Note}, {&ex.Payer, in.Payer}, {&ex.Split, in.Split}, {&ex.Currency, in.Currency}} {
+		if s.from != nil {
+			*s.to = *s.from
+		}
+	}
+	if in.Participants != nil {
+		for _, p := range *in.Participants {
+			pt := Participant{Name: p.Name}
+			if p.Value != nil {
+				pt.Value = *p.Value
+			}
+			ex.Participants = append(ex.Participants, pt)
+		}
+	}
+	if in.GroupId != nil {
+		ex.GroupId = int(*in.GroupId)
+	}
+	if in.Metadata != nil {
+		ex.Metadata = *in.Metadata
+	}
+	if in.Date != nil && *in.Date != "" {
+		d, err := ParseDate(*in.Date)
+		if err != nil {
+			v := ValidationError{}
+			v.add("date", CodeFormat, "date error : this field should be a date as YYYY-MM-DD.")
+			return ex, invalid(v)
+		}
+		ex.Date = d
+	}
+	return ex, nil
+}
+
+func (*gqlResolver) CreateExpense(ctx context.Context, args struct{ Input expenseInput }) (*expenseResolver, error) {
+	r := gqlFrom(ctx)
+	if err := Authorize(CurrentUser(r.c), ActionCreate, nil); err != nil {
+		return nil, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	ex, err := args.Input.expense()
+	if err != nil {
+		return nil, err
+	}
+	if ex, err = createExpense(r.c, ex); err != nil {
+		return nil, r.fail(err)
+	}
+	return &expenseResolver{ex}, nil
+}
+
+func (*gqlResolver) UpdateExpense(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input expenseInput
+}) (*expenseResolver, error) {
+	r := gqlFrom(ctx)
+	if err := Authorize(CurrentUser(r.c), ActionUpdate, nil); err != nil {
+		return nil, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if _, err := strconv.Atoi(string(args.ID)); err != nil {
+		return nil, &Err{Status: http.StatusBadRequest, Message: "id error : this field should be a number."}
+	}
+	ex, err := args.Input.expense()
+	if err != nil {
+		return nil, err
+	}
+	var groupId *int
+	if args.Input.GroupId != nil {
+		groupId = &ex.GroupId
+	}
+	if ex, err = editExpense(r.c, string(args.ID), ex, groupId); err != nil {
+		return nil, r.fail(err)
+	}
+	return &expenseResolver{ex}, nil
+}
+
+type expenseResolver struct{ ex Expense }
+
+func (r *expenseResolver) ID() graphql.ID     { return graphql.ID(strconv.Itoa(r.ex.Id)) }
+func (r *expenseResolver) Title() string      { return r.ex.Title }
+func (r *expenseResolver) Amount() float64    { return float64(r.ex.Amount) }
+func (r *expenseResolver) Note() string       { return r.ex.Note }
+func (r *expenseResolver) Tags() []string     { return r.ex.Tags }
+func (r *expenseResolver) Owner() string      { return r.ex.Owner }
+func (r *expenseResolver) Payer() *string     { return optional(r.ex.Payer) }
+func (r *expenseResolver) Split() *string     { return optional(r.ex.Split) }
+func (r *expenseResolver) Status() string     { return r.ex.Status }
+func (r *expenseResolver) Currency() string   { return r.ex.Currency }
+func (r *expenseResolver) Date() *string      { return optional(r.ex.Date.String()) }
+func (r *expenseResolver) Metadata() Metadata { return r.ex.Metadata }
+
+func (r *expenseResolver) GroupId() *int32 {
+	if r.ex.GroupId == 0 {
+		return nil
+	}
+	id := int32(r.ex.GroupId)
+	return &id
+}
+
+func (r *expenseResolver) Participants() []*participantResolver {
+	ps := []*participantResolver{}
+	for _, p := range r.ex.Participants {
+		ps = append(ps, &participantResolver{p})
+	}
+	return ps
+}
+
+// Transitions are loaded for all the expenses of a response at once.
+func (r *expenseResolver) Transitions(ctx context.Context) ([]*transitionResolver, error) {
+	req := gqlFrom(ctx)
+	ts, _, err := req.transitions.load(r.ex.Id)
+	if err != nil {
+		return nil, req.fail(err)
+	}
+	trs := []*transitionResolver{}
+	for _, t := range ts {
+		trs = append(trs, &transitionResolver{t})
+	}
+	return trs, nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type participantResolver struct{ p Participant }
+
+func (r *participantResolver) Name() string  { return r.p.Name }
+func (r *participantResolver) Owed() float64 { return r.p.Owed }
+
+func (r *participantResolver) Value() *float64 {
+	if r.p.Value == 0 {
+		return nil
+	}
+	return &r.p.Value
+}
+
+type transitionResolver struct{ t Transition }
+
+func (r *transitionResolver) From() string      { return r.t.From }
+func (r *transitionResolver) To() string        { return r.t.To }
+func (r *transitionResolver) Comment() string   { return r.t.Comment }
+func (r *transitionResolver) Actor() string     { return r.t.Actor }
+func (r *transitionResolver) CreatedAt() string { return r.t.CreatedAt.Format(time.RFC3339) }
+
+type expenseConnection struct {
+	nodes   []*expenseResolver
+	hasNext bool
+}
+
+func (c *expenseConnection) Nodes() []*expenseResolver { return c.nodes }
+func (c *expenseConnection) PageInfo() *pageInfo {
+	p := &pageInfo{hasNext: c.hasNext}
+	if len(c.nodes) > 0 {
+		p.endCursor = optional(encodeCursor(c.nodes[len(c.nodes)-1].ex.Id))
+	}
+	return p
+}
+
+type pageInfo struct {
+	endCursor *string
+	hasNext   bool
+}
+
+func (p *pageInfo) EndCursor() *string { return p.endCursor }
+func (p *pageInfo) HasNextPage() bool  { return p.hasNext }
+
+// Cursors are the id of the last expense of a page, opaque to clients.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("expense:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	_, err = fmt.Sscanf(string(b), "expense:%d", &id)
+	return id, err
+}
+
+type summaryResolver struct{ s Summary }
+
+func (r *summaryResolver) Count() int32 { return int32(r.s.Count) }
+func (r *summaryResolver) Totals() []*currencyTotalResolver {
+	return currencyTotals(r.s.Totals)
+}
+func (r *summaryResolver) ByTag() []*summaryLineResolver { return summaryLines(r.s.ByTag) }
+func (r *summaryResolver) ByOwner() []*summaryLineResolver {
+	return summaryLines(r.s.ByOwner)
+}
+
+func summaryLines(ls []SummaryLine) []*summaryLineResolver {
+	rs := []*summaryLineResolver{}
+	for _, l := range ls {
+		rs = append(rs, &summaryLineResolver{l})
+	}
+	return rs
+}
+
+type summaryLineResolver struct{ l SummaryLine }
+
+func (r *summaryLineResolver) Key() string  { return r.l.Key }
+func (r *summaryLineResolver) Count() int32 { return int32(r.l.Count) }
+func (r *summaryLineResolver) Totals() []*currencyTotalResolver {
+	return currencyTotals(r.l.Totals)
+}
+
+// currencyTotals lists totals in the order of their currencies.
+func currencyTotals(totals map[string]float64) []*currencyTotalResolver {
+	rs := []*currencyTotalResolver{}
+	for _, cur := range currencies(totals) {
+		rs = append(rs, &currencyTotalResolver{cur, totals[cur]})
+	}
+	return rs
+}
+
+type currencyTotalResolver struct {
+	currency string
+	total    float64
+}
+
+func (r *currencyTotalResolver) Currency() string { return r.currency }
+func (r *currencyTotalResolver) Total() float64   { return r.total }
+
+// queryComplexity scores the operation of query: each field costs 1, and the
+// fields under a page of expenses cost once per expense of the page.
+func queryComplexity(query, operationName string, variables map[string]interface{}) (int, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return 0, err
+	}
+	op := doc.Operations.ForName(operationName)
+	if op == nil {
+		return 0, fmt.Errorf("no operation %q in the query", operationName)
+	}
+	return selectionComplexity(doc, op.SelectionSet, variables, map[string]bool{}), nil
+}
+
+func selectionComplexity(doc *ast.QueryDocument, set ast.SelectionSet, variables map[string]interface{}, spreading map[string]bool) int {
+	n := 0
+	for _, sel := range set {
+		switch s := sel.(type) {
+		case *ast.Field:
+			children := selectionComplexity(doc, s.SelectionSet, variables, spreading)
+			if s.Name == "expenses" {
+				children *= pageSize(s, variables)
+			}
+			n += 1 + children
+		case *ast.InlineFragment:
+			n += selectionComplexity(doc, s.SelectionSet, variables, spreading)
+		case *ast.FragmentSpread:
+			f := doc.Fragments.ForName(s.Name)
+			if f == nil || spreading[s.Name] {
+				continue
+			}
+			spreading[s.Name] = true
+			n += selectionComplexity(doc, f.SelectionSet, variables, spreading)
+			delete(spreading, s.Name)
+		}
+	}
+	return n
+}
+
+// pageSize is the first argument of a page of expenses, its default when
+// not given.
+func pageSize(f *ast.Field, variables map[string]interface{}) int {
+	a := f.Arguments.ForName("first")
+	if a == nil {
+		return 20
+	}
+	v, err := a.Value.Value(variables)
+	if err != nil {
+		return maxPageSize
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return maxPageSize
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL answers the queries and mutations of the GraphQL API. Errors of
+// the query are answered as GraphQL errors with status 200, errors of the
+// request itself as problems.
+func GraphQL(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	req := graphqlRequest{}
+	if err := c.Bind(&req); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if req.Query == "" {
+		return &Err{Status: http.StatusBadRequest, Message: "query error : this field should not empty."}
+	}
+
+	if errs := gqlSchema.ValidateWithVariables(req.Query, req.Variables); len(errs) > 0 {
+		return c.JSON(http.StatusOK, &graphql.Response{Errors: errs})
+	}
+	n, err := queryComplexity(req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		return c.JSON(http.StatusOK, &graphql.Response{Errors: []*gqlerrors.QueryError{gqlerrors.Errorf("%s", err)}})
+	}
+	if n > maxQueryComplexity {
+		return c.JSON(http.StatusOK, &graphql.Response{Errors: []*gqlerrors.QueryError{
+			gqlerrors.Errorf("query complexity %d is more than the limit of %d", n, maxQueryComplexity),
+		}})
+	}
+
+	ctx := context.WithValue(c.Request().Context(), gqlRequestKey{}, newGqlRequest(c))
+	return c.JSON(http.StatusOK, gqlSchema.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
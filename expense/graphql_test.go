@@ -0,0 +1,147 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+type graphqlResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func postGraphQL(t *testing.T, query string, variables map[string]interface{}, u User) graphqlResponse {
+	body, _ := json.Marshal(graphqlRequest{Query: query, Variables: variables})
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, u)
+
+	err := serve(GraphQL, c)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := graphqlResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal("unable to decode response", err)
+	}
+	return res
+}
+
+func addExpenseRow(rows *sqlmock.Rows, id int, title string) *sqlmock.Rows {
+	return rows.AddRow(id, title, 250, "", pq.Array([]string{"travel"}), "admin", "", "", []byte("[]"), 0, StatusDraft, "THB", testDate, []byte("{}"))
+}
+
+func TestGraphQLBatchesExpenses(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	rows := sqlmock.NewRows(expenseRows)
+	addExpenseRow(rows, 1, "taxi")
+	addExpenseRow(rows, 2, "lunch")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = ANY($1)`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(rows)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_transitions WHERE expense_id = ANY($1)`)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "expense_id", "from_status", "to_status", "comment", "actor", "created_at"}).
+			AddRow(1, 2, StatusDraft, StatusSubmitted, "", "admin", time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	//action
+	res := postGraphQL(t, `{
+		a: expense(id: "1") { title transitions { to } }
+		b: expense(id: "2") { title transitions { to createdAt } }
+		c: expense(id: "3") { title }
+	}`, nil, testAdmin)
+
+	//assert
+	assert.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"title":"taxi","transitions":[]}`, string(res.Data["a"]))
+	assert.JSONEq(t, `{"title":"lunch","transitions":[{"to":"submitted","createdAt":"2023-01-02T03:04:05Z"}]}`, string(res.Data["b"]))
+	assert.Equal(t, "null", string(res.Data["c"]))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGraphQLExpensesPage(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	rows := sqlmock.NewRows(expenseRows)
+	addExpenseRow(rows, 5, "taxi")
+	addExpenseRow(rows, 6, "lunch")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND $3 = ANY(tags) AND date >= $4 AND id > $5 ORDER BY id LIMIT $6`)).
+		WithArgs(true, sqlmock.AnyArg(), "travel", NewDate(testDate), 4, 2).
+		WillReturnRows(rows)
+
+	//action
+	res := postGraphQL(t, `{
+		expenses(first: 1, after: "`+encodeCursor(4)+`", filter: {tag: "travel", from: "2022-12-24"}) {
+			nodes { id title }
+			pageInfo { endCursor hasNextPage }
+		}
+	}`, nil, testAdmin)
+
+	//assert
+	assert.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"nodes":[{"id":"5","title":"taxi"}],"pageInfo":{"endCursor":"`+encodeCursor(5)+`","hasNextPage":true}}`, string(res.Data["expenses"]))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGraphQLComplexityLimit(t *testing.T) {
+	res := postGraphQL(t, `{
+		expenses(first: 100) {
+			nodes { id title amount note tags owner status currency date metadata transitions { from to actor } }
+		}
+	}`, nil, testAdmin)
+
+	assert.Len(t, res.Errors, 1)
+	assert.Equal(t, "query complexity 1501 is more than the limit of 1000", res.Errors[0].Message)
+}
+
+func TestGraphQLCreateExpenseValidation(t *testing.T) {
+	res := postGraphQL(t, `mutation($input: ExpenseInput!) {
+		createExpense(input: $input) { id }
+	}`, map[string]interface{}{"input": map[string]interface{}{"title": "", "amount": -1, "tags": []string{}}}, testAdmin)
+
+	assert.Len(t, res.Errors, 1)
+	assert.Equal(t, "title error : this field should not empty.; amount error : this field should not less than 0.; tags error : this field should have at least 1.", res.Errors[0].Message)
+	assert.Equal(t, float64(http.StatusBadRequest), res.Errors[0].Extensions["status"])
+	assert.Len(t, res.Errors[0].Extensions["errors"], 3)
+}
+
+func TestGraphQLCreateExpenseAsViewer(t *testing.T) {
+	res := postGraphQL(t, `mutation {
+		createExpense(input: {title: "taxi", amount: 250, tags: ["travel"]}) { id }
+	}`, nil, User{Username: "bob", Role: RoleViewer})
+
+	assert.Len(t, res.Errors, 1)
+	assert.Equal(t, float64(http.StatusForbidden), res.Errors[0].Extensions["status"])
+}
@@ -0,0 +1,273 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+// Invitation asks a user to join a group. The user becomes a member once
+// the invitation is accepted.
+type Invitation struct {
+	Id        int       `json:"id"`
+	GroupId   int       `json:"group_id"`
+	Group     string    `json:"group"`
+	Username  string    `json:"username"`
+	InvitedBy string    `json:"invited_by"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// visibleExpenses is the condition on the expenses table matching what u may
+// see: every expense for admins, otherwise the expenses filed under no group
+// or under one of u's groups. Its arguments are numbered from n. It holds as
+// well for the other tables with a group_id, such as settlements.
+func visibleExpenses(u User, n int) (string, []interface{}) {
+	return fmt.Sprintf(`($%d OR group_id IS NULL OR group_id = ANY($%d))`, n, n+1),
+		[]interface{}{u.EffectiveRole() == RoleAdmin, pq.Array(u.GroupIds)}
+}
+
+// visibleSnapshots is the condition matching the JSON snapshots of expenses
+// in columns u may see, as visibleExpenses does for the expenses themselves.
+// A snapshot without a group_id, like those of other entities, is visible.
+func visibleSnapshots(u User, n int, columns ...string) (string, []interface{}) {
+	conds := make([]string, len(columns))
+	for i, col := range columns {
+		conds[i] = fmt.Sprintf(`COALESCE((%s->>'group_id')::int = ANY($%d), true)`, col, n+1)
+	}
+	return fmt.Sprintf(`($%d OR (%s))`, n, strings.Join(conds, ` AND `)),
+		[]interface{}{u.EffectiveRole() == RoleAdmin, pq.Array(u.GroupIds)}
+}
+
+// groupFilter is what u may see of a table with a group_id, narrowed to
+// ?group= when it is given.
+func groupFilter(c echo.Context, u User) (string, []interface{}, error) {
+	where, args := visibleExpenses(u, 1)
+	if g := c.QueryParam("group"); g != "" {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return "", nil, fmt.Errorf("group error : this field should be a group id.")
+		}
+		args = append(args, id)
+		where += fmt.Sprintf(` AND group_id = $%d`, len(args))
+	}
+	return where, args, nil
+}
+
+// expenseFilter is the condition of the expense listings: what u may see,
+// narrowed to ?group= and ?meta.<field>= when they are given.
+func expenseFilter(c echo.Context, u User) (string, []interface{}, error) {
+	where, args, err := groupFilter(c, u)
+	if err != nil {
+		return "", nil, err
+	}
+	meta, args, err := metadataFilter(c, args)
+	if err != nil {
+		return "", nil, err
+	}
+	return where + meta, args, nil
+}
+
+// groupParam reads the group id in the path and checks that u may access the
+// group.
+func groupParam(c echo.Context, u User) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, &Err{Status: http.StatusBadRequest, Message: "id error : this field should be a group id."}
+	}
+	if err := AuthorizeGroup(u, id); err != nil {
+		return 0, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	return id, nil
+}
+
+func GetGroupMembers(c echo.Context) error {
+	id, err := groupParam(c, CurrentUser(c))
+	if err != nil {
+		return err
+	}
+
+	rows, err := Db.Query(`SELECT username FROM group_members WHERE group_id = $1 ORDER BY username`, id)
+	if err != nil {
+		return internalErr("unable to query members", err)
+	}
+	defer rows.Close()
+
+	ms := []string{}
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return internalErr("unable to scan member", err)
+		}
+		ms = append(ms, m)
+	}
+	return c.JSON(http.StatusOK, ms)
+}
+
+// RemoveGroupMember takes a user out of a group. Members may leave, admins
+// may remove anyone.
+func RemoveGroupMember(c echo.Context) error {
+	u := CurrentUser(c)
+	id, err := groupParam(c, u)
+	if err != nil {
+		return err
+	}
+	username := c.Param("username")
+	if username != u.Username {
+		if err := Authorize(u, ActionManageUsers, nil); err != nil {
+			return &Err{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+
+	res, err := Db.Exec(`DELETE FROM group_members WHERE group_id = $1 AND username = $2`, id, username)
+	if err != nil {
+		return internalErr("unable to remove member", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "member's not found"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// InviteGroupMember invites a user to a group. Any member may invite to a
+// group without a role, groups granting a role are managed by admins.
+func InviteGroupMember(c echo.Context) error {
+	u := CurrentUser(c)
+	id, err := groupParam(c, u)
+	if err != nil {
+		return err
+	}
+
+	inv := Invitation{}
+	if err := c.Bind(&inv); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if inv.Username == "" {
+		return &Err{Status: http.StatusBadRequest, Message: "username error : this field should not empty."}
+	}
+
+	var role Role
+	err = Db.QueryRow(`SELECT name, role FROM groups WHERE id = $1`, id).Scan(&inv.Group, &role)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "group's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan group", err)
+	}
+	if role != "" {
+		if err := Authorize(u, ActionManageUsers, nil); err != nil {
+			return &Err{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+
+	var member bool
+	err = Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM group_members WHERE group_id = $1 AND username = $2)`, id, inv.Username).Scan(&member)
+	if err != nil {
+		return internalErr("unable to query members", err)
+	}
+	if member {
+		return &Err{Status: http.StatusConflict, Message: "user " + inv.Username + " is already a member"}
+	}
+
+	inv.GroupId, inv.InvitedBy, inv.Status = id, u.Username, InvitationPending
+	err = Db.QueryRow(`INSERT INTO group_invitations (group_id, username, invited_by) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		inv.GroupId, inv.Username, inv.InvitedBy).Scan(&inv.Id, &inv.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code.Name() {
+			case "unique_violation":
+				return &Err{Status: http.StatusConflict, Message: "user " + inv.Username + " is already invited"}
+			case "foreign_key_violation":
+				return &Err{Status: http.StatusNotFound, Message: "user's not found"}
+			}
+		}
+		return internalErr("unable to create invitation", err)
+	}
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// GetInvitations lists the pending invitations of the current user.
+func GetInvitations(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(`SELECT i.id, i.group_id, g.name, i.username, i.invited_by, i.status, i.created_at
+		FROM group_invitations i JOIN groups g ON g.id = i.group_id
+		WHERE i.username = $1 AND i.status = 'pending' ORDER BY i.id`, u.Username)
+	if err != nil {
+		return internalErr("unable to query invitations", err)
+	}
+	defer rows.Close()
+
+	invs := []Invitation{}
+	for rows.Next() {
+		inv := Invitation{}
+		if err := rows.Scan(&inv.Id, &inv.GroupId, &inv.Group, &inv.Username, &inv.InvitedBy, &inv.Status, &inv.CreatedAt); err != nil {
+			return internalErr("unable to scan invitation", err)
+		}
+		invs = append(invs, inv)
+	}
+	return c.JSON(http.StatusOK, invs)
+}
+
+func AcceptInvitation(c echo.Context) error {
+	return respondInvitation(c, InvitationAccepted)
+}
+
+func DeclineInvitation(c echo.Context) error {
+	return respondInvitation(c, InvitationDeclined)
+}
+
+// respondInvitation closes a pending invitation of the current user and, when
+// it is accepted, adds the user to the group.
+func respondInvitation(c echo.Context, status string) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	inv := Invitation{}
+	err = tx.QueryRow(`UPDATE group_invitations SET status = $3, responded_at = now()
+		WHERE id = $1 AND username = $2 AND status = 'pending'
+		RETURNING id, group_id, username, invited_by, status, created_at`, c.Param("id"), u.Username, status).
+		Scan(&inv.Id, &inv.GroupId, &inv.Username, &inv.InvitedBy, &inv.Status, &inv.CreatedAt)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "invitation's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan invitation", err)
+	}
+
+	if status == InvitationAccepted {
+		_, err = tx.Exec(`INSERT INTO group_members (group_id, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, inv.GroupId, inv.Username)
+		if err != nil {
+			return internalErr("unable to add member", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, inv)
+}
@@ -0,0 +1,389 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetExpensesByIdHidesOtherGroups(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE id = $1`)).
+		ExpectQuery().
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "rent", 12000, "", pq.Array([]string{"home"}), "bob", "", "", []byte("[]"), 7, "draft", "THB", testDate, []byte("{}")))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleEditor, GroupIds: []int64{8}})
+	c.SetPath("/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	//action
+	err = serve(GetExpensesById, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetExpensesFiltersByMembership(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND group_id = $3`)).
+		ExpectQuery().
+		WithArgs(false, pq.Array([]int64{7}), 7).
+		WillReturnRows(sqlmock.NewRows(expenseRows))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses?group=7", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleEditor, GroupIds: []int64{7}})
+
+	//action
+	err = serve(GetExpenses, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetGroupReportUnit(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT currency, COUNT(*), SUM(amount) FROM expenses WHERE group_id = $1 GROUP BY currency`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "count", "total"}).AddRow("THB", 2, 150).AddRow("USD", 1, 20))
+	mock.ExpectQuery(regexp.QuoteMeta(`UNNEST(tags) AS tag WHERE group_id = $1 GROUP BY tag, currency`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "currency", "count", "total"}).AddRow("food", "THB", 2, 150).AddRow("food", "USD", 1, 20))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE group_id = $1 GROUP BY owner, currency`)).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "currency", "count", "total"}).AddRow("alice", "THB", 1, 50).AddRow("alice", "USD", 1, 20).AddRow("bob", "THB", 1, 100))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer, GroupIds: []int64{7}})
+	c.SetPath("/groups/:id/report")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+	var s Summary
+
+	//action
+	err = serve(GetGroupReport, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&s)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 3, s.Count)
+	assert.Equal(t, map[string]float64{"THB": 150, "USD": 20}, s.Totals)
+	assert.Equal(t, []SummaryLine{{"food", 3, map[string]float64{"THB": 150, "USD": 20}}}, s.ByTag)
+	assert.Equal(t, []SummaryLine{{"alice", 2, map[string]float64{"THB": 50, "USD": 20}}, {"bob", 1, map[string]float64{"THB": 100}}}, s.ByOwner)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSummaryFilters(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	where := `WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND status = $3 AND date >= $4`
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT currency, COUNT(*), SUM(amount) FROM expenses `+where+` GROUP BY currency`)).
+		WithArgs(false, pq.Array([]int64{}), StatusApproved, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "count", "total"}).AddRow("THB", 1, 50))
+	mock.ExpectQuery(regexp.QuoteMeta(`UNNEST(tags) AS tag `+where+` GROUP BY tag, currency`)).
+		WithArgs(false, pq.Array([]int64{}), StatusApproved, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "currency", "count", "total"}))
+	mock.ExpectQuery(regexp.QuoteMeta(where+` GROUP BY owner, currency`)).
+		WithArgs(false, pq.Array([]int64{}), StatusApproved, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "currency", "count", "total"}))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses/summary?status=approved&from=2024-01-01", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer, GroupIds: []int64{}})
+	var s Summary
+
+	//action
+	err = serve(GetSummary, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&s)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, s.Count)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSummaryRowError(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT currency, COUNT(*), SUM(amount) FROM expenses WHERE`)).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "count", "total"}).AddRow("THB", 1, 50).AddRow("USD", 1, 20).RowError(1, errors.New("pq: canceling statement due to statement timeout")))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses/summary", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+
+	//action
+	err = serve(GetSummary, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetGroupReportForbidden(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleApprover})
+	c.SetPath("/groups/:id/report")
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	err := serve(GetGroupReport, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE group_invitations SET status = $3`)).
+		WithArgs("3", "alice", InvitationAccepted).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "group_id", "username", "invited_by", "status", "created_at"}).
+			AddRow(3, 7, "alice", "bob", InvitationAccepted, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO group_members (group_id, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`)).
+		WithArgs(7, "alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer})
+	c.SetPath("/invitations/:id/accept")
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+
+	//action
+	err = serve(AcceptInvitation, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateExpenseGroup(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		body  string
+		group int
+	}{
+		{"should keep the group when group_id is left out", `{"title": "rent", "amount": 12000, "tags": ["home"]}`, 7},
+		{"should take the expense out of its group on group_id 0", `{"title": "rent", "amount": 12000, "tags": ["home"], "group_id": 0}`, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//arrange
+			var mock sqlmock.Sqlmock
+			var err error
+			Db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal("unable to create mock db", err)
+			}
+			defer Db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+				WithArgs("1").
+				WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "rent", 11000, "", pq.Array([]string{"home"}), "alice", "", "", []byte("[]"), 7, "draft", "THB", testDate, []byte("{}")))
+			mock.ExpectQuery(regexp.QuoteMeta(`UPDATE expenses SET`)).
+				WithArgs("rent", float32(12000), "", pq.Array([]string{"home"}), "", "", Participants(nil), tc.group, "THB", NewDate(testDate), Metadata(nil), 1).
+				WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "rent", 12000, "", pq.Array([]string{"home"}), "alice", "", "", []byte("[]"), tc.group, "draft", "THB", testDate, []byte("{}")))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			SetCurrentUser(c, User{Username: "alice", Role: RoleEditor, GroupIds: []int64{7}})
+			c.SetPath("/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+
+			//action
+			err = serve(UpdateExpensesById, c)
+
+			//assert
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetSettlementsFiltersByMembership(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM settlements WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND group_id = $3 ORDER BY id`)).
+		WithArgs(false, pq.Array([]int64{7}), 7).
+		WillReturnRows(sqlmock.NewRows(settlementRows).
+			AddRow(1, "bob", "alice", 300, "THB", "", 7, "alice", time.Now()))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/settlements?group=7", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer, GroupIds: []int64{7}})
+	sts := []Settlement{}
+
+	//action
+	err = serve(GetSettlements, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&sts)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	if assert.Len(t, sts, 1) {
+		assert.Equal(t, 7, sts[0].GroupId)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateSettlementInOtherGroup(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/settlements", strings.NewReader(`{"from": "bob", "to": "alice", "amount": 300, "group_id": 8}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleEditor, GroupIds: []int64{7}})
+
+	err := serve(CreateSettlement, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestCreateSettlementOfOthers(t *testing.T) {
+	cases := []struct {
+		name string
+		user User
+		code int
+	}{
+		{"editor can't record a settlement between others", User{Username: "alice", Role: RoleEditor}, http.StatusForbidden},
+		{"approver can record a settlement between others", User{Username: "dave", Role: RoleApprover}, http.StatusCreated},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mock sqlmock.Sqlmock
+			var err error
+			Db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal("unable to create mock db", err)
+			}
+			defer Db.Close()
+			mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO settlements`)).
+				WithArgs("bob", "carol", 300.0, DefaultCurrency, "", 0, tc.user.Username).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/settlements", strings.NewReader(`{"from": "bob", "to": "carol", "amount": 300}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			SetCurrentUser(c, tc.user)
+
+			err = serve(CreateSettlement, c)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.code, rec.Code)
+			if tc.code == http.StatusForbidden {
+				assert.Contains(t, rec.Body.String(), `role editor can only record settlements it takes part in, not from \"bob\" to \"carol\"`)
+			}
+		})
+	}
+}
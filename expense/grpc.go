@@ -0,0 +1,287 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Suvisuttikasame/assessment/expensepb"
+	"github.com/labstack/echo/v4"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ExpenseServer serves the gRPC ExpenseService. Its calls run with the
+// echo.Context the auth interceptors store on their context, so they go
+// through the validation, policy checks and audit trail of the REST API.
+type ExpenseServer struct {
+	expensepb.UnimplementedExpenseServiceServer
+}
+
+var callEcho = echo.New()
+
+// NewCallContext returns the echo.Context a gRPC call of method runs with.
+// requestId is written to the audit log as for HTTP requests. The address of
+// the peer is the remote address of the request, so c.RealIP() is the client
+// of the call.
+func NewCallContext(ctx context.Context, method, requestId string) echo.Context {
+	req := (&http.Request{Method: http.MethodPost, URL: &url.URL{Path: method}, Header: http.Header{}}).WithContext(ctx)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+	req.Header.Set(echo.HeaderXRequestID, requestId)
+	c := callEcho.NewContext(req, callResponse{http.Header{}})
+	SetLogger(c, slog.Default().With(slog.String("request_id", requestId), slog.String("rpc", method)))
+	return c
+}
+
+// callResponse keeps the headers set on a call context, what is written to
+// it is dropped.
+type callResponse struct {
+	header http.Header
+}
+
+func (r callResponse) Header() http.Header       { return r.header }
+func (callResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (callResponse) WriteHeader(int)             {}
+
+type callContextKey struct{}
+
+// WithCallContext returns ctx carrying c for the ExpenseServer methods.
+func WithCallContext(ctx context.Context, c echo.Context) context.Context {
+	return context.WithValue(ctx, callContextKey{}, c)
+}
+
+// CallContext returns the echo.Context stored on ctx. A call that wasn't
+// authenticated gets one without a user, which every policy check refuses.
+func CallContext(ctx context.Context) echo.Context {
+	if c, ok := ctx.Value(callContextKey{}).(echo.Context); ok {
+		return c
+	}
+	return NewCallContext(ctx, "", "")
+}
+
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.FailedPrecondition,
+	http.StatusPreconditionFailed:  codes.FailedPrecondition,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusNotImplemented:      codes.Unimplemented,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// GRPCError turns err into the status of a gRPC call, with the field errors
+// of a validation failure as BadRequest details. Server errors are logged
+// and not told, as in the REST responses.
+func GRPCError(c echo.Context, err error) error {
+	e := &Err{Status: http.StatusInternalServerError}
+	var he *echo.HTTPError
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &he):
+		e = &Err{Status: he.Code, Message: fmt.Sprint(he.Message)}
+	}
+	if e.Status >= http.StatusInternalServerError {
+		logServerError(c, err)
+		e = &Err{Status: http.StatusInternalServerError, Message: "the server couldn't process the request"}
+	}
+
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Unknown
+		if e.Status >= http.StatusInternalServerError {
+			code = codes.Internal
+		}
+	}
+	s := status.New(code, e.Message)
+	if len(e.Errors) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, f := range e.Errors {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: f.Message})
+		}
+		if d, err := s.WithDetails(br); err == nil {
+			s = d
+		}
+	}
+	return s.Err()
+}
+
+func (ExpenseServer) Create(ctx context.Context, req *expensepb.CreateExpenseRequest) (*expensepb.Expense, error) {
+	c := CallContext(ctx)
+	if err := Authorize(CurrentUser(c), ActionCreate, nil); err != nil {
+		return nil, GRPCError(c, &Err{Status: http.StatusForbidden, Message: err.Error()})
+	}
+	ex, err := expenseFromProto(req.GetExpense())
+	if err != nil {
+		return nil, GRPCError(c, err)
+	}
+	if ex, err = createExpense(c, ex); err != nil {
+		return nil, GRPCError(c, err)
+	}
+	return expenseToProto(c, ex)
+}
+
+func (ExpenseServer) Get(ctx context.Context, req *expensepb.GetExpenseRequest) (*expensepb.Expense, error) {
+	c := CallContext(ctx)
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return nil, GRPCError(c, &Err{Status: http.StatusForbidden, Message: err.Error()})
+	}
+	ex, err := getExpense(u, strconv.FormatInt(req.GetId(), 10))
+	if err != nil {
+		return nil, GRPCError(c, err)
+	}
+	return expenseToProto(c, ex)
+}
+
+func (ExpenseServer) List(req *expensepb.ListExpensesRequest, stream expensepb.ExpenseService_ListServer) error {
+	c := CallContext(stream.Context())
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return GRPCError(c, &Err{Status: http.StatusForbidden, Message: err.Error()})
+	}
+
+	f := &expenseFilterInput{Status: req.Status, Tag: req.Tag, Owner: req.Owner, Currency: req.Currency, From: req.From, To: req.To}
+	if req.GroupId != nil {
+		g := int32(*req.GroupId)
+		f.Group = &g
+	}
+	if len(req.Meta) > 0 {
+		meta := []struct{ Field, Value string }{}
+		for field, value := range req.Meta {
+			meta = append(meta, struct{ Field, Value string }{field, value})
+		}
+		f.Meta = &meta
+	}
+	where, args, err := f.where(u)
+	if err != nil {
+		return GRPCError(c, err)
+	}
+
+	rows, err := Db.QueryContext(stream.Context(), `SELECT `+expenseColumns+` FROM expenses WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return GRPCError(c, internalErr("unable to query expenses", err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return GRPCError(c, internalErr("unable to scan expense", err))
+		}
+		p, err := expenseToProto(c, ex)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(p); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return GRPCError(c, internalErr("unable to read expenses", err))
+	}
+	return nil
+}
+
+func (ExpenseServer) Update(ctx context.Context, req *expensepb.UpdateExpenseRequest) (*expensepb.Expense, error) {
+	c := CallContext(ctx)
+	if err := Authorize(CurrentUser(c), ActionUpdate, nil); err != nil {
+		return nil, GRPCError(c, &Err{Status: http.StatusForbidden, Message: err.Error()})
+	}
+	b, err := expenseFromProto(req.GetExpense())
+	if err != nil {
+		return nil, GRPCError(c, err)
+	}
+	var groupId *int
+	if req.GroupId != nil {
+		g := int(req.GetGroupId())
+		groupId = &g
+	}
+	ex, err := editExpense(c, strconv.FormatInt(req.GetId(), 10), b, groupId)
+	if err != nil {
+		return nil, GRPCError(c, err)
+	}
+	return expenseToProto(c, ex)
+}
+
+func (ExpenseServer) Delete(ctx context.Context, req *expensepb.DeleteExpenseRequest) (*emptypb.Empty, error) {
+	c := CallContext(ctx)
+	if err := Authorize(CurrentUser(c), ActionDelete, nil); err != nil {
+		return nil, GRPCError(c, &Err{Status: http.StatusForbidden, Message: err.Error()})
+	}
+	if err := deleteExpense(c, strconv.FormatInt(req.GetId(), 10)); err != nil {
+		return nil, GRPCError(c, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func expenseToProto(c echo.Context, ex Expense) (*expensepb.Expense, error) {
+	p := &expensepb.Expense{
+		Id:       int64(ex.Id),
+		Title:    ex.Title,
+		Amount:   ex.Amount,
+		Note:     ex.Note,
+		Tags:     ex.Tags,
+		Owner:    ex.Owner,
+		Payer:    ex.Payer,
+		Split:    ex.Split,
+		GroupId:  int64(ex.GroupId),
+		Status:   ex.Status,
+		Currency: ex.Currency,
+		Date:     ex.Date.String(),
+	}
+	for _, pa := range ex.Participants {
+		p.Participants = append(p.Participants, &expensepb.Participant{Name: pa.Name, Value: pa.Value, Owed: pa.Owed})
+	}
+	if ex.Metadata != nil {
+		m, err := structpb.NewStruct(ex.Metadata)
+		if err != nil {
+			return nil, GRPCError(c, internalErr("unable to convert metadata", err))
+		}
+		p.Metadata = m
+	}
+	return p, nil
+}
+
+// expenseFromProto reads the fields of p a client may set. A missing p is
+// an empty expense, which validation refuses.
+func expenseFromProto(p *expensepb.Expense) (Expense, error) {
+	ex := Expense{
+		Title:    p.GetTitle(),
+		Amount:   p.GetAmount(),
+		Note:     p.GetNote(),
+		Tags:     p.GetTags(),
+		Payer:    p.GetPayer(),
+		Split:    p.GetSplit(),
+		GroupId:  int(p.GetGroupId()),
+		Currency: p.GetCurrency(),
+	}
+	for _, pa := range p.GetParticipants() {
+		ex.Participants = append(ex.Participants, Participant{Name: pa.GetName(), Value: pa.GetValue(), Owed: pa.GetOwed()})
+	}
+	if p.GetMetadata() != nil {
+		ex.Metadata = p.GetMetadata().AsMap()
+	}
+	if p.GetDate() != "" {
+		d, err := ParseDate(p.GetDate())
+		if err != nil {
+			v := ValidationError{}
+			v.add("date", CodeFormat, "date error : this field should be a date as YYYY-MM-DD.")
+			return ex, invalid(v)
+		}
+		ex.Date = d
+	}
+	return ex, nil
+}
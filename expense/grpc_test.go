@@ -0,0 +1,156 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Suvisuttikasame/assessment/expensepb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func callAs(u User) context.Context {
+	c := NewCallContext(context.Background(), expensepb.ExpenseService_Get_FullMethodName, "test-request")
+	SetCurrentUser(c, u)
+	return WithCallContext(context.Background(), c)
+}
+
+// listStream collects what List sends.
+type listStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*expensepb.Expense
+}
+
+func (s *listStream) Context() context.Context { return s.ctx }
+
+func (s *listStream) Send(ex *expensepb.Expense) error {
+	s.sent = append(s.sent, ex)
+	return nil
+}
+
+func TestGRPCGetExpense(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE id = $1`)).
+		ExpectQuery().
+		WithArgs("7").
+		WillReturnRows(addExpenseRow(sqlmock.NewRows(expenseRows), 7, "taxi"))
+
+	//action
+	ex, err := ExpenseServer{}.Get(callAs(testAdmin), &expensepb.GetExpenseRequest{Id: 7})
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), ex.Id)
+	assert.Equal(t, "taxi", ex.Title)
+	assert.Equal(t, []string{"travel"}, ex.Tags)
+	assert.Equal(t, "2022-12-24", ex.Date)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGRPCGetExpenseNotFound(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE id = $1`)).
+		ExpectQuery().
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows(expenseRows))
+
+	//action
+	_, err = ExpenseServer{}.Get(callAs(testAdmin), &expensepb.GetExpenseRequest{Id: 7})
+
+	//assert
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "expense's not found", status.Convert(err).Message())
+}
+
+func TestGRPCCreateExpenseValidation(t *testing.T) {
+	_, err := ExpenseServer{}.Create(callAs(testAdmin), &expensepb.CreateExpenseRequest{Expense: &expensepb.Expense{Amount: -1}})
+
+	s := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, s.Code())
+	assert.Equal(t, "title error : this field should not empty.; amount error : this field should not less than 0.; tags error : this field should have at least 1.", s.Message())
+	if assert.Len(t, s.Details(), 1) {
+		br := s.Details()[0].(*errdetails.BadRequest)
+		assert.Len(t, br.FieldViolations, 3)
+		assert.Equal(t, "title", br.FieldViolations[0].Field)
+	}
+}
+
+func TestGRPCCreateExpenseBadDate(t *testing.T) {
+	_, err := ExpenseServer{}.Create(callAs(testAdmin), &expensepb.CreateExpenseRequest{Expense: &expensepb.Expense{Title: "taxi", Tags: []string{"travel"}, Date: "24/12/2022"}})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "date error : this field should be a date as YYYY-MM-DD.", status.Convert(err).Message())
+}
+
+func TestGRPCWithoutUser(t *testing.T) {
+	_, err := ExpenseServer{}.Delete(context.Background(), &expensepb.DeleteExpenseRequest{Id: 1})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCListExpenses(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	rows := sqlmock.NewRows(expenseRows)
+	addExpenseRow(rows, 1, "taxi")
+	addExpenseRow(rows, 2, "train")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND $3 = ANY(tags) AND date <= $4 ORDER BY id`)).
+		WithArgs(true, sqlmock.AnyArg(), "travel", NewDate(testDate)).
+		WillReturnRows(rows)
+	tag, to := "travel", "2022-12-24"
+	stream := &listStream{ctx: callAs(testAdmin)}
+
+	//action
+	err = ExpenseServer{}.List(&expensepb.ListExpensesRequest{Tag: &tag, To: &to}, stream)
+
+	//assert
+	assert.Nil(t, err)
+	if assert.Len(t, stream.sent, 2) {
+		assert.Equal(t, "taxi", stream.sent[0].Title)
+		assert.Equal(t, "train", stream.sent[1].Title)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGRPCErrorHidesServerErrors(t *testing.T) {
+	c := NewCallContext(context.Background(), expensepb.ExpenseService_Get_FullMethodName, "test-request")
+
+	err := GRPCError(c, internalErr("unable to query expenses", errors.New("connection refused")))
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "the server couldn't process the request", status.Convert(err).Message())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(GRPCError(c, &Err{Status: http.StatusTooManyRequests, Message: "slow down"})))
+}
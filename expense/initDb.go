@@ -2,30 +2,39 @@ package expense
 
 import (
 	"database/sql"
-	"log"
 
 	_ "github.com/lib/pq"
 )
 
 var Db *sql.DB
 
-func InitDb(url string) {
+// defaultAdminPassword is given to the admin account created on an empty
+// users table so that the service stays reachable after the first start.
+const defaultAdminPassword = "admin"
+
+// OpenDb connects Db to the database at url, without changing its schema.
+func OpenDb(url string) error {
 	var err error
 	Db, err = sql.Open("postgres", url)
-	if err != nil {
-		log.Fatal("Db connection error", err)
-	}
-
-	createTb := `CREATE TABLE IF NOT EXISTS expenses(
-					id SERIAL PRIMARY KEY,
-					title TEXT,
-					AMOUNT FLOAT,
-					NOTE TEXT,
-					TAGS TEXT[])`
+	return err
+}
 
-	_, err = Db.Exec(createTb)
+// CreateTables applies the pending migrations to db and creates the default
+// admin account.
+func CreateTables(db *sql.DB) error {
+	if _, err := MigrateUp(db); err != nil {
+		return err
+	}
+	return createAdmin(db)
+}
 
+// createAdmin creates the admin account when there are no users.
+func createAdmin(db *sql.DB) error {
+	hash, err := hashPassword(defaultAdminPassword)
 	if err != nil {
-		log.Fatal("can not create table expense", err)
+		return err
 	}
+	_, err = db.Exec(`INSERT INTO users (username, password, role)
+		SELECT 'admin', $1, 'admin' WHERE NOT EXISTS (SELECT 1 FROM users)`, hash)
+	return err
 }
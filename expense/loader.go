@@ -0,0 +1,110 @@
+package expense
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// loaderWait is how long a loader collects keys before fetching them. GraphQL
+// resolves sibling fields concurrently, so the keys they ask for within it
+// are fetched with one query.
+const loaderWait = 2 * time.Millisecond
+
+// loader batches the keys asked for within wait into one call of fetch and
+// caches what it returns. A loader lives for one request, so nothing is
+// cached across requests.
+type loader[V any] struct {
+	fetch func(keys []int) (map[int]V, error)
+	wait  time.Duration
+
+	mu    sync.Mutex
+	next  *loaderBatch[V]
+	cache map[int]*loaderBatch[V]
+}
+
+type loaderBatch[V any] struct {
+	keys   []int
+	done   chan struct{}
+	values map[int]V
+	err    error
+}
+
+func newLoader[V any](fetch func(keys []int) (map[int]V, error)) *loader[V] {
+	return &loader[V]{fetch: fetch, wait: loaderWait, cache: map[int]*loaderBatch[V]{}}
+}
+
+// load returns the value of key, and false when fetch found none.
+func (l *loader[V]) load(key int) (V, bool, error) {
+	l.mu.Lock()
+	b, ok := l.cache[key]
+	if !ok {
+		if l.next == nil {
+			l.next = &loaderBatch[V]{done: make(chan struct{})}
+			next := l.next
+			time.AfterFunc(l.wait, func() { l.dispatch(next) })
+		}
+		b = l.next
+		b.keys = append(b.keys, key)
+		l.cache[key] = b
+	}
+	l.mu.Unlock()
+
+	<-b.done
+	v, found := b.values[key]
+	return v, found, b.err
+}
+
+func (l *loader[V]) dispatch(b *loaderBatch[V]) {
+	l.mu.Lock()
+	if l.next == b {
+		l.next = nil
+	}
+	l.mu.Unlock()
+
+	b.values, b.err = l.fetch(b.keys)
+	close(b.done)
+}
+
+// fetchExpenses reads the expenses with the given ids. Those u can't see are
+// left out, as if they didn't exist.
+func fetchExpenses(u User, ids []int) (map[int]Expense, error) {
+	rows, err := Db.Query(`SELECT `+expenseColumns+` FROM expenses WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exs := map[int]Expense{}
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return nil, err
+		}
+		if Authorize(u, ActionView, &ex) == nil {
+			exs[ex.Id] = ex
+		}
+	}
+	return exs, rows.Err()
+}
+
+// fetchTransitions reads the workflow history of the given expenses.
+func fetchTransitions(ids []int) (map[int][]Transition, error) {
+	rows, err := Db.Query(`SELECT id, expense_id, from_status, to_status, comment, actor, created_at
+		FROM expense_transitions WHERE expense_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ts := map[int][]Transition{}
+	for rows.Next() {
+		t := Transition{}
+		if err := rows.Scan(&t.Id, &t.ExpenseId, &t.From, &t.To, &t.Comment, &t.Actor, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		ts[t.ExpenseId] = append(ts[t.ExpenseId], t)
+	}
+	return ts, rows.Err()
+}
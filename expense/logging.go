@@ -0,0 +1,43 @@
+package expense
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const loggerKey = "logger"
+
+// Logger returns the logger of the request c, which carries its request ID,
+// or the default logger when the request has none.
+func Logger(c echo.Context) *slog.Logger {
+	if l, ok := c.Get(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// SetLogger stores l on c for the handlers that run after it.
+func SetLogger(c echo.Context, l *slog.Logger) {
+	c.Set(loggerKey, l)
+}
+
+// ErrorAttrs describes err for the log. A Postgres error also gets its
+// code, detail and where it happened, which its message leaves out.
+func ErrorAttrs(err error) []interface{} {
+	attrs := []interface{}{slog.String("error", err.Error())}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		attrs = append(attrs, slog.Group("db",
+			slog.String("code", string(pqErr.Code)),
+			slog.String("condition", pqErr.Code.Name()),
+			slog.String("detail", pqErr.Detail),
+			slog.String("table", pqErr.Table),
+			slog.String("column", pqErr.Column),
+			slog.String("constraint", pqErr.Constraint),
+		))
+	}
+	return attrs
+}
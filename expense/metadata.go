@@ -0,0 +1,357 @@
+package expense
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	FieldDate    = "date"
+)
+
+// Metadata holds the custom fields of an expense, as defined by the field
+// definitions.
+type Metadata map[string]interface{}
+
+func (m *Metadata) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return fmt.Errorf("can't scan %T into metadata", src)
+}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// FieldDefinition describes a custom field expenses may carry in their
+// metadata. Enum limits a string or number field to the values listed.
+type FieldDefinition struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Enum     []string `json:"enum,omitempty"`
+}
+
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+func (f *FieldDefinition) validation() error {
+	if !fieldNamePattern.MatchString(f.Name) {
+		return fmt.Errorf("name error : this field should be lower case letters, digits and _.")
+	}
+	switch f.Type {
+	case FieldString, FieldNumber:
+	case FieldBoolean, FieldDate:
+		if len(f.Enum) > 0 {
+			return fmt.Errorf("enum error : a %s field can't have an enum.", f.Type)
+		}
+	default:
+		return fmt.Errorf("type error : this field should be string, number, boolean or date.")
+	}
+	if f.Type == FieldNumber {
+		for _, v := range f.Enum {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("enum error : %q is not a number.", v)
+			}
+		}
+	}
+	return nil
+}
+
+// check adds to ve when v is not a valid value of the field.
+func (f *FieldDefinition) check(v interface{}, ve *ValidationError) {
+	field := "metadata." + f.Name
+	s := ""
+	switch f.Type {
+	case FieldString:
+		str, ok := v.(string)
+		if !ok {
+			ve.add(field, CodeType, fmt.Sprintf("metadata error : %s should be a string.", f.Name))
+			return
+		}
+		s = str
+	case FieldNumber:
+		n, ok := v.(float64)
+		if !ok {
+			ve.add(field, CodeType, fmt.Sprintf("metadata error : %s should be a number.", f.Name))
+			return
+		}
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+	case FieldBoolean:
+		if _, ok := v.(bool); !ok {
+			ve.add(field, CodeType, fmt.Sprintf("metadata error : %s should be true or false.", f.Name))
+		}
+		return
+	case FieldDate:
+		str, ok := v.(string)
+		if _, err := ParseDate(str); !ok || err != nil {
+			ve.add(field, CodeFormat, fmt.Sprintf("metadata error : %s should be a date as YYYY-MM-DD.", f.Name))
+		}
+		return
+	}
+	if len(f.Enum) > 0 && !f.allows(s) {
+		ve.add(field, CodeEnum, fmt.Sprintf("metadata error : %s should be one of %s.", f.Name, strings.Join(f.Enum, ", ")))
+	}
+}
+
+func (f *FieldDefinition) allows(s string) bool {
+	for _, v := range f.Enum {
+		if v == s {
+			return true
+		}
+		if f.Type == FieldNumber {
+			a, _ := strconv.ParseFloat(v, 64)
+			if b, err := strconv.ParseFloat(s, 64); err == nil && a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fields caches the field definitions so that validation doesn't read them
+// for every expense. RefreshFields keeps it in step with other replicas.
+var (
+	fieldsMu sync.RWMutex
+	fields   = map[string]FieldDefinition{}
+)
+
+func setFields(fs []FieldDefinition) {
+	m := make(map[string]FieldDefinition, len(fs))
+	for _, f := range fs {
+		m[f.Name] = f
+	}
+	fieldsMu.Lock()
+	fields = m
+	fieldsMu.Unlock()
+}
+
+func queryFields() ([]FieldDefinition, error) {
+	rows, err := Db.Query(`SELECT name, type, required, enum FROM field_definitions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fs := []FieldDefinition{}
+	for rows.Next() {
+		f := FieldDefinition{}
+		if err := rows.Scan(&f.Name, &f.Type, &f.Required, pq.Array(&f.Enum)); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, rows.Err()
+}
+
+// LoadFields reads the field definitions into the cache.
+func LoadFields() error {
+	fs, err := queryFields()
+	if err != nil {
+		return err
+	}
+	setFields(fs)
+	return nil
+}
+
+// RefreshFields reloads the field definitions every interval until ctx is
+// done, picking up the changes made through other replicas.
+func RefreshFields(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := LoadFields(); err != nil {
+			slog.Error("field definitions refresh error", ErrorAttrs(err)...)
+		}
+	}
+}
+
+func (e *Expense) validateMetadata(v *ValidationError) {
+	fieldsMu.RLock()
+	defer fieldsMu.RUnlock()
+
+	names := make([]string, 0, len(e.Metadata))
+	for name := range e.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := fields[name]
+		if !ok {
+			v.add("metadata."+name, CodeUnknown, fmt.Sprintf("metadata error : %s is not a defined field.", name))
+			continue
+		}
+		f.check(e.Metadata[name], v)
+	}
+
+	required := []string{}
+	for _, f := range fields {
+		if _, ok := e.Metadata[f.Name]; f.Required && !ok {
+			required = append(required, f.Name)
+		}
+	}
+	sort.Strings(required)
+	for _, name := range required {
+		v.add("metadata."+name, CodeRequired, fmt.Sprintf("metadata error : %s should not empty.", name))
+	}
+}
+
+// metadataFilter narrows the expense listings to the ?meta.<field>= query
+// parameters, args holds the arguments of the condition so far.
+func metadataFilter(c echo.Context, args []interface{}) (string, []interface{}, error) {
+	meta := map[string]string{}
+	for k := range c.QueryParams() {
+		if strings.HasPrefix(k, "meta.") {
+			meta[strings.TrimPrefix(k, "meta.")] = c.QueryParam(k)
+		}
+	}
+	return metadataCondition(meta, args)
+}
+
+// metadataCondition is the condition of expenses whose metadata has the
+// values in meta. The values are typed from the field definitions and matched
+// with @>, which the GIN index on metadata serves.
+func metadataCondition(meta map[string]string, args []interface{}) (string, []interface{}, error) {
+	if len(meta) == 0 {
+		return "", args, nil
+	}
+	fieldsMu.RLock()
+	defer fieldsMu.RUnlock()
+
+	names := []string{}
+	for name := range meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	want := Metadata{}
+	for _, name := range names {
+		f, ok := fields[name]
+		if !ok {
+			return "", nil, fmt.Errorf("meta.%s error : this field should name a metadata field.", name)
+		}
+		v, err := f.parse(meta[name])
+		if err != nil {
+			return "", nil, fmt.Errorf("meta.%s error : %s", name, err)
+		}
+		want[name] = v
+	}
+	b, err := json.Marshal(want)
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, string(b))
+	return fmt.Sprintf(` AND metadata @> $%d::jsonb`, len(args)), args, nil
+}
+
+// parse reads s, a query parameter, as a value of the field.
+func (f *FieldDefinition) parse(s string) (interface{}, error) {
+	switch f.Type {
+	case FieldNumber:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("this field should be a number.")
+		}
+		return n, nil
+	case FieldBoolean:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("this field should be true or false.")
+		}
+		return b, nil
+	case FieldDate:
+		if _, err := ParseDate(s); err != nil {
+			return nil, fmt.Errorf("this field should be a date as YYYY-MM-DD.")
+		}
+	}
+	return s, nil
+}
+
+func GetFields(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	fs, err := queryFields()
+	if err != nil {
+		return internalErr("unable to query fields", err)
+	}
+	return c.JSON(http.StatusOK, fs)
+}
+
+// SaveField creates or replaces the definition of the field in the path.
+// Expenses saved before keep their metadata, the definition applies the next
+// time they are written.
+func SaveField(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageFields, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	f := FieldDefinition{}
+	if err := c.Bind(&f); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	f.Name = c.Param("name")
+	if err := f.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	_, err := Db.Exec(`INSERT INTO field_definitions (name, type, required, enum) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (name) DO UPDATE SET type = $2, required = $3, enum = $4`, f.Name, f.Type, f.Required, pq.Array(f.Enum))
+	if err != nil {
+		return internalErr("unable to save field", err)
+	}
+	if err := LoadFields(); err != nil {
+		return internalErr("unable to load fields", err)
+	}
+	return c.JSON(http.StatusOK, f)
+}
+
+func DeleteField(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageFields, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	res, err := Db.Exec(`DELETE FROM field_definitions WHERE name = $1`, c.Param("name"))
+	if err != nil {
+		return internalErr("unable to delete field", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "field's not found"}
+	}
+	if err := LoadFields(); err != nil {
+		return internalErr("unable to load fields", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	setFields([]FieldDefinition{
+		{Name: "project", Type: FieldString, Required: true, Enum: []string{"ABC", "XYZ"}},
+		{Name: "invoice_no", Type: FieldNumber},
+		{Name: "billable", Type: FieldBoolean},
+		{Name: "due", Type: FieldDate},
+	})
+	defer setFields(nil)
+
+	tests := []struct {
+		name     string
+		metadata Metadata
+		want     string
+	}{
+		{"valid", Metadata{"project": "ABC", "invoice_no": float64(42), "billable": true, "due": "2023-01-31"}, ""},
+		{"required", Metadata{"invoice_no": float64(42)}, "metadata error : project should not empty."},
+		{"unknown", Metadata{"project": "ABC", "vendor": "x"}, "metadata error : vendor is not a defined field."},
+		{"enum", Metadata{"project": "DEF"}, "metadata error : project should be one of ABC, XYZ."},
+		{"number", Metadata{"project": "ABC", "invoice_no": "42"}, "metadata error : invoice_no should be a number."},
+		{"boolean", Metadata{"project": "ABC", "billable": "yes"}, "metadata error : billable should be true or false."},
+		{"date", Metadata{"project": "ABC", "due": "31/01/2023"}, "metadata error : due should be a date as YYYY-MM-DD."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ex := Expense{Title: "taxi", Amount: 100, Tags: []string{"travel"}, Metadata: tt.metadata}
+
+			err := ex.validation()
+
+			if tt.want == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMetadataListsEveryField(t *testing.T) {
+	setFields([]FieldDefinition{
+		{Name: "project", Type: FieldString, Required: true},
+		{Name: "vendor", Type: FieldString, Required: true},
+		{Name: "invoice_no", Type: FieldNumber},
+	})
+	defer setFields(nil)
+	ex := Expense{Title: "taxi", Amount: 100, Tags: []string{"travel"}, Metadata: Metadata{"invoice_no": "42", "other": 1.0}}
+
+	err := ex.validation()
+
+	assert.Equal(t, ValidationError{
+		{Field: "metadata.invoice_no", Code: CodeType, Message: "metadata error : invoice_no should be a number."},
+		{Field: "metadata.other", Code: CodeUnknown, Message: "metadata error : other is not a defined field."},
+		{Field: "metadata.project", Code: CodeRequired, Message: "metadata error : project should not empty."},
+		{Field: "metadata.vendor", Code: CodeRequired, Message: "metadata error : vendor should not empty."},
+	}, err)
+}
+
+func TestFieldDefinitionValidation(t *testing.T) {
+	assert.Nil(t, (&FieldDefinition{Name: "cost_center", Type: FieldNumber, Enum: []string{"100", "200.5"}}).validation())
+	assert.EqualError(t, (&FieldDefinition{Name: "Project", Type: FieldString}).validation(), "name error : this field should be lower case letters, digits and _.")
+	assert.EqualError(t, (&FieldDefinition{Name: "due", Type: FieldDate, Enum: []string{"x"}}).validation(), "enum error : a date field can't have an enum.")
+	assert.EqualError(t, (&FieldDefinition{Name: "code", Type: FieldNumber, Enum: []string{"ten"}}).validation(), `enum error : "ten" is not a number.`)
+	assert.EqualError(t, (&FieldDefinition{Name: "code", Type: "list"}).validation(), "type error : this field should be string, number, boolean or date.")
+}
+
+func TestGetExpensesByMetadata(t *testing.T) {
+	//arrange
+	setFields([]FieldDefinition{{Name: "project", Type: FieldString}, {Name: "nights", Type: FieldNumber}})
+	defer setFields(nil)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses?meta.project=ABC&meta.nights=2&group=2", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	prep := mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE ($1 OR group_id IS NULL OR group_id = ANY($2)) AND group_id = $3 AND metadata @> $4::jsonb`))
+	prep.ExpectQuery().
+		WithArgs(true, sqlmock.AnyArg(), 2, `{"nights":2,"project":"ABC"}`).
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), "admin", "", "", []byte("[]"), 2, "draft", "THB", testDate, []byte(`{"project":"ABC"}`)))
+	rt := []Expense{}
+
+	//action
+	err = serve(GetExpenses, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&rt)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, Metadata{"project": "ABC"}, rt[0].Metadata)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetExpensesByBadMetadataField(t *testing.T) {
+	setFields([]FieldDefinition{{Name: "nights", Type: FieldNumber}})
+	defer setFields(nil)
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{"meta.project'--=ABC", "meta.project'-- error : this field should name a metadata field."},
+		{"meta.project=ABC", "meta.project error : this field should name a metadata field."},
+		{"meta.nights=two", "meta.nights error : this field should be a number."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/expenses?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			SetCurrentUser(c, testAdmin)
+
+			err := serve(GetExpenses, c)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.message)
+		})
+	}
+}
@@ -0,0 +1,602 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a step of the schema. Its Up statements must be idempotent,
+// databases created before migrations were versioned got them all already.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+	// Drops names the records reverting the migration destroys for good.
+	// MigrateDown stops before such a migration unless told to go on.
+	Drops string
+}
+
+// migrations are applied in order of version, never change one that is
+// released: add a new one.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create expenses",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS expenses(
+					id SERIAL PRIMARY KEY,
+					title TEXT,
+					AMOUNT FLOAT,
+					NOTE TEXT,
+					TAGS TEXT[])`,
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS expenses`,
+		},
+		Drops: "the expenses",
+	},
+	{
+		Version: 2,
+		Name:    "create users and groups",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS users(
+					username TEXT PRIMARY KEY,
+					password TEXT NOT NULL,
+					role TEXT NOT NULL)`,
+			`CREATE TABLE IF NOT EXISTS groups(
+					id SERIAL PRIMARY KEY,
+					name TEXT NOT NULL UNIQUE,
+					role TEXT NOT NULL DEFAULT '')`,
+			`CREATE TABLE IF NOT EXISTS group_members(
+					group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+					username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+					PRIMARY KEY (group_id, username))`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS group_members`,
+			`DROP TABLE IF EXISTS groups`,
+			`DROP TABLE IF EXISTS users`,
+		},
+		Drops: "the users, groups and their members",
+	},
+	{
+		Version: 3,
+		Name:    "create audit log",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS audit_log(
+					id BIGSERIAL PRIMARY KEY,
+					actor TEXT NOT NULL,
+					action TEXT NOT NULL,
+					entity TEXT NOT NULL,
+					entity_id INT NOT NULL,
+					request_id TEXT NOT NULL DEFAULT '',
+					before JSONB,
+					after JSONB,
+					changed_fields TEXT[] NOT NULL DEFAULT '{}',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id)`,
+			// the audit log is append-only, rows can't be changed or removed
+			`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+					BEGIN
+						RAISE EXCEPTION 'audit_log is append-only';
+					END;
+					$$ LANGUAGE plpgsql`,
+			`DROP TRIGGER IF EXISTS audit_log_no_change ON audit_log`,
+			`CREATE TRIGGER audit_log_no_change BEFORE UPDATE OR DELETE ON audit_log
+					FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only()`,
+			`DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log`,
+			`CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+					FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only()`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS audit_log`,
+			`DROP FUNCTION IF EXISTS audit_log_append_only()`,
+		},
+		Drops: "the append-only audit_log",
+	},
+	{
+		Version: 4,
+		Name:    "create expense revisions",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS expense_revisions(
+					expense_id INT NOT NULL,
+					revision INT NOT NULL,
+					data JSONB NOT NULL,
+					action TEXT NOT NULL,
+					actor TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					PRIMARY KEY (expense_id, revision))`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS expense_revisions`,
+		},
+		Drops: "the revisions of the expenses",
+	},
+	{
+		Version: 5,
+		Name:    "create webhooks",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhooks(
+					id SERIAL PRIMARY KEY,
+					url TEXT NOT NULL,
+					events TEXT[] NOT NULL,
+					secret TEXT NOT NULL,
+					active BOOLEAN NOT NULL DEFAULT TRUE,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE TABLE IF NOT EXISTS webhook_deliveries(
+					id BIGSERIAL PRIMARY KEY,
+					webhook_id INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+					event TEXT NOT NULL,
+					payload JSONB NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					attempts INT NOT NULL DEFAULT 0,
+					next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					last_error TEXT NOT NULL DEFAULT '',
+					response_code INT NOT NULL DEFAULT 0,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_deliveries`,
+			`DROP TABLE IF EXISTS webhooks`,
+		},
+		Drops: "the webhooks and their deliveries",
+	},
+	{
+		Version: 6,
+		Name:    "create outbox",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS outbox(
+					id BIGSERIAL PRIMARY KEY,
+					aggregate_id INT NOT NULL,
+					sequence INT NOT NULL,
+					event TEXT NOT NULL,
+					payload JSONB NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					published_at TIMESTAMPTZ,
+					UNIQUE (aggregate_id, sequence))`,
+			`CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL`,
+			`ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS event_id BIGINT`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries (webhook_id, event_id)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS webhook_deliveries_event_idx`,
+			`ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS event_id`,
+			`DROP TABLE IF EXISTS outbox`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "split expenses",
+		Up: []string{
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS payer TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS participants JSONB NOT NULL DEFAULT '[]'`,
+			`CREATE TABLE IF NOT EXISTS settlements(
+					id SERIAL PRIMARY KEY,
+					payer TEXT NOT NULL,
+					payee TEXT NOT NULL,
+					amount FLOAT NOT NULL,
+					note TEXT NOT NULL DEFAULT '',
+					created_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS settlements`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS participants`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS split`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS payer`,
+		},
+		Drops: "the settlements and the splits of the expenses",
+	},
+	{
+		Version: 8,
+		Name:    "group expenses",
+		Up: []string{
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS group_id INT REFERENCES groups(id) ON DELETE SET NULL`,
+			`CREATE INDEX IF NOT EXISTS expenses_group_idx ON expenses (group_id)`,
+			`CREATE TABLE IF NOT EXISTS group_invitations(
+					id SERIAL PRIMARY KEY,
+					group_id INT NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+					username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+					invited_by TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					responded_at TIMESTAMPTZ)`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS group_invitations_pending_idx ON group_invitations (group_id, username) WHERE status = 'pending'`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS group_invitations`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS group_id`,
+		},
+		Drops: "the group invitations",
+	},
+	{
+		Version: 9,
+		Name:    "approval workflow",
+		Up: []string{
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'draft'`,
+			`CREATE INDEX IF NOT EXISTS expenses_status_idx ON expenses (status)`,
+			`CREATE TABLE IF NOT EXISTS expense_transitions(
+					id BIGSERIAL PRIMARY KEY,
+					expense_id INT NOT NULL,
+					from_status TEXT NOT NULL,
+					to_status TEXT NOT NULL,
+					comment TEXT NOT NULL DEFAULT '',
+					actor TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE INDEX IF NOT EXISTS expense_transitions_expense_idx ON expense_transitions (expense_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS expense_transitions`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS status`,
+		},
+		Drops: "the approval history of the expenses",
+	},
+	{
+		Version: 10,
+		Name:    "currencies and dates",
+		Up: []string{
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB'`,
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS date DATE NOT NULL DEFAULT CURRENT_DATE`,
+		},
+		Down: []string{
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS date`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS currency`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create expense reports",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS expense_reports(
+					id SERIAL PRIMARY KEY,
+					title TEXT NOT NULL,
+					period_start DATE NOT NULL,
+					period_end DATE NOT NULL,
+					owner TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'draft',
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE TABLE IF NOT EXISTS expense_report_items(
+					report_id INT NOT NULL REFERENCES expense_reports(id) ON DELETE CASCADE,
+					expense_id INT NOT NULL UNIQUE REFERENCES expenses(id) ON DELETE CASCADE,
+					PRIMARY KEY (report_id, expense_id))`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS expense_report_items`,
+			`DROP TABLE IF EXISTS expense_reports`,
+		},
+		Drops: "the expense reports",
+	},
+	{
+		Version: 12,
+		Name:    "create payments",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS payees(
+					username TEXT PRIMARY KEY REFERENCES users(username) ON DELETE CASCADE,
+					account_name TEXT NOT NULL,
+					bank_code TEXT NOT NULL,
+					account_number TEXT NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE TABLE IF NOT EXISTS payment_batches(
+					id SERIAL PRIMARY KEY,
+					currency TEXT NOT NULL,
+					layout TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'open',
+					created_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+					paid_at TIMESTAMPTZ)`,
+			`CREATE TABLE IF NOT EXISTS payment_batch_payments(
+					batch_id INT NOT NULL REFERENCES payment_batches(id) ON DELETE CASCADE,
+					username TEXT NOT NULL,
+					account_name TEXT NOT NULL,
+					bank_code TEXT NOT NULL,
+					account_number TEXT NOT NULL,
+					amount FLOAT NOT NULL,
+					PRIMARY KEY (batch_id, username))`,
+			`CREATE TABLE IF NOT EXISTS payment_batch_items(
+					batch_id INT NOT NULL REFERENCES payment_batches(id) ON DELETE CASCADE,
+					expense_id INT NOT NULL UNIQUE REFERENCES expenses(id) ON DELETE CASCADE,
+					username TEXT NOT NULL,
+					PRIMARY KEY (batch_id, expense_id))`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS payment_batch_items`,
+			`DROP TABLE IF EXISTS payment_batch_payments`,
+			`DROP TABLE IF EXISTS payment_batches`,
+			`DROP TABLE IF EXISTS payees`,
+		},
+		Drops: "the payees and payment batches",
+	},
+	{
+		Version: 13,
+		Name:    "custom fields",
+		Up: []string{
+			`ALTER TABLE expenses ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'`,
+			`CREATE INDEX IF NOT EXISTS expenses_metadata_idx ON expenses USING GIN (metadata)`,
+			`CREATE TABLE IF NOT EXISTS field_definitions(
+					name TEXT PRIMARY KEY,
+					type TEXT NOT NULL,
+					required BOOLEAN NOT NULL DEFAULT false,
+					enum TEXT[] NOT NULL DEFAULT '{}')`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS field_definitions`,
+			`ALTER TABLE expenses DROP COLUMN IF EXISTS metadata`,
+		},
+		Drops: "the metadata field definitions and the metadata of the expenses",
+	},
+	{
+		Version: 14,
+		Name:    "group settlements",
+		Up: []string{
+			`ALTER TABLE settlements ADD COLUMN IF NOT EXISTS group_id INT REFERENCES groups(id) ON DELETE SET NULL`,
+		},
+		Down: []string{
+			`ALTER TABLE settlements DROP COLUMN IF EXISTS group_id`,
+		},
+	},
+	{
+		Version: 15,
+		Name:    "create receipts",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS receipts(
+					id SERIAL PRIMARY KEY,
+					expense_id INT NOT NULL REFERENCES expenses(id) ON DELETE CASCADE,
+					filename TEXT NOT NULL,
+					content_type TEXT NOT NULL,
+					size INT NOT NULL,
+					content BYTEA NOT NULL,
+					uploaded_by TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			`CREATE INDEX IF NOT EXISTS receipts_expense_idx ON receipts (expense_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS receipts`,
+		},
+		Drops: "the receipts uploaded",
+	},
+	{
+		Version: 16,
+		Name:    "settlement currencies",
+		Up: []string{
+			`ALTER TABLE settlements ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB'`,
+		},
+		Down: []string{
+			`ALTER TABLE settlements DROP COLUMN IF EXISTS currency`,
+		},
+	},
+	{
+		Version: 17,
+		Name:    "outbox sequences",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS outbox_sequences(
+					aggregate_id INT PRIMARY KEY,
+					sequence INT NOT NULL)`,
+			// go on from the events still in the outbox, or from the
+			// revisions of expenses whose events were all cleaned up, which
+			// are at least as many
+			`INSERT INTO outbox_sequences (aggregate_id, sequence)
+				SELECT aggregate_id, MAX(sequence) FROM outbox GROUP BY aggregate_id
+				ON CONFLICT DO NOTHING`,
+			`INSERT INTO outbox_sequences (aggregate_id, sequence)
+				SELECT expense_id, MAX(revision) FROM expense_revisions GROUP BY expense_id
+				ON CONFLICT DO NOTHING`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS outbox_sequences`,
+		},
+	},
+}
+
+// LatestSchemaVersion is the version of the schema this build expects.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// MigrationState is a migration and when it was applied, zero when it is
+// pending.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (s MigrationState) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations(
+					version INT PRIMARY KEY,
+					name TEXT NOT NULL,
+					applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`
+
+// migrationDB is a *sql.DB, or the *sql.Conn holding the migration lock.
+type migrationDB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// migrationLock is the advisory lock held while migrating, so that replicas
+// starting together don't run the same migration at once.
+const migrationLock = 0x6d696772617465
+
+// lockMigrations waits for the migration lock on a connection of db, which
+// the migrations then run on. The lock goes with the connection once it is
+// closed.
+func lockMigrations(ctx context.Context, db *sql.DB) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func unlockMigrations(ctx context.Context, conn *sql.Conn) {
+	conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLock)
+	conn.Close()
+}
+
+// appliedMigrations returns when each applied migration was applied, none
+// when schema_migrations doesn't exist yet.
+func appliedMigrations(db migrationDB) (map[int]time.Time, error) {
+	ctx := context.Background()
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]time.Time{}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// SchemaVersion is the highest migration applied to db, 0 for a database
+// that was never migrated.
+func SchemaVersion(db *sql.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	v := 0
+	for version := range applied {
+		if version > v {
+			v = version
+		}
+	}
+	return v, nil
+}
+
+// MigrationStatus lists every migration and whether it was applied to db.
+func MigrationStatus(db *sql.DB) ([]MigrationState, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	states := []MigrationState{}
+	for _, m := range migrations {
+		states = append(states, MigrationState{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version]})
+	}
+	return states, nil
+}
+
+// MigrateUp applies the pending migrations in order, each in a transaction,
+// and returns them. It holds the migration lock throughout, a replica
+// starting meanwhile waits and then finds nothing pending.
+func MigrateUp(db *sql.DB) ([]Migration, error) {
+	ctx := context.Background()
+	conn, err := lockMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockMigrations(ctx, conn)
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := migrate(conn, m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT DO NOTHING`, m.Version, m.Name)
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, latest first, and
+// returns them. Migrations that drop records for good are reverted only when
+// drop is set, otherwise it stops before them with a *MigrationDropError.
+func MigrateDown(db *sql.DB, steps int, drop bool) ([]Migration, error) {
+	ctx := context.Background()
+	conn, err := lockMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockMigrations(ctx, conn)
+
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Drops != "" && !drop {
+			return done, &MigrationDropError{Migration: m}
+		}
+		if err := migrate(conn, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrationDropError is returned by MigrateDown for a migration whose Down
+// destroys records it wasn't allowed to.
+type MigrationDropError struct {
+	Migration Migration
+}
+
+func (e *MigrationDropError) Error() string {
+	return fmt.Sprintf("migration %d %s: reverting it drops %s for good", e.Migration.Version, e.Migration.Name, e.Migration.Drops)
+}
+
+// migrate runs stmts and then record in a transaction.
+func migrate(db migrationDB, stmts []string, record string, args ...interface{}) error {
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
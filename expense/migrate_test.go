@@ -0,0 +1,274 @@
+//go:build unit
+
+package expense
+
+import (
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration %s", m.Name)
+		assert.NotEmpty(t, m.Up, "migration %d has no up statements", m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d has no down statements", m.Version)
+	}
+	assert.Equal(t, len(migrations), LatestSchemaVersion())
+}
+
+// db/init.sql starts the database of the integration tests, it falls behind
+// whenever a migration is added without it.
+func TestInitSQL(t *testing.T) {
+	b, err := os.ReadFile("../db/init.sql")
+	if err != nil {
+		t.Fatal("unable to read init.sql", err)
+	}
+	sql := string(b)
+	for _, m := range migrations {
+		for _, stmt := range m.Up {
+			assert.True(t, strings.Contains(sql, stmt+";"), "init.sql misses migration %d %s:\n%s", m.Version, m.Name, stmt)
+		}
+	}
+}
+
+// expectApplied answers which migrations were applied, up to version.
+func expectApplied(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_migrations') IS NOT NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(version > 0))
+	if version == 0 {
+		return
+	}
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for v := 1; v <= version; v++ {
+		rows.AddRow(v, time.Date(2022, 12, 24, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version, applied_at FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+// expectLocked wraps the expectations of expect in the migration lock.
+func expectLocked(mock sqlmock.Sqlmock, expect func()) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+	expect()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectMigration(mock sqlmock.Sqlmock, stmts []string, record string) {
+	mock.ExpectBegin()
+	for _, stmt := range stmts {
+		mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(record)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestMigrateUp(t *testing.T) {
+	t.Run("should apply every migration to a new database", func(t *testing.T) {
+		//arrange
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer db.Close()
+		expectLocked(mock, func() {
+			mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 0))
+			expectApplied(mock, 0)
+			for _, m := range migrations {
+				expectMigration(mock, m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT DO NOTHING`)
+			}
+		})
+
+		//action
+		done, err := MigrateUp(db)
+
+		//assert
+		assert.Nil(t, err)
+		assert.Len(t, done, len(migrations))
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should apply only the pending migrations", func(t *testing.T) {
+		//arrange
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer db.Close()
+		latest := migrations[len(migrations)-1]
+		expectLocked(mock, func() {
+			mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).WillReturnResult(sqlmock.NewResult(0, 0))
+			expectApplied(mock, latest.Version-1)
+			mock.ExpectBegin()
+			for _, stmt := range latest.Up {
+				mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations`)).
+				WithArgs(latest.Version, latest.Name).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		})
+
+		//action
+		done, err := MigrateUp(db)
+
+		//assert
+		assert.Nil(t, err)
+		if assert.Len(t, done, 1) {
+			assert.Equal(t, latest.Version, done[0].Version)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+// expectReverted expects the migrations of versions to be reverted.
+func expectReverted(mock sqlmock.Sqlmock, versions ...int) {
+	for _, v := range versions {
+		mock.ExpectBegin()
+		for _, stmt := range migrations[v-1].Down {
+			mock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).
+			WithArgs(v).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	t.Run("should revert the last migrations, latest first", func(t *testing.T) {
+		//arrange
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer db.Close()
+		expectLocked(mock, func() {
+			expectApplied(mock, 12)
+			expectReverted(mock, 12, 11)
+		})
+
+		//action
+		done, err := MigrateDown(db, 2, true)
+
+		//assert
+		assert.Nil(t, err)
+		if assert.Len(t, done, 2) {
+			assert.Equal(t, 12, done[0].Version)
+			assert.Equal(t, 11, done[1].Version)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should stop before dropping records unless told to", func(t *testing.T) {
+		//arrange
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer db.Close()
+		expectLocked(mock, func() {
+			expectApplied(mock, 14)
+			expectReverted(mock, 14)
+		})
+
+		//action
+		done, err := MigrateDown(db, 2, false)
+
+		//assert
+		assert.Len(t, done, 1)
+		assert.EqualError(t, err, "migration 13 custom fields: reverting it drops the metadata field definitions and the metadata of the expenses for good")
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should drop the audit log when told to", func(t *testing.T) {
+		//arrange
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer db.Close()
+		expectLocked(mock, func() {
+			expectApplied(mock, 3)
+			expectReverted(mock, 3)
+		})
+
+		//action
+		done, err := MigrateDown(db, 1, true)
+
+		//assert
+		assert.Nil(t, err)
+		assert.Len(t, done, 1)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrationStatus(t *testing.T) {
+	//arrange
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer db.Close()
+	expectApplied(mock, 2)
+
+	//action
+	states, err := MigrationStatus(db)
+
+	//assert
+	assert.Nil(t, err)
+	if assert.Len(t, states, len(migrations)) {
+		assert.True(t, states[1].Applied())
+		assert.False(t, states[2].Applied())
+		assert.Equal(t, "create audit log", states[2].Name)
+	}
+}
+
+func TestSyntheticExpenses(t *testing.T) {
+	//action
+	exs := SyntheticExpenses(rand.New(rand.NewSource(1)), 50, "alice")
+
+	//assert
+	assert.Equal(t, exs, SyntheticExpenses(rand.New(rand.NewSource(1)), 50, "alice"))
+	oldest := NewDate(Today().AddDate(0, 0, -seedDays))
+	for _, ex := range exs {
+		assert.Nil(t, ex.validation())
+		assert.Equal(t, "alice", ex.Owner)
+		assert.Greater(t, ex.Amount, float32(0))
+		assert.True(t, ex.Date.After(oldest.Time), "%s is older than %d days", ex.Date, seedDays)
+		assert.False(t, ex.Date.After(Today().Time))
+	}
+}
+
+func TestSeed(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	exs := SyntheticExpenses(rand.New(rand.NewSource(1)), 2, "alice")
+	mock.ExpectBegin()
+	for i, ex := range exs {
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expenses`)).
+			WithArgs(ex.Title, ex.Amount, ex.Note, sqlmock.AnyArg(), "alice", "", "", sqlmock.AnyArg(), 0, ex.Status, "THB", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(i + 1))
+	}
+	mock.ExpectCommit()
+
+	//action
+	err = Seed(exs)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, 1, exs[0].Id)
+	assert.Equal(t, 2, exs[1].Id)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,156 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Event is a domain event of an expense. Events of one expense are numbered
+// by Sequence from 1 and are published in that order.
+type Event struct {
+	Id         int64     `json:"id"`
+	Type       string    `json:"event"`
+	ExpenseId  int       `json:"expense_id"`
+	Sequence   int       `json:"sequence"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Expense    Expense   `json:"expense"`
+}
+
+// eventFor maps the action of recordChange to its event type.
+func eventFor(action string) string {
+	switch action {
+	case "create":
+		return EventExpenseCreated
+	case "delete":
+		return EventExpenseDeleted
+	}
+	return EventExpenseUpdated
+}
+
+// writeOutbox adds the event of a mutation to the outbox in tx, so the event
+// exists exactly when the mutation does. The Relay publishes it afterwards.
+// The sequence is counted in outbox_sequences rather than from the outbox,
+// which the Relay empties of published events.
+func writeOutbox(tx *sql.Tx, event string, ex *Expense) error {
+	payload, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`WITH next AS (
+			INSERT INTO outbox_sequences (aggregate_id, sequence) VALUES ($1, 1)
+			ON CONFLICT (aggregate_id) DO UPDATE SET sequence = outbox_sequences.sequence + 1
+			RETURNING sequence)
+		INSERT INTO outbox (aggregate_id, sequence, event, payload) SELECT $1, sequence, $2, $3 FROM next`,
+		ex.Id, event, string(payload))
+	return err
+}
+
+// Relay publishes the outbox to its sinks. An event is marked published once
+// every sink took it and is retried otherwise, so delivery is at-least-once:
+// sinks may see an event again and should use its id to drop duplicates.
+type Relay struct {
+	Sinks     []Sink
+	Interval  time.Duration
+	BatchSize int
+	Retention time.Duration // how long published events are kept
+}
+
+func NewRelay(sinks ...Sink) *Relay {
+	return &Relay{Sinks: sinks, Interval: time.Second, BatchSize: 100, Retention: 7 * 24 * time.Hour}
+}
+
+// relayLock is the advisory lock held while relaying. Only one replica relays
+// at a time, which keeps the events of an expense in sequence.
+const relayLock = 0x6f7574626f78
+
+// Run relays until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		if err := r.relay(ctx); err != nil {
+			slog.Error("outbox relay error", ErrorAttrs(err)...)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (r *Relay) relay(ctx context.Context) error {
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, relayLock).Scan(&locked); err != nil || !locked {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, event, aggregate_id, sequence, created_at, payload FROM outbox
+		WHERE published_at IS NULL ORDER BY id LIMIT $1`, r.BatchSize)
+	if err != nil {
+		return err
+	}
+	events := []Event{}
+	for rows.Next() {
+		ev := Event{}
+		var payload []byte
+		if err := rows.Scan(&ev.Id, &ev.Type, &ev.ExpenseId, &ev.Sequence, &ev.OccurredAt, &payload); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal(payload, &ev.Expense); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	published := []int64{}
+	blocked := map[int]bool{}
+	for _, ev := range events {
+		// a failed event holds back the later events of its expense
+		if blocked[ev.ExpenseId] {
+			continue
+		}
+		if err := r.publish(ctx, ev); err != nil {
+			slog.Error("outbox publish error", append([]interface{}{slog.Int64("event_id", ev.Id)}, ErrorAttrs(err)...)...)
+			blocked[ev.ExpenseId] = true
+			continue
+		}
+		published = append(published, ev.Id)
+	}
+
+	if len(published) > 0 {
+		if _, err := tx.Exec(`UPDATE outbox SET published_at = now() WHERE id = ANY($1)`, pq.Array(published)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE published_at < now() - $1 * INTERVAL '1 second'`, r.Retention.Seconds()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Relay) publish(ctx context.Context, ev Event) error {
+	for _, s := range r.Sinks {
+		if err := s.Publish(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink keeps what it was given and fails the events in fail.
+type recordingSink struct {
+	got  []int64
+	fail map[int64]bool
+}
+
+func (s *recordingSink) Publish(ctx context.Context, ev Event) error {
+	if s.fail[ev.Id] {
+		return errors.New("sink down")
+	}
+	s.got = append(s.got, ev.Id)
+	return nil
+}
+
+func TestWriteOutboxCountsSequences(t *testing.T) {
+	//arrange
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox_sequences (aggregate_id, sequence) VALUES ($1, 1)
+			ON CONFLICT (aggregate_id) DO UPDATE SET sequence = outbox_sequences.sequence + 1`)).
+		WithArgs(1, EventExpenseUpdated, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tx, _ := db.Begin()
+
+	//action
+	err = writeOutbox(tx, EventExpenseUpdated, &Expense{Id: 1})
+
+	//assert
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRelay(t *testing.T) {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	at := time.Date(2022, 12, 24, 10, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM outbox`)).
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event", "aggregate_id", "sequence", "created_at", "payload"}).
+			AddRow(1, "expense.created", 1, 1, at, []byte(`{"id":1}`)).
+			AddRow(2, "expense.created", 2, 1, at, []byte(`{"id":2}`)).
+			AddRow(3, "expense.updated", 1, 2, at, []byte(`{"id":1}`)).
+			AddRow(4, "expense.updated", 2, 2, at, []byte(`{"id":2}`)))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET published_at = now() WHERE id = ANY($1)`)).
+		WithArgs(pq.Array([]int64{1, 3})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM outbox WHERE published_at`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	sink := &recordingSink{fail: map[int64]bool{2: true}}
+	err = NewRelay(sink).relay(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 3}, sink.got, "events of expense 2 wait for its failed first event")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRelaySkipsWhenAnotherReplicaRelays(t *testing.T) {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectRollback()
+
+	err = NewRelay(&recordingSink{}).relay(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestNDJSONSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewNDJSONSink(buf)
+
+	s.Publish(context.Background(), Event{Id: 1, Type: "expense.created", ExpenseId: 1, Sequence: 1})
+	s.Publish(context.Background(), Event{Id: 2, Type: "expense.updated", ExpenseId: 1, Sequence: 2})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	assert.Contains(t, string(lines[1]), `"event":"expense.updated"`)
+}
+
+func TestBus(t *testing.T) {
+	b := &Bus{}
+	got := []int64{}
+	unsubscribe := b.Subscribe(func(ev Event) error {
+		got = append(got, ev.Id)
+		return nil
+	})
+
+	b.Publish(context.Background(), Event{Id: 1})
+	unsubscribe()
+	b.Publish(context.Background(), Event{Id: 2})
+
+	assert.Equal(t, []int64{1}, got)
+}
@@ -0,0 +1,424 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	BatchOpen = "open"
+	BatchPaid = "paid"
+)
+
+// Payee is the bank account a user is reimbursed to.
+type Payee struct {
+	Username      string `json:"username"`
+	AccountName   string `json:"account_name"`
+	BankCode      string `json:"bank_code"`
+	AccountNumber string `json:"account_number"`
+}
+
+var (
+	// Thai banks are numbered with 3 digits, their accounts with 10 or, at a
+	// few state banks, 12
+	bankCodePattern      = regexp.MustCompile(`^[0-9]{3}$`)
+	accountNumberPattern = regexp.MustCompile(`^[0-9]{10,12}$`)
+)
+
+func (p *Payee) validation() error {
+	if p.AccountName == "" {
+		return fmt.Errorf("account_name error : this field should not empty.")
+	}
+	if !bankCodePattern.MatchString(p.BankCode) {
+		return fmt.Errorf("bank_code error : this field should be 3 digits.")
+	}
+	if !accountNumberPattern.MatchString(p.AccountNumber) {
+		return fmt.Errorf("account_number error : this field should be 10 to 12 digits.")
+	}
+	return nil
+}
+
+// Payment is one transfer of a batch: everything owed to a user.
+type Payment struct {
+	Username      string  `json:"username"`
+	AccountName   string  `json:"account_name"`
+	BankCode      string  `json:"bank_code"`
+	AccountNumber string  `json:"account_number"`
+	Amount        float64 `json:"amount"`
+	ExpenseIds    []int   `json:"expense_ids"`
+}
+
+// PaymentBatch gathers approved expenses that are not paid yet into one
+// transfer per user, exported as a bank file. Paying the batch reimburses
+// its expenses.
+type PaymentBatch struct {
+	Id        int        `json:"id"`
+	Currency  string     `json:"currency"`
+	Layout    string     `json:"layout"`
+	Status    string     `json:"status"`
+	Total     float64    `json:"total"`
+	Payments  []Payment  `json:"payments,omitempty"`
+	Skipped   []string   `json:"skipped,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	PaidAt    *time.Time `json:"paid_at"`
+}
+
+func (b *PaymentBatch) total() {
+	cents := int64(0)
+	for _, p := range b.Payments {
+		cents += toCents(p.Amount)
+	}
+	b.Total = fromCents(cents)
+}
+
+// payeeOf answers whether u may see and change the bank account of username.
+func payeeOf(u User, username string) error {
+	if u.Username == username {
+		return Authorize(u, ActionView, nil)
+	}
+	return Authorize(u, ActionManageUsers, nil)
+}
+
+func GetPayee(c echo.Context) error {
+	if err := payeeOf(CurrentUser(c), c.Param("username")); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	p := Payee{}
+	err := Db.QueryRow(`SELECT username, account_name, bank_code, account_number FROM payees WHERE username = $1`, c.Param("username")).
+		Scan(&p.Username, &p.AccountName, &p.BankCode, &p.AccountNumber)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "payee's not found"}
+	case nil:
+		return c.JSON(http.StatusOK, p)
+	default:
+		return internalErr("can't scan payee", err)
+	}
+}
+
+func UpdatePayee(c echo.Context) error {
+	if err := payeeOf(CurrentUser(c), c.Param("username")); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	p := Payee{}
+	if err := c.Bind(&p); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	p.Username = c.Param("username")
+	if err := p.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	_, err := Db.Exec(`INSERT INTO payees (username, account_name, bank_code, account_number) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username) DO UPDATE SET account_name = $2, bank_code = $3, account_number = $4, updated_at = now()`,
+		p.Username, p.AccountName, p.BankCode, p.AccountNumber)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+		return &Err{Status: http.StatusNotFound, Message: "user's not found"}
+	}
+	if err != nil {
+		return internalErr("unable to update payee", err)
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+const selectBatch = `SELECT id, currency, layout, status, created_by, created_at, paid_at FROM payment_batches`
+
+func scanBatch(row scanner, b *PaymentBatch) error {
+	return row.Scan(&b.Id, &b.Currency, &b.Layout, &b.Status, &b.CreatedBy, &b.CreatedAt, &b.PaidAt)
+}
+
+// loadBatch reads batch id with its payments. lock takes a row lock on the
+// batch for the transaction q.
+func loadBatch(q querier, id string, lock bool) (PaymentBatch, error) {
+	forUpdate := ""
+	if lock {
+		forUpdate = ` FOR UPDATE`
+	}
+
+	b := PaymentBatch{}
+	if err := scanBatch(q.QueryRow(selectBatch+` WHERE id = $1`+forUpdate, id), &b); err != nil {
+		return b, err
+	}
+
+	rows, err := q.Query(`SELECT p.username, p.account_name, p.bank_code, p.account_number, p.amount,
+		ARRAY(SELECT i.expense_id FROM payment_batch_items i WHERE i.batch_id = p.batch_id AND i.username = p.username ORDER BY i.expense_id)
+		FROM payment_batch_payments p WHERE p.batch_id = $1 ORDER BY p.username`, b.Id)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	b.Payments = []Payment{}
+	for rows.Next() {
+		p, ids := Payment{}, []int64{}
+		if err := rows.Scan(&p.Username, &p.AccountName, &p.BankCode, &p.AccountNumber, &p.Amount, pq.Array(&ids)); err != nil {
+			return b, err
+		}
+		for _, id := range ids {
+			p.ExpenseIds = append(p.ExpenseIds, int(id))
+		}
+		b.Payments = append(b.Payments, p)
+	}
+	b.total()
+	return b, rows.Err()
+}
+
+// CreatePaymentBatch gathers the approved expenses in the currency asked for
+// (THB by default) that are in no batch yet, one payment per owner. Owners without a
+// bank account are left out and listed as skipped.
+func CreatePaymentBatch(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionReimburse, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	b := PaymentBatch{}
+	if err := c.Bind(&b); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if b.Currency == "" {
+		b.Currency = DefaultCurrency
+	}
+	if b.Layout == "" {
+		b.Layout = DefaultBankLayout
+	}
+	if _, ok := bankLayout(b.Layout); !ok {
+		return &Err{Status: http.StatusBadRequest, Message: "layout error : there is no layout " + b.Layout + "."}
+	}
+	b.Status, b.CreatedBy = BatchOpen, u.Username
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	visible, args := visibleExpenses(u, 3)
+	rows, err := tx.Query(`SELECT e.id, e.owner, e.amount, p.account_name, p.bank_code, p.account_number
+		FROM expenses e LEFT JOIN payees p ON p.username = e.owner
+		WHERE e.status = $1 AND e.currency = $2 AND `+visible+`
+		AND NOT EXISTS (SELECT 1 FROM payment_batch_items i WHERE i.expense_id = e.id)
+		ORDER BY e.owner, e.id FOR UPDATE OF e`, append([]interface{}{StatusApproved, b.Currency}, args...)...)
+	if err != nil {
+		return internalErr("unable to query expenses", err)
+	}
+
+	cents := map[string]int64{}
+	for rows.Next() {
+		var (
+			id                               int
+			owner                            string
+			amount                           float64
+			account, bankCode, accountNumber sql.NullString
+		)
+		if err := rows.Scan(&id, &owner, &amount, &account, &bankCode, &accountNumber); err != nil {
+			rows.Close()
+			return internalErr("can't scan expense", err)
+		}
+		if !account.Valid {
+			if !contains(b.Skipped, owner) {
+				b.Skipped = append(b.Skipped, owner)
+			}
+			continue
+		}
+		if n := len(b.Payments); n == 0 || b.Payments[n-1].Username != owner {
+			b.Payments = append(b.Payments, Payment{Username: owner, AccountName: account.String, BankCode: bankCode.String, AccountNumber: accountNumber.String})
+		}
+		p := &b.Payments[len(b.Payments)-1]
+		p.ExpenseIds = append(p.ExpenseIds, id)
+		cents[owner] += toCents(amount)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return internalErr("can't scan expense", err)
+	}
+	if len(b.Payments) == 0 {
+		return &Err{Status: http.StatusConflict, Message: "there are no approved " + b.Currency + " expenses to pay"}
+	}
+
+	err = tx.QueryRow(`INSERT INTO payment_batches (currency, layout, created_by) VALUES ($1, $2, $3) RETURNING id, created_at`,
+		b.Currency, b.Layout, b.CreatedBy).Scan(&b.Id, &b.CreatedAt)
+	if err != nil {
+		return internalErr("unable to create payment batch", err)
+	}
+	for i := range b.Payments {
+		p := &b.Payments[i]
+		p.Amount = fromCents(cents[p.Username])
+		_, err = tx.Exec(`INSERT INTO payment_batch_payments (batch_id, username, account_name, bank_code, account_number, amount) VALUES ($1, $2, $3, $4, $5, $6)`,
+			b.Id, p.Username, p.AccountName, p.BankCode, p.AccountNumber, p.Amount)
+		if err != nil {
+			return internalErr("unable to create payment", err)
+		}
+		for _, id := range p.ExpenseIds {
+			_, err = tx.Exec(`INSERT INTO payment_batch_items (batch_id, expense_id, username) VALUES ($1, $2, $3)`, b.Id, id, p.Username)
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+				return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("expense %d is already in a payment batch", id)}
+			}
+			if err != nil {
+				return internalErr("unable to create payment", err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	b.total()
+	return c.JSON(http.StatusCreated, b)
+}
+
+// GetPaymentBatches lists the batches, newest first. ?status= narrows the list.
+func GetPaymentBatches(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionReimburse, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(selectBatch+` WHERE ($1 = '' OR status = $1) ORDER BY id DESC`, c.QueryParam("status"))
+	if err != nil {
+		return internalErr("unable to query payment batches", err)
+	}
+	defer rows.Close()
+
+	bs := []PaymentBatch{}
+	for rows.Next() {
+		b := PaymentBatch{}
+		if err := scanBatch(rows, &b); err != nil {
+			return internalErr("unable to scan payment batch", err)
+		}
+		bs = append(bs, b)
+	}
+	return c.JSON(http.StatusOK, bs)
+}
+
+func viewBatch(c echo.Context) (PaymentBatch, error) {
+	if err := Authorize(CurrentUser(c), ActionReimburse, nil); err != nil {
+		return PaymentBatch{}, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	b, err := loadBatch(Db, c.Param("id"), false)
+	switch err {
+	case sql.ErrNoRows:
+		return b, &Err{Status: http.StatusNotFound, Message: "payment batch's not found"}
+	case nil:
+		return b, nil
+	default:
+		return b, internalErr("can't scan payment batch", err)
+	}
+}
+
+func GetPaymentBatchById(c echo.Context) error {
+	b, err := viewBatch(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// GetPaymentBatchFile downloads the bank file of a batch, in the layout it
+// was created with or the one in ?layout=.
+func GetPaymentBatchFile(c echo.Context) error {
+	b, err := viewBatch(c)
+	if err != nil {
+		return err
+	}
+
+	name := c.QueryParam("layout")
+	if name == "" {
+		name = b.Layout
+	}
+	l, ok := bankLayout(name)
+	if !ok {
+		return &Err{Status: http.StatusBadRequest, Message: "layout error : there is no layout " + name + "."}
+	}
+
+	file, err := WriteBankFile(l, &b)
+	if _, ok := err.(*BankFileError); ok {
+		return &Err{Status: http.StatusUnprocessableEntity, Message: "unable to render bank file: " + err.Error()}
+	}
+	if err != nil {
+		return internalErr("unable to render bank file", err)
+	}
+	charset := l.Encoding
+	if charset == "" {
+		charset = EncodingUTF8
+	}
+	ext, contentType := "txt", "text/plain; charset="+charset
+	if l.Format == LayoutCSV {
+		ext, contentType = "csv", "text/csv; charset="+charset
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="payment-batch-%d.%s"`, b.Id, ext))
+	return c.Blob(http.StatusOK, contentType, file)
+}
+
+// PayPaymentBatch records that the bank made the transfers of a batch and
+// reimburses every expense in it. Either all of them move or none does.
+func PayPaymentBatch(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionReimburse, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	b, err := loadBatch(tx, c.Param("id"), true)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "payment batch's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan payment batch", err)
+	}
+	if b.Status != BatchOpen {
+		return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("payment batch %d is already %s", b.Id, b.Status)}
+	}
+
+	comment := fmt.Sprintf("paid in payment batch %d", b.Id)
+	for _, p := range b.Payments {
+		for _, id := range p.ExpenseIds {
+			ex := Expense{}
+			if err := scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, id), &ex); err != nil {
+				return internalErr("can't scan expense", err)
+			}
+			if err := transition(tx, c, &ex, "reimburse", comment); err != nil {
+				return transitionError(err)
+			}
+		}
+	}
+
+	b.Status = BatchPaid
+	if err = tx.QueryRow(`UPDATE payment_batches SET status = $2, paid_at = now() WHERE id = $1 RETURNING paid_at`, b.Id, b.Status).Scan(&b.PaidAt); err != nil {
+		return internalErr("unable to update payment batch", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+// DeletePaymentBatch drops a batch that is not paid, so that its expenses go
+// into the next one.
+func DeletePaymentBatch(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionReimburse, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	res, err := Db.Exec(`DELETE FROM payment_batches WHERE id = $1 AND status = $2`, c.Param("id"), BatchOpen)
+	if err != nil {
+		return internalErr("unable to delete payment batch", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "open payment batch's not found"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
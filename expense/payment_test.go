@@ -0,0 +1,176 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchContext(method, body string, u User) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, u)
+	c.SetPath("/payment-batches/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	return c, rec
+}
+
+func TestCreatePaymentBatch(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses e LEFT JOIN payees p ON p.username = e.owner`)).
+		WithArgs(StatusApproved, "THB", true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner", "amount", "account_name", "bank_code", "account_number"}).
+			AddRow(1, "alice", 0.1, "Alice A.", "004", "1234567890").
+			AddRow(3, "alice", 0.2, "Alice A.", "004", "1234567890").
+			AddRow(2, "bob", 100, nil, nil, nil).
+			AddRow(4, "carol", 50, "Carol C.", "014", "1111111111"))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO payment_batches (currency, layout, created_by) VALUES ($1, $2, $3) RETURNING id, created_at`)).
+		WithArgs("THB", "th-bank", "admin").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payment_batch_payments`)).
+		WithArgs(1, "alice", "Alice A.", "004", "1234567890", 0.3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, id := range []int{1, 3} {
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payment_batch_items`)).
+			WithArgs(1, id, "alice").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payment_batch_payments`)).
+		WithArgs(1, "carol", "Carol C.", "014", "1111111111", 50.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO payment_batch_items`)).
+		WithArgs(1, 4, "carol").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	c, rec := batchContext(http.MethodPost, `{}`, testAdmin)
+	b := PaymentBatch{}
+
+	//action
+	err = serve(CreatePaymentBatch, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&b)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Len(t, b.Payments, 2)
+	assert.Equal(t, []int{1, 3}, b.Payments[0].ExpenseIds)
+	assert.Equal(t, 50.3, b.Total)
+	assert.Equal(t, []string{"bob"}, b.Skipped)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePaymentBatchAsEditor(t *testing.T) {
+	c, rec := batchContext(http.MethodPost, `{}`, User{Username: "alice", Role: RoleEditor})
+
+	err := serve(CreatePaymentBatch, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func expectBatch(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payment_batches WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency", "layout", "status", "created_by", "created_at", "paid_at"}).
+			AddRow(1, "THB", "th-bank", status, "admin", time.Now(), nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM payment_batch_payments p WHERE p.batch_id = $1`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "account_name", "bank_code", "account_number", "amount", "ids"}).
+			AddRow("alice", "Alice A.", "004", "1234567890", 250, []byte("{1}")))
+}
+
+func TestPayPaymentBatch(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	expectBatch(mock, BatchOpen)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), "alice", "", "", []byte("[]"), 0, StatusApproved, "THB", testDate, []byte("{}")))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE expenses SET status = $2 WHERE id = $1`)).
+		WithArgs(1, StatusReimbursed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_transitions`)).
+		WithArgs(1, StatusApproved, StatusReimbursed, "paid in payment batch 1", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE payment_batches SET status = $2, paid_at = now() WHERE id = $1 RETURNING paid_at`)).
+		WithArgs(1, BatchPaid).
+		WillReturnRows(sqlmock.NewRows([]string{"paid_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+
+	c, rec := batchContext(http.MethodPost, `{}`, testAdmin)
+	b := PaymentBatch{}
+
+	//action
+	err = serve(PayPaymentBatch, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&b)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, BatchPaid, b.Status)
+	assert.NotNil(t, b.PaidAt)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestPayPaidPaymentBatch(t *testing.T) {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	expectBatch(mock, BatchPaid)
+	mock.ExpectRollback()
+
+	c, rec := batchContext(http.MethodPost, `{}`, testAdmin)
+
+	err = serve(PayPaymentBatch, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,369 @@
+package expense
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	pdfLinesPerPage = 64
+	pdfFontSize     = 9
+	pdfLeading      = 11
+)
+
+// PDFError is returned by writePDF for text the document can't show.
+type PDFError struct {
+	Reason string
+}
+
+func (e *PDFError) Error() string {
+	return e.Reason
+}
+
+// writePDF writes lines as an A4 PDF document, paging every pdfLinesPerPage
+// lines. The document is in the font loaded with LoadPDFFont, or else in
+// Courier, a standard font with no glyphs beyond Windows-1252. A line with a
+// character the font can't show, Thai in Courier for one, is a *PDFError
+// rather than a statement with '?' in place of names.
+func writePDF(w io.Writer, lines []string) error {
+	pf := documentFont()
+	shown := make([]string, len(lines))
+	for i, l := range lines {
+		s, err := pf.show(l)
+		if err != nil {
+			return &PDFError{Reason: fmt.Sprintf("line %d: %s", i+1, err)}
+		}
+		shown[i] = s
+	}
+
+	pages := [][]string{}
+	for len(shown) > pdfLinesPerPage {
+		pages = append(pages, shown[:pdfLinesPerPage])
+		shown = shown[pdfLinesPerPage:]
+	}
+	pages = append(pages, shown)
+
+	buf := &bytes.Buffer{}
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// objects 1 and 2 are the catalog and the page tree, the objects of the
+	// font follow from 3, every page then takes two: the page and its
+	// content stream
+	fontObjects := pf.objects(3)
+	first := 3 + len(fontObjects)
+	kids := []string{}
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", first+2*i))
+	}
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	for _, o := range fontObjects {
+		object(o)
+	}
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", first+1+2*i))
+
+		content := &bytes.Buffer{}
+		fmt.Fprintf(content, "BT\n/F1 %d Tf\n%d TL\n40 802 Td\n", pdfFontSize, pdfLeading)
+		for _, l := range page {
+			content.WriteString(l + "\n")
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfFont is the font of one PDF document.
+type pdfFont interface {
+	// show returns the operators moving to the next line and showing s.
+	show(s string) (string, error)
+	// objects returns the objects of the font numbered from first, its font
+	// dictionary first. It is called once the lines went through show.
+	objects(first int) []string
+}
+
+// documentFont returns the font of a new document.
+func documentFont() pdfFont {
+	pdfFontMu.RLock()
+	defer pdfFontMu.RUnlock()
+	if pdfTrueType == nil {
+		return courier{}
+	}
+	return &embeddedFont{trueTypeFont: pdfTrueType, widths: map[sfnt.GlyphIndex]int{}, unicode: map[sfnt.GlyphIndex]rune{}}
+}
+
+// pdfCellWidth is how wide a column is in thousandths of the font size, the
+// width of every Courier glyph.
+const pdfCellWidth = 600
+
+// combining tells the characters drawn over or under the one before them,
+// such as Thai vowels and tone marks, which take no column of their own.
+func combining(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// courier is the standard Courier font in WinAnsiEncoding.
+type courier struct{}
+
+func (courier) show(s string) (string, error) {
+	b, err := pdfString(s)
+	if err != nil {
+		return "", err
+	}
+	return "(" + string(b) + ") '", nil
+}
+
+func (courier) objects(first int) []string {
+	return []string{"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>"}
+}
+
+var (
+	pdfFontMu   sync.RWMutex
+	pdfTrueType *trueTypeFont
+)
+
+// trueTypeFont is a font file the PDF documents embed whole.
+type trueTypeFont struct {
+	name string
+	data []byte
+	font *sfnt.Font
+	// the metrics, in thousandths of an em
+	bbox                       [4]int
+	ascent, descent, capHeight int
+}
+
+// pdfEm is the size of an em the metrics of the fonts are taken at, which
+// makes them thousandths of an em as PDF wants them.
+var pdfEm = fixed.I(1000)
+
+// LoadPDFFont reads the TrueType font at path, which the PDF statements are
+// then written in. The font has to have glyphs for whatever the statements
+// show, Thai and Latin for the reports of this company.
+func LoadPDFFont(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := newTrueTypeFont(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	pdfFontMu.Lock()
+	defer pdfFontMu.Unlock()
+	pdfTrueType = f
+	return nil
+}
+
+func newTrueTypeFont(data []byte) (*trueTypeFont, error) {
+	// PDF embeds OpenType fonts with PostScript outlines in another way,
+	// only TrueType outlines are written as FontFile2
+	if bytes.HasPrefix(data, []byte("OTTO")) {
+		return nil, errors.New("font has PostScript outlines, it should be a TrueType font")
+	}
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	b := &sfnt.Buffer{}
+	bounds, err := f.Bounds(b, pdfEm, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	m, err := f.Metrics(b, pdfEm, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	t := &trueTypeFont{
+		name: postScriptName(f, b),
+		data: data,
+		font: f,
+		// sfnt measures y downwards, PDF upwards
+		bbox:    [4]int{bounds.Min.X.Round(), -bounds.Max.Y.Round(), bounds.Max.X.Round(), -bounds.Min.Y.Round()},
+		ascent:  m.Ascent.Round(),
+		descent: -m.Descent.Round(),
+	}
+	// sfnt gives the cap height upwards or downwards depending on the table
+	// it comes from
+	t.capHeight = m.CapHeight.Round()
+	if t.capHeight < 0 {
+		t.capHeight = -t.capHeight
+	}
+	if t.capHeight == 0 {
+		t.capHeight = t.ascent
+	}
+	return t, nil
+}
+
+// postScriptName returns the PostScript name of f with only the characters
+// a PDF name takes as they are.
+func postScriptName(f *sfnt.Font, b *sfnt.Buffer) string {
+	name, _ := f.Name(b, sfnt.NameIDPostScript)
+	name = strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("()<>[]{}/%#", r) {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return "Embedded"
+	}
+	return name
+}
+
+// embeddedFont writes a trueTypeFont into a document as a CID font whose
+// codes are the glyph indexes. Each glyph keeps its own width and the text
+// is moved to the next column after each character with the characters
+// combined over it, so the columns of the statements line up as in Courier.
+type embeddedFont struct {
+	*trueTypeFont
+	buf     sfnt.Buffer
+	widths  map[sfnt.GlyphIndex]int
+	unicode map[sfnt.GlyphIndex]rune
+}
+
+func (f *embeddedFont) show(s string) (string, error) {
+	b := &strings.Builder{}
+	b.WriteString("T* [")
+	cell := 0
+	width := 0
+	end := func() {
+		if width != cell {
+			fmt.Fprintf(b, " %d ", width-cell)
+		}
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			r = '?'
+		}
+		g, err := f.font.GlyphIndex(&f.buf, r)
+		if err != nil {
+			return "", err
+		}
+		if g == 0 {
+			return "", fmt.Errorf("%q has no glyph in the PDF font %s", r, f.name)
+		}
+		w, ok := f.widths[g]
+		if !ok {
+			adv, err := f.font.GlyphAdvance(&f.buf, g, pdfEm, font.HintingNone)
+			if err != nil {
+				return "", err
+			}
+			w = adv.Round()
+			f.widths[g] = w
+			f.unicode[g] = r
+		}
+		if !combining(r) {
+			end()
+			cell, width = pdfCellWidth, 0
+		}
+		width += w
+		fmt.Fprintf(b, "<%04X>", g)
+	}
+	end()
+	b.WriteString("] TJ")
+	return b.String(), nil
+}
+
+func (f *embeddedFont) objects(first int) []string {
+	glyphs := make([]sfnt.GlyphIndex, 0, len(f.widths))
+	for g := range f.widths {
+		glyphs = append(glyphs, g)
+	}
+	sort.Slice(glyphs, func(i, j int) bool { return glyphs[i] < glyphs[j] })
+	widths := &strings.Builder{}
+	toUnicode := &strings.Builder{}
+	for i, g := range glyphs {
+		fmt.Fprintf(widths, " %d [%d]", g, f.widths[g])
+		// a bfchar block takes at most 100 characters
+		if i%100 == 0 {
+			if i > 0 {
+				toUnicode.WriteString("endbfchar\n")
+			}
+			fmt.Fprintf(toUnicode, "%d beginbfchar\n", min(100, len(glyphs)-i))
+		}
+		fmt.Fprintf(toUnicode, "<%04X> <", g)
+		for _, u := range utf16.Encode([]rune{f.unicode[g]}) {
+			fmt.Fprintf(toUnicode, "%04X", u)
+		}
+		toUnicode.WriteString(">\n")
+	}
+	if len(glyphs) > 0 {
+		toUnicode.WriteString("endbfchar\n")
+	}
+	cmap := "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n" +
+		toUnicode.String() +
+		"endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend"
+
+	file := &bytes.Buffer{}
+	zw := zlib.NewWriter(file)
+	zw.Write(f.data)
+	zw.Close()
+
+	return []string{
+		fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", f.name, first+1, first+4),
+		fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW %d /W [%s ] /CIDToGIDMap /Identity >>", f.name, first+2, pdfCellWidth, widths),
+		fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>", f.name, f.bbox[0], f.bbox[1], f.bbox[2], f.bbox[3], f.ascent, f.descent, f.capHeight, first+3),
+		fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", file.Len(), len(f.data), file.Bytes()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(cmap), cmap),
+	}
+}
+
+// winAnsi are the characters Windows-1252 puts at 0x80 to 0x9F, where
+// Latin-1 has control codes.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfString escapes s for a PDF literal string in a WinAnsiEncoding font.
+func pdfString(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b = append(b, '\\', byte(r))
+		case r < 0x20 || r == 0x7f:
+			b = append(b, '?')
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			b = append(b, byte(r))
+		case winAnsi[r] != 0:
+			b = append(b, winAnsi[r])
+		default:
+			return nil, fmt.Errorf("%q can't be written in the PDF font", r)
+		}
+	}
+	return b, nil
+}
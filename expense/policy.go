@@ -0,0 +1,158 @@
+package expense
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleEditor   Role = "editor"
+	RoleApprover Role = "approver"
+	RoleAdmin    Role = "admin"
+)
+
+// roleLevel orders the roles; every role may do anything the roles below it can.
+var roleLevel = map[Role]int{
+	RoleViewer:   1,
+	RoleEditor:   2,
+	RoleApprover: 3,
+	RoleAdmin:    4,
+}
+
+func (r Role) valid() bool {
+	_, ok := roleLevel[r]
+	return ok
+}
+
+type Action string
+
+const (
+	ActionView           Action = "view"
+	ActionCreate         Action = "create"
+	ActionUpdate         Action = "update"
+	ActionDelete         Action = "delete"
+	ActionViewAudit      Action = "view the audit log"
+	ActionManageUsers    Action = "manage users"
+	ActionManageWebhooks Action = "manage webhooks"
+	ActionApprove        Action = "approve expenses"
+	ActionReimburse      Action = "reimburse expenses"
+	ActionManageFields   Action = "manage fields"
+)
+
+// AccessDenied is returned by Authorize and carries the reason shown to the client.
+type AccessDenied struct {
+	Reason string
+}
+
+func (e *AccessDenied) Error() string {
+	return e.Reason
+}
+
+const userKey = "user"
+
+// CurrentUser returns the user stored on c by the authentication middleware.
+func CurrentUser(c echo.Context) User {
+	u, _ := c.Get(userKey).(User)
+	return u
+}
+
+// SetCurrentUser stores u on c for the handlers that run after it.
+func SetCurrentUser(c echo.Context, u User) {
+	c.Set(userKey, u)
+}
+
+// Authorize reports whether u may perform a. ex is the expense the action
+// targets, or nil when the action does not target a single expense.
+func Authorize(u User, a Action, ex *Expense) error {
+	r := u.EffectiveRole()
+	if !r.valid() {
+		return &AccessDenied{Reason: fmt.Sprintf("user %q has no role", u.Username)}
+	}
+	if ex != nil && ex.GroupId != 0 {
+		if err := AuthorizeGroup(u, ex.GroupId); err != nil {
+			return err
+		}
+	}
+
+	switch a {
+	case ActionView:
+		return nil
+	case ActionCreate:
+		return require(u, r, RoleEditor, a)
+	case ActionUpdate, ActionDelete:
+		if err := require(u, r, RoleEditor, a); err != nil {
+			return err
+		}
+		if ex != nil && ex.Owner != u.Username && roleLevel[r] < roleLevel[RoleApprover] {
+			return &AccessDenied{Reason: fmt.Sprintf("role %s can only %s its own expenses, expense %d belongs to %q", r, a, ex.Id, ex.Owner)}
+		}
+		return nil
+	case ActionApprove:
+		if err := require(u, r, RoleApprover, a); err != nil {
+			return err
+		}
+		// nobody approves or rejects their own claims
+		if ex != nil && ex.Owner == u.Username {
+			return &AccessDenied{Reason: fmt.Sprintf("expense %d belongs to %q, someone else should approve or reject it", ex.Id, ex.Owner)}
+		}
+		return nil
+	case ActionViewAudit, ActionReimburse:
+		return require(u, r, RoleApprover, a)
+	case ActionManageUsers, ActionManageWebhooks, ActionManageFields:
+		return require(u, r, RoleAdmin, a)
+	}
+	return &AccessDenied{Reason: fmt.Sprintf("unknown action %q", a)}
+}
+
+// AuthorizeReport reports whether u may perform a on report r. Reports are
+// seen and changed by their owner; approvers may act on any report.
+func AuthorizeReport(u User, a Action, r *Report) error {
+	if err := Authorize(u, a, nil); err != nil {
+		return err
+	}
+	if a == ActionApprove && r.Owner == u.Username {
+		return &AccessDenied{Reason: fmt.Sprintf("report %d belongs to %q, someone else should approve or reject it", r.Id, r.Owner)}
+	}
+	if r.Owner != u.Username && roleLevel[u.EffectiveRole()] < roleLevel[RoleApprover] {
+		return &AccessDenied{Reason: fmt.Sprintf("role %s can only %s its own reports, report %d belongs to %q", u.EffectiveRole(), a, r.Id, r.Owner)}
+	}
+	return nil
+}
+
+// AuthorizeSettlement reports whether u may record settlement s. Editors
+// record the payments they made or received; approvers may record any, such
+// as a transfer paid through the company.
+func AuthorizeSettlement(u User, s *Settlement) error {
+	if err := Authorize(u, ActionCreate, nil); err != nil {
+		return err
+	}
+	if s.GroupId != 0 {
+		if err := AuthorizeGroup(u, s.GroupId); err != nil {
+			return err
+		}
+	}
+	if s.From != u.Username && s.To != u.Username && roleLevel[u.EffectiveRole()] < roleLevel[RoleApprover] {
+		return &AccessDenied{Reason: fmt.Sprintf("role %s can only record settlements it takes part in, not from %q to %q", u.EffectiveRole(), s.From, s.To)}
+	}
+	return nil
+}
+
+// AuthorizeGroup reports whether u may see and work with the expenses of
+// group id, which takes membership of the group or the admin role.
+func AuthorizeGroup(u User, id int) error {
+	if u.EffectiveRole() == RoleAdmin || u.memberOf(id) {
+		return nil
+	}
+	return &AccessDenied{Reason: fmt.Sprintf("user %q is not a member of group %d", u.Username, id)}
+}
+
+func require(u User, have, want Role, a Action) error {
+	if roleLevel[have] < roleLevel[want] {
+		return &AccessDenied{Reason: fmt.Sprintf("role %s is not allowed to %s, requires %s", have, a, want)}
+	}
+	return nil
+}
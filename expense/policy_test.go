@@ -0,0 +1,83 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	own := &Expense{Id: 1, Owner: "alice"}
+	other := &Expense{Id: 2, Owner: "bob"}
+
+	cases := []struct {
+		name    string
+		user    User
+		action  Action
+		ex      *Expense
+		allowed bool
+	}{
+		{"user without role can't view", User{Username: "alice"}, ActionView, nil, false},
+		{"viewer can view", User{Username: "alice", Role: RoleViewer}, ActionView, nil, true},
+		{"viewer can't create", User{Username: "alice", Role: RoleViewer}, ActionCreate, nil, false},
+		{"editor can create", User{Username: "alice", Role: RoleEditor}, ActionCreate, nil, true},
+		{"editor can update own expense", User{Username: "alice", Role: RoleEditor}, ActionUpdate, own, true},
+		{"editor can't update other's expense", User{Username: "alice", Role: RoleEditor}, ActionUpdate, other, false},
+		{"approver can update other's expense", User{Username: "alice", Role: RoleApprover}, ActionUpdate, other, true},
+		{"approver can't manage users", User{Username: "alice", Role: RoleApprover}, ActionManageUsers, nil, false},
+		{"admin can manage users", User{Username: "alice", Role: RoleAdmin}, ActionManageUsers, nil, true},
+		{"group role raises viewer to editor", User{Username: "alice", Role: RoleViewer, GroupRoles: []string{"editor"}}, ActionCreate, nil, true},
+		{"member can view group expense", User{Username: "alice", Role: RoleViewer, GroupIds: []int64{7}}, ActionView, &Expense{Id: 3, GroupId: 7}, true},
+		{"non-member can't view group expense", User{Username: "alice", Role: RoleApprover}, ActionView, &Expense{Id: 3, GroupId: 7}, false},
+		{"admin can view any group expense", User{Username: "alice", Role: RoleAdmin}, ActionView, &Expense{Id: 3, GroupId: 7}, true},
+		{"approver can approve other's expense", User{Username: "alice", Role: RoleApprover}, ActionApprove, other, true},
+		{"approver can't approve own expense", User{Username: "alice", Role: RoleApprover}, ActionApprove, own, false},
+		{"admin can't approve own expense", User{Username: "alice", Role: RoleAdmin}, ActionApprove, own, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Authorize(tc.user, tc.action, tc.ex)
+
+			if tc.allowed {
+				assert.Nil(t, err)
+			} else {
+				assert.IsType(t, &AccessDenied{}, err)
+			}
+		})
+	}
+}
+
+func TestCreateExpensesForbidden(t *testing.T) {
+	//arrange
+	e := echo.New()
+	reqBody := bytes.NewBufferString(`{
+		"title": "buy a new phone",
+		"amount": 39000,
+		"note": "buy a new phone",
+		"tags": ["gadget", "shopping"]
+	}`)
+	req := httptest.NewRequest(http.MethodPost, "/expenses", reqBody)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer})
+	var r Err
+
+	//action
+	err := serve(CreateExpenses, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "role viewer is not allowed to create, requires editor", r.Message)
+}
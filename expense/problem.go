@@ -0,0 +1,82 @@
+package expense
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem detail. Message repeats Detail and Errors
+// lists validation failures, both kept from the error body clients knew
+// before.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	Message   string       `json:"message"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// HTTPErrorHandler answers every error with application/problem+json. The
+// detail of a server error is not sent, it is logged with the request ID.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := Problem{Type: "about:blank", Status: http.StatusInternalServerError}
+	var e *Err
+	var he *echo.HTTPError
+	switch {
+	case errors.As(err, &e):
+		p.Status, p.Detail, p.Errors = e.Status, e.Message, e.Errors
+	case errors.As(err, &he):
+		p.Status, p.Detail = he.Code, fmt.Sprint(he.Message)
+	}
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+
+	p.Title = http.StatusText(p.Status)
+	p.Instance = c.Request().URL.Path
+	p.RequestId = c.Response().Header().Get(echo.HeaderXRequestID)
+	if p.Status >= http.StatusInternalServerError {
+		logServerError(c, err)
+		p.Detail, p.Errors = "the server couldn't process the request", nil
+	}
+	p.Message = p.Detail
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		Logger(c).Error("unable to write error response", ErrorAttrs(err)...)
+	}
+}
+
+// logServerError logs err with the request it failed, as the response
+// doesn't tell what went wrong. The message of an *Err says what failed,
+// such as "unable to create expense", and its cause is logged in detail.
+func logServerError(c echo.Context, err error) {
+	msg := "request failed"
+	var e *Err
+	if errors.As(err, &e) && e.Internal != nil {
+		msg, err = e.Message, e.Internal
+	}
+	attrs := append([]interface{}{
+		slog.String("method", c.Request().Method),
+		slog.String("path", c.Request().URL.Path),
+	}, ErrorAttrs(err)...)
+	Logger(c).Error(msg, attrs...)
+}
@@ -0,0 +1,100 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func problemOf(t *testing.T, err error) (Problem, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Response().Header().Set(echo.HeaderXRequestID, "req-1")
+
+	HTTPErrorHandler(err, c)
+
+	p := Problem{}
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&p))
+	assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+	return p, rec
+}
+
+func TestHTTPErrorHandlerClientError(t *testing.T) {
+	p, rec := problemOf(t, invalid(ValidationError{{Field: "title", Code: CodeRequired, Message: "title error : this field should not empty."}}))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, Problem{
+		Type:      "about:blank",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "title error : this field should not empty.",
+		Instance:  "/expenses/1",
+		RequestId: "req-1",
+		Message:   "title error : this field should not empty.",
+		Errors:    []FieldError{{Field: "title", Code: CodeRequired, Message: "title error : this field should not empty."}},
+	}, p)
+}
+
+func TestHTTPErrorHandlerHidesServerErrors(t *testing.T) {
+	for name, err := range map[string]error{
+		"Err":     internalErr("unable to query statement", errors.New(`pq: relation "expenses" does not exist`)),
+		"untyped": errors.New("pq: connection refused"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, rec := problemOf(t, err)
+
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Equal(t, "Internal Server Error", p.Title)
+			assert.Equal(t, "the server couldn't process the request", p.Detail)
+			assert.NotContains(t, rec.Body.String(), "pq:")
+		})
+	}
+}
+
+func TestHTTPErrorHandlerEchoError(t *testing.T) {
+	p, rec := problemOf(t, echo.NewHTTPError(http.StatusTooManyRequests, "too many requests, retry later"))
+
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "too many requests, retry later", p.Detail)
+	assert.Equal(t, "Too Many Requests", p.Title)
+}
+
+func TestHTTPErrorHandlerLogsDbErrors(t *testing.T) {
+	//arrange
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/expenses", nil), httptest.NewRecorder())
+	out := &bytes.Buffer{}
+	SetLogger(c, slog.New(slog.NewJSONHandler(out, nil)).With("request_id", "req-1"))
+	err := internalErr("unable to create expense", &pq.Error{Code: "23503", Detail: `Key (group_id)=(7) is not present in table "groups".`, Table: "expenses", Constraint: "expenses_group_id_fkey"})
+
+	//action
+	HTTPErrorHandler(err, c)
+
+	//assert
+	line := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "ERROR", line["level"])
+	assert.Equal(t, "unable to create expense", line["msg"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "POST", line["method"])
+	assert.Equal(t, map[string]interface{}{
+		"code":       "23503",
+		"condition":  "foreign_key_violation",
+		"detail":     `Key (group_id)=(7) is not present in table "groups".`,
+		"table":      "expenses",
+		"column":     "",
+		"constraint": "expenses_group_id_fkey",
+	}, line["db"])
+}
@@ -0,0 +1,216 @@
+package expense
+
+import (
+	"database/sql"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Receipt is a file backing an expense, the scan or photo of its receipt.
+// The listing leaves the content out, it is served on its own.
+type Receipt struct {
+	Id          int       `json:"id"`
+	ExpenseId   int       `json:"expense_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const maxReceiptSize = 10 << 20
+
+// receiptTypes are the types receipts may have, told from their content
+// rather than from what the client says.
+var receiptTypes = []string{"image/jpeg", "image/png", "application/pdf"}
+
+const selectReceipt = `SELECT id, expense_id, filename, content_type, size, uploaded_by, created_at FROM receipts`
+
+func scanReceipt(row scanner, r *Receipt) error {
+	return row.Scan(&r.Id, &r.ExpenseId, &r.Filename, &r.ContentType, &r.Size, &r.UploadedBy, &r.CreatedAt)
+}
+
+// loadReceipts returns the receipts of the expenses ids by expense.
+func loadReceipts(q querier, ids []int) (map[int][]Receipt, error) {
+	rows, err := q.Query(selectReceipt+` WHERE expense_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rs := map[int][]Receipt{}
+	for rows.Next() {
+		r := Receipt{}
+		if err := scanReceipt(rows, &r); err != nil {
+			return nil, err
+		}
+		rs[r.ExpenseId] = append(rs[r.ExpenseId], r)
+	}
+	return rs, rows.Err()
+}
+
+// editableExpense locks the expense id in tx for a change of its receipts,
+// which follows the rules of editing the expense.
+func editableExpense(tx *sql.Tx, u User, id string) (Expense, error) {
+	ex := Expense{}
+	err := scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, id), &ex)
+	switch err {
+	case sql.ErrNoRows:
+		return ex, &Err{Status: http.StatusNotFound, Message: "expense's not found"}
+	case nil:
+	default:
+		return ex, internalErr("can't scan expense", err)
+	}
+	if err := Authorize(u, ActionUpdate, &ex); err != nil {
+		return ex, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if ex.locked() {
+		return ex, &Err{Status: http.StatusConflict, Message: "expense is " + ex.Status + " and its receipts can't be changed"}
+	}
+	return ex, nil
+}
+
+// UploadReceipt attaches the file in the file field of a multipart form to
+// the expense.
+func UploadReceipt(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionUpdate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: "file error : this field should be a file."}
+	}
+	if fh.Size > maxReceiptSize {
+		return &Err{Status: http.StatusRequestEntityTooLarge, Message: "file error : this field should not larger than 10 MB."}
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return internalErr("unable to open receipt", err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxReceiptSize))
+	if err != nil {
+		return internalErr("unable to read receipt", err)
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	if !contains(receiptTypes, contentType) {
+		return &Err{Status: http.StatusUnsupportedMediaType, Message: "file error : this field should be a JPEG, PNG or PDF."}
+	}
+	r := Receipt{Filename: receiptFilename(fh.Filename), ContentType: contentType, Size: len(content), UploadedBy: u.Username}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	ex, err := editableExpense(tx, u, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	r.ExpenseId = ex.Id
+	err = tx.QueryRow(`INSERT INTO receipts (expense_id, filename, content_type, size, content, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`,
+		r.ExpenseId, r.Filename, r.ContentType, r.Size, content, r.UploadedBy).Scan(&r.Id, &r.CreatedAt)
+	if err != nil {
+		return internalErr("unable to insert receipt", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusCreated, r)
+}
+
+// receiptFilename keeps the base name of what the client called the file.
+func receiptFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return "receipt"
+	}
+	return name
+}
+
+func GetReceipts(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	ex, err := getExpense(u, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	rs, err := loadReceipts(Db, []int{ex.Id})
+	if err != nil {
+		return internalErr("unable to query receipts", err)
+	}
+	if rs[ex.Id] == nil {
+		return c.JSON(http.StatusOK, []Receipt{})
+	}
+	return c.JSON(http.StatusOK, rs[ex.Id])
+}
+
+// GetReceipt serves the content of a receipt as a download.
+func GetReceipt(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	ex, err := getExpense(u, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var filename, contentType string
+	var content []byte
+	err = Db.QueryRow(`SELECT filename, content_type, content FROM receipts WHERE id = $1 AND expense_id = $2`, c.Param("receiptId"), ex.Id).
+		Scan(&filename, &contentType, &content)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "receipt's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan receipt", err)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, contentType, content)
+}
+
+func DeleteReceipt(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionUpdate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	ex, err := editableExpense(tx, u, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM receipts WHERE id = $1 AND expense_id = $2`, c.Param("receiptId"), ex.Id)
+	if err != nil {
+		return internalErr("unable to delete receipt", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "receipt's not found"}
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
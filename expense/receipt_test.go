@@ -0,0 +1,178 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var receiptRows = []string{"id", "expense_id", "filename", "content_type", "size", "uploaded_by", "created_at"}
+
+var pngContent = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func receiptContext(req *http.Request, u User, receiptId string) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	SetCurrentUser(c, u)
+	c.SetPath("/expenses/:id/receipts/:receiptId")
+	c.SetParamNames("id", "receiptId")
+	c.SetParamValues("1", receiptId)
+	return c, rec
+}
+
+func uploadRequest(filename string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, _ := w.CreateFormFile("file", filename)
+	part.Write(content)
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/expenses/1/receipts", body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return req
+}
+
+func expectExpenseForUpdate(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), "alice", "", "", []byte("[]"), 0, status, "THB", testDate, []byte("{}")))
+}
+
+func TestUploadReceipt(t *testing.T) {
+	alice := User{Username: "alice", Role: RoleEditor}
+
+	t.Run("should store the receipt with the type of its content", func(t *testing.T) {
+		//arrange
+		var mock sqlmock.Sqlmock
+		var err error
+		Db, mock, err = sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer Db.Close()
+		mock.ExpectBegin()
+		expectExpenseForUpdate(mock, StatusDraft)
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO receipts`)).
+			WithArgs(1, "taxi.png", "image/png", len(pngContent), pngContent, "alice").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+		mock.ExpectCommit()
+		c, rec := receiptContext(uploadRequest(`C:\scans\taxi.png`, pngContent), alice, "")
+		r := Receipt{}
+
+		//action
+		err = serve(UploadReceipt, c)
+		assert.Nil(t, err)
+		err = json.NewDecoder(rec.Body).Decode(&r)
+
+		//assert
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, 3, r.Id)
+		assert.Equal(t, "taxi.png", r.Filename)
+		assert.Equal(t, "image/png", r.ContentType)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should refuse files that are not images or PDFs", func(t *testing.T) {
+		c, rec := receiptContext(uploadRequest("taxi.png", []byte("<script>alert(1)</script>")), alice, "")
+
+		err := serve(UploadReceipt, c)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("should refuse receipts of a submitted expense", func(t *testing.T) {
+		//arrange
+		var mock sqlmock.Sqlmock
+		var err error
+		Db, mock, err = sqlmock.New()
+		if err != nil {
+			t.Fatal("unable to create mock db", err)
+		}
+		defer Db.Close()
+		mock.ExpectBegin()
+		expectExpenseForUpdate(mock, StatusSubmitted)
+		mock.ExpectRollback()
+		c, rec := receiptContext(uploadRequest("taxi.png", pngContent), alice, "")
+
+		//action
+		err = serve(UploadReceipt, c)
+
+		//assert
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), "expense is submitted and its receipts can't be changed")
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetReceipt(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectPrepare(regexp.QuoteMeta(`FROM expenses WHERE id = $1`)).
+		ExpectQuery().
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), "alice", "", "", []byte("[]"), 0, StatusApproved, "THB", testDate, []byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT filename, content_type, content FROM receipts WHERE id = $1 AND expense_id = $2`)).
+		WithArgs("3", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"filename", "content_type", "content"}).AddRow(`taxi "1".png`, "image/png", pngContent))
+	c, rec := receiptContext(httptest.NewRequest(http.MethodGet, "/expenses/1/receipts/3", nil), User{Username: "bob", Role: RoleApprover}, "3")
+
+	//action
+	err = serve(GetReceipt, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, `attachment; filename="taxi \"1\".png"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, pngContent, rec.Body.Bytes())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteReceiptNotFound(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectBegin()
+	expectExpenseForUpdate(mock, StatusDraft)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM receipts WHERE id = $1 AND expense_id = $2`)).
+		WithArgs("9", 1).
+		WillReturnResult(driver.RowsAffected(0))
+	mock.ExpectRollback()
+	c, rec := receiptContext(httptest.NewRequest(http.MethodDelete, "/expenses/1/receipts/9", nil), User{Username: "alice", Role: RoleEditor}, "9")
+
+	//action
+	err = serve(DeleteReceipt, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,398 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Report bundles expenses of one owner into a claim that goes through the
+// approval workflow as a whole.
+type Report struct {
+	Id          int                `json:"id"`
+	Title       string             `json:"title"`
+	PeriodStart Date               `json:"period_start"`
+	PeriodEnd   Date               `json:"period_end"`
+	Owner       string             `json:"owner"`
+	Status      string             `json:"status"`
+	ExpenseIds  []int              `json:"expense_ids"`
+	Expenses    []Expense          `json:"expenses"`
+	Totals      map[string]float64 `json:"totals"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+func (r *Report) validation() error {
+	if r.Title == "" {
+		return fmt.Errorf("title error : this field should not empty.")
+	}
+	if r.PeriodStart.IsZero() || r.PeriodEnd.IsZero() {
+		return fmt.Errorf("period error : period_start and period_end should not empty.")
+	}
+	if r.PeriodEnd.Before(r.PeriodStart.Time) {
+		return fmt.Errorf("period error : period_end should not be before period_start.")
+	}
+	if len(r.ExpenseIds) == 0 {
+		return fmt.Errorf("expense_ids error : this field should have at least 1.")
+	}
+	return nil
+}
+
+// editable reports whether the report may still change, which it may until
+// it is submitted and again once it is rejected.
+func (r *Report) editable() bool {
+	return r.Status == StatusDraft || r.Status == StatusRejected
+}
+
+// totals adds up the expenses of the report per currency.
+func (r *Report) totals() {
+	cents := map[string]int64{}
+	for _, ex := range r.Expenses {
+		cents[ex.Currency] += toCents(float64(ex.Amount))
+	}
+	r.Totals = map[string]float64{}
+	for cur, c := range cents {
+		r.Totals[cur] = fromCents(c)
+	}
+}
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const selectReport = `SELECT id, title, period_start, period_end, owner, status, created_at FROM expense_reports`
+
+func scanReport(row scanner, r *Report) error {
+	return row.Scan(&r.Id, &r.Title, &r.PeriodStart, &r.PeriodEnd, &r.Owner, &r.Status, &r.CreatedAt)
+}
+
+// loadReport reads report id with its expenses. lock takes row locks on both
+// for the transaction q.
+func loadReport(q querier, id string, lock bool) (Report, error) {
+	forUpdate := ""
+	if lock {
+		forUpdate = ` FOR UPDATE`
+	}
+
+	r := Report{}
+	if err := scanReport(q.QueryRow(selectReport+` WHERE id = $1`+forUpdate, id), &r); err != nil {
+		return r, err
+	}
+
+	rows, err := q.Query(`SELECT `+expenseColumns+` FROM expenses
+		WHERE id IN (SELECT expense_id FROM expense_report_items WHERE report_id = $1) ORDER BY date, id`+forUpdate, r.Id)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	r.ExpenseIds, r.Expenses = []int{}, []Expense{}
+	for rows.Next() {
+		ex := Expense{}
+		if err := scanExpense(rows, &ex); err != nil {
+			return r, err
+		}
+		r.ExpenseIds = append(r.ExpenseIds, ex.Id)
+		r.Expenses = append(r.Expenses, ex)
+	}
+	r.totals()
+	return r, rows.Err()
+}
+
+// attachExpenses files the expenses in r.ExpenseIds under the report in tx.
+// Expenses have to belong to the report owner, fall in its period and not be
+// submitted elsewhere.
+func attachExpenses(tx *sql.Tx, u User, r *Report) error {
+	if _, err := tx.Exec(`DELETE FROM expense_report_items WHERE report_id = $1`, r.Id); err != nil {
+		return internalErr("unable to clear report items", err)
+	}
+
+	r.Expenses = []Expense{}
+	for _, id := range r.ExpenseIds {
+		ex := Expense{}
+		err := scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, id), &ex)
+		if err == nil && Authorize(u, ActionView, &ex) != nil {
+			err = sql.ErrNoRows
+		}
+		switch err {
+		case sql.ErrNoRows:
+			return &Err{Status: http.StatusBadRequest, Message: fmt.Sprintf("expense_ids error : expense %d is not found.", id)}
+		case nil:
+		default:
+			return internalErr("can't scan expense", err)
+		}
+		if ex.Owner != r.Owner {
+			return &Err{Status: http.StatusBadRequest, Message: fmt.Sprintf("expense_ids error : expense %d belongs to %q.", id, ex.Owner)}
+		}
+		if ex.Date.Before(r.PeriodStart.Time) || ex.Date.After(r.PeriodEnd.Time) {
+			return &Err{Status: http.StatusBadRequest, Message: fmt.Sprintf("expense_ids error : expense %d is dated %s, outside the period.", id, ex.Date)}
+		}
+		if ex.Status != StatusDraft && ex.Status != StatusRejected {
+			return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("expense_ids error : expense %d is %s.", id, ex.Status)}
+		}
+
+		_, err = tx.Exec(`INSERT INTO expense_report_items (report_id, expense_id) VALUES ($1, $2)`, r.Id, id)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("expense_ids error : expense %d is already in a report.", id)}
+		}
+		if err != nil {
+			return internalErr("unable to add report item", err)
+		}
+		r.Expenses = append(r.Expenses, ex)
+	}
+	r.totals()
+	return nil
+}
+
+func CreateReport(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionCreate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	r := Report{}
+	if err := c.Bind(&r); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := r.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	r.Owner, r.Status = u.Username, StatusDraft
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO expense_reports (title, period_start, period_end, owner) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		r.Title, r.PeriodStart, r.PeriodEnd, r.Owner).Scan(&r.Id, &r.CreatedAt)
+	if err != nil {
+		return internalErr("unable to create report", err)
+	}
+	if err := attachExpenses(tx, u, &r); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusCreated, r)
+}
+
+// GetReports lists the reports of the current user, or every report to
+// approvers. ?status= narrows the list.
+func GetReports(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	all := Authorize(u, ActionApprove, nil) == nil
+	rows, err := Db.Query(selectReport+` WHERE ($1 OR owner = $2) AND ($3 = '' OR status = $3) ORDER BY id`,
+		all, u.Username, c.QueryParam("status"))
+	if err != nil {
+		return internalErr("unable to query reports", err)
+	}
+	defer rows.Close()
+
+	rs := []Report{}
+	for rows.Next() {
+		r := Report{}
+		if err := scanReport(rows, &r); err != nil {
+			return internalErr("unable to scan report", err)
+		}
+		rs = append(rs, r)
+	}
+	return c.JSON(http.StatusOK, rs)
+}
+
+// viewReport loads the report in the path when the current user may see it.
+func viewReport(c echo.Context) (Report, error) {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return Report{}, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	r, err := loadReport(Db, c.Param("id"), false)
+	if err == nil && AuthorizeReport(u, ActionView, &r) != nil {
+		err = sql.ErrNoRows
+	}
+	switch err {
+	case sql.ErrNoRows:
+		return r, &Err{Status: http.StatusNotFound, Message: "report's not found"}
+	case nil:
+		return r, nil
+	default:
+		return r, internalErr("can't scan report", err)
+	}
+}
+
+func GetReportById(c echo.Context) error {
+	r, err := viewReport(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// UpdateReport replaces the title, period and expenses of a report that is
+// not submitted.
+func UpdateReport(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionUpdate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	b := Report{}
+	if err := c.Bind(&b); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := b.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	r := Report{}
+	err = scanReport(tx.QueryRow(selectReport+` WHERE id = $1 FOR UPDATE`, c.Param("id")), &r)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "report's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan report", err)
+	}
+	if err := AuthorizeReport(u, ActionUpdate, &r); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if !r.editable() {
+		return &Err{Status: http.StatusConflict, Message: "report is " + r.Status + " and can't be edited"}
+	}
+
+	r.Title, r.PeriodStart, r.PeriodEnd, r.ExpenseIds = b.Title, b.PeriodStart, b.PeriodEnd, b.ExpenseIds
+	_, err = tx.Exec(`UPDATE expense_reports SET title = $2, period_start = $3, period_end = $4 WHERE id = $1`,
+		r.Id, r.Title, r.PeriodStart, r.PeriodEnd)
+	if err != nil {
+		return internalErr("unable to update report", err)
+	}
+	if err := attachExpenses(tx, u, &r); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// DeleteReport removes a report that is not submitted. Its expenses are kept.
+func DeleteReport(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionDelete, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	r := Report{}
+	err = scanReport(tx.QueryRow(selectReport+` WHERE id = $1 FOR UPDATE`, c.Param("id")), &r)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "report's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan report", err)
+	}
+	if err := AuthorizeReport(u, ActionDelete, &r); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if !r.editable() {
+		return &Err{Status: http.StatusConflict, Message: "report is " + r.Status + " and can't be deleted"}
+	}
+
+	if _, err = tx.Exec(`DELETE FROM expense_reports WHERE id = $1`, r.Id); err != nil {
+		return internalErr("unable to delete report", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func SubmitReport(c echo.Context) error {
+	return transitionReport(c, "submit")
+}
+
+func ApproveReport(c echo.Context) error {
+	return transitionReport(c, "approve")
+}
+
+func RejectReport(c echo.Context) error {
+	return transitionReport(c, "reject")
+}
+
+// transitionReport moves the report in the path and every expense in it
+// through the step name. Either all of them move or none does.
+func transitionReport(c echo.Context, name string) error {
+	u := CurrentUser(c)
+	step := workflow[name]
+	if err := Authorize(u, step.action, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	body := struct {
+		Comment string `json:"comment"`
+	}{}
+	if err := c.Bind(&body); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	r, err := loadReport(tx, c.Param("id"), true)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "report's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan report", err)
+	}
+	if err := AuthorizeReport(u, step.action, &r); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if !contains(step.from, r.Status) {
+		return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("can't %s report %d, it is %s", name, r.Id, r.Status)}
+	}
+	if len(r.Expenses) == 0 {
+		return &Err{Status: http.StatusConflict, Message: fmt.Sprintf("can't %s report %d, it has no expenses", name, r.Id)}
+	}
+
+	for i := range r.Expenses {
+		if err := transition(tx, c, &r.Expenses[i], name, body.Comment); err != nil {
+			return transitionError(err)
+		}
+	}
+	r.Status = step.to
+	if _, err = tx.Exec(`UPDATE expense_reports SET status = $2 WHERE id = $1`, r.Id, r.Status); err != nil {
+		return internalErr("unable to update report", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, r)
+}
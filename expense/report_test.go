@@ -0,0 +1,281 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var reportRows = []string{"id", "title", "period_start", "period_end", "owner", "status", "created_at"}
+
+func expectReport(mock sqlmock.Sqlmock, status string, lock bool) {
+	forUpdate := ""
+	if lock {
+		forUpdate = " FOR UPDATE"
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_reports WHERE id = $1` + forUpdate)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(reportRows).
+			AddRow(1, "December trip", time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC), "alice", status, time.Now()))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_report_items WHERE report_id = $1) ORDER BY date, id` + forUpdate)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "taxi", 250, "airport", pq.Array([]string{"travel"}), "alice", "", "", []byte("[]"), 0, status, "THB", testDate, []byte("{}")).
+			AddRow(2, "hotel", 100, "", pq.Array([]string{"travel", "lodging"}), "alice", "", "", []byte("[]"), 0, status, "USD", testDate, []byte("{}")))
+}
+
+func reportContext(method, target, body string, u User) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, u)
+	c.SetPath("/reports/:id")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	return c, rec
+}
+
+func TestGetReportById(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	expectReport(mock, StatusDraft, false)
+
+	c, rec := reportContext(http.MethodGet, "/reports/1", "", User{Username: "alice", Role: RoleEditor})
+	r := Report{}
+
+	//action
+	err = serve(GetReportById, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []int{1, 2}, r.ExpenseIds)
+	assert.Equal(t, map[string]float64{"THB": 250, "USD": 100}, r.Totals)
+	assert.Equal(t, "2022-12-01", r.PeriodStart.String())
+}
+
+func TestGetReportByIdOfAnotherUser(t *testing.T) {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	expectReport(mock, StatusDraft, false)
+
+	c, rec := reportContext(http.MethodGet, "/reports/1", "", User{Username: "bob", Role: RoleEditor})
+
+	err = serve(GetReportById, c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCreateReportOutsidePeriod(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expense_reports (title, period_start, period_end, owner) VALUES ($1, $2, $3, $4) RETURNING id, created_at`)).
+		WithArgs("January", "2023-01-01", "2023-01-31", "alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM expense_report_items WHERE report_id = $1`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(expenseRows).
+			AddRow(1, "taxi", 250, "", pq.Array([]string{"travel"}), "alice", "", "", []byte("[]"), 0, StatusDraft, "THB", testDate, []byte("{}")))
+	mock.ExpectRollback()
+
+	c, rec := reportContext(http.MethodPost, "/reports",
+		`{"title":"January","period_start":"2023-01-01","period_end":"2023-01-31","expense_ids":[1]}`, User{Username: "alice", Role: RoleEditor})
+	var r Err
+
+	//action
+	err = serve(CreateReport, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "expense_ids error : expense 1 is dated 2022-12-24, outside the period.", r.Message)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSubmitReport(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	expectReport(mock, StatusDraft, true)
+	for id := 1; id <= 2; id++ {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE expenses SET status = $2 WHERE id = $1`)).
+			WithArgs(id, StatusSubmitted).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_transitions`)).
+			WithArgs(id, StatusDraft, StatusSubmitted, "", "alice").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE expense_reports SET status = $2 WHERE id = $1`)).
+		WithArgs(1, StatusSubmitted).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	c, rec := reportContext(http.MethodPost, "/reports/1/submit", `{}`, User{Username: "alice", Role: RoleEditor})
+	r := Report{}
+
+	//action
+	err = serve(SubmitReport, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, StatusSubmitted, r.Status)
+	for _, ex := range r.Expenses {
+		assert.Equal(t, StatusSubmitted, ex.Status)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetReportStatement(t *testing.T) {
+	for _, format := range []string{"html", "pdf"} {
+		t.Run(format, func(t *testing.T) {
+			var mock sqlmock.Sqlmock
+			var err error
+			Db, mock, err = sqlmock.New()
+			if err != nil {
+				t.Fatal("unable to create mock db", err)
+			}
+			defer Db.Close()
+			expectReport(mock, StatusApproved, false)
+			mock.ExpectQuery(regexp.QuoteMeta(selectReceipt + ` WHERE expense_id = ANY($1) ORDER BY id`)).
+				WithArgs(pq.Array([]int{1, 2})).
+				WillReturnRows(sqlmock.NewRows(receiptRows).AddRow(3, 1, "taxi-receipt.jpg", "image/jpeg", 1024, "alice", time.Now()))
+
+			c, rec := reportContext(http.MethodGet, "/reports/1/statement?format="+format, "", User{Username: "alice", Role: RoleEditor})
+
+			err = serve(GetReportStatement, c)
+
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			body := rec.Body.String()
+			assert.Contains(t, body, "December trip")
+			assert.Contains(t, body, "travel, lodging")
+			assert.Contains(t, body, "250.00 THB")
+			assert.Contains(t, body, "taxi-receipt.jpg")
+			assert.Nil(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	buf := &bytes.Buffer{}
+	lines := make([]string, pdfLinesPerPage+1)
+	lines[0] = "total (THB) 250.00 \\ €"
+
+	err := writePDF(buf, lines)
+
+	assert.Nil(t, err)
+	pdf := buf.String()
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	assert.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+	assert.Contains(t, pdf, "(total \\(THB\\) 250.00 \\\\ \x80) '")
+	assert.Contains(t, pdf, "/Count 2")
+	// the cross-reference table points at every object
+	for n := 1; n <= 7; n++ {
+		offset := strings.Index(pdf, fmt.Sprintf("\n%d 0 obj\n", n)) + 1
+		assert.Contains(t, pdf, fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+}
+
+func TestWritePDFRefusesThai(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	err := writePDF(buf, []string{"EXPENSE REPORT #1", "Owner:  สมชาย"})
+
+	assert.EqualError(t, err, `line 2: 'ส' can't be written in the PDF font`)
+	assert.Zero(t, buf.Len())
+}
+
+func TestWritePDFEmbedsFont(t *testing.T) {
+	// DejaVu Sans has no Thai, its Greek and combining accents are beyond
+	// Courier all the same
+	const path = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+	if err := LoadPDFFont(path); err != nil {
+		t.Skip("no font to embed:", err)
+	}
+	defer func() { pdfTrueType = nil }()
+	buf := &bytes.Buffer{}
+
+	err := writePDF(buf, []string{"Ω 250.00", "Cafe\u0301"})
+
+	assert.Nil(t, err)
+	pdf := buf.String()
+	assert.Contains(t, pdf, "/Subtype /Type0 /BaseFont /DejaVuSans /Encoding /Identity-H")
+	assert.Contains(t, pdf, "/FontFile2 6 0 R")
+	assert.Regexp(t, `<[0-9A-F]{4}> <03A9>`, pdf)
+	assert.Regexp(t, `<[0-9A-F]{4}> <0301>`, pdf)
+	assert.Contains(t, pdf, "] TJ")
+	for n := 1; n <= 9; n++ {
+		offset := strings.Index(pdf, fmt.Sprintf("\n%d 0 obj\n", n)) + 1
+		assert.Contains(t, pdf, fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+
+	err = writePDF(&bytes.Buffer{}, []string{"Owner:  สมชาย"})
+
+	assert.EqualError(t, err, `line 1: 'ส' has no glyph in the PDF font DejaVuSans`)
+}
+
+func TestColumn(t *testing.T) {
+	assert.Equal(t, "taxi  ", column("taxi", 6))
+	assert.Equal(t, "tax", column("taxi", 3))
+	// the vowels and tone marks over the consonants take no column
+	assert.Equal(t, "ที่พัก  ", column("ที่พัก", 5))
+	assert.Equal(t, "ที่พั", column("ที่พัก", 2))
+}
@@ -0,0 +1,184 @@
+package expense
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Revision is the state of an expense after one of its writes. Revisions are
+// numbered from 1 per expense.
+type Revision struct {
+	ExpenseId int       `json:"expense_id"`
+	Revision  int       `json:"revision"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+	Expense   Expense   `json:"expense"`
+}
+
+// recordChange writes the audit entry, the new revision and the outbox event
+// of an expense mutation in tx. before is nil on create and after
+// is nil on delete, where the revision keeps the last state of the expense.
+func recordChange(tx *sql.Tx, c echo.Context, action string, before, after *Expense) error {
+	if err := writeAudit(tx, c, action, before, after); err != nil {
+		return err
+	}
+	ex := changedExpense(before, after)
+	if err := writeRevision(tx, c, action, ex); err != nil {
+		return err
+	}
+	return writeOutbox(tx, eventFor(action), ex)
+}
+
+func writeRevision(tx *sql.Tx, c echo.Context, action string, ex *Expense) error {
+	data, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO expense_revisions (expense_id, revision, data, action, actor)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4 FROM expense_revisions WHERE expense_id = $1`,
+		ex.Id, string(data), action, CurrentUser(c).Username)
+	return err
+}
+
+const selectRevision = `SELECT expense_id, revision, action, actor, created_at, data FROM expense_revisions`
+
+func scanRevision(row scanner, r *Revision) error {
+	var data []byte
+	if err := row.Scan(&r.ExpenseId, &r.Revision, &r.Action, &r.Actor, &r.CreatedAt, &data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.Expense)
+}
+
+func GetExpenseRevisions(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(selectRevision+` WHERE expense_id = $1 ORDER BY revision`, c.Param("id"))
+	if err != nil {
+		return internalErr("unable to query revisions", err)
+	}
+	defer rows.Close()
+
+	rs := []Revision{}
+	for rows.Next() {
+		r := Revision{}
+		if err := scanRevision(rows, &r); err != nil {
+			return internalErr("unable to scan revision", err)
+		}
+		rs = append(rs, r)
+	}
+	// the latest revision holds the group the expense is filed under now
+	if len(rs) == 0 || Authorize(u, ActionView, &rs[len(rs)-1].Expense) != nil {
+		return &Err{Status: http.StatusNotFound, Message: "expense's revisions not found"}
+	}
+	return c.JSON(http.StatusOK, rs)
+}
+
+func GetExpenseRevision(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	// as for the list, the latest revision holds the group the expense is
+	// filed under now, whatever group it had at revision n
+	latest := Revision{}
+	err := scanRevision(Db.QueryRow(selectRevision+` WHERE expense_id = $1 ORDER BY revision DESC LIMIT 1`, c.Param("id")), &latest)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's revision not found"}
+	case nil:
+		if Authorize(u, ActionView, &latest.Expense) != nil {
+			return &Err{Status: http.StatusNotFound, Message: "expense's revision not found"}
+		}
+	default:
+		return internalErr("can't scan revision", err)
+	}
+
+	r := Revision{}
+	err = scanRevision(Db.QueryRow(selectRevision+` WHERE expense_id = $1 AND revision = $2`, c.Param("id"), c.Param("n")), &r)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's revision not found"}
+	case nil:
+		return c.JSON(http.StatusOK, r)
+	default:
+		return internalErr("can't scan revision", err)
+	}
+}
+
+// RevertExpense sets the expense back to the content of revision ?to=n. The
+// revert is recorded as a new revision, earlier revisions are kept.
+func RevertExpense(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionUpdate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	to, err := strconv.Atoi(c.QueryParam("to"))
+	if err != nil || to < 1 {
+		return &Err{Status: http.StatusBadRequest, Message: "to error : this field should be a revision number."}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	cur := Expense{}
+	err = scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, c.Param("id")), &cur)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's not found"}
+	case nil:
+	default:
+		return internalErr("can't scan expense", err)
+	}
+	if err := Authorize(u, ActionUpdate, &cur); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if cur.locked() {
+		return &Err{Status: http.StatusConflict, Message: "expense is " + cur.Status + " and can't be edited"}
+	}
+
+	r := Revision{}
+	err = scanRevision(tx.QueryRow(selectRevision+` WHERE expense_id = $1 AND revision = $2`, cur.Id, to), &r)
+	switch err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "expense's revision not found"}
+	case nil:
+	default:
+		return internalErr("can't scan revision", err)
+	}
+	if r.Expense.GroupId != 0 {
+		if err := AuthorizeGroup(u, r.Expense.GroupId); err != nil {
+			return &Err{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+	// the revision passed the rules of its day, it has to pass today's
+	if err := r.Expense.validation(); err != nil {
+		return invalid(err)
+	}
+
+	ex, err := updateExpense(tx, cur.Id, r.Expense)
+	if err != nil {
+		return internalErr("can't scan updated expense", err)
+	}
+	if err = recordChange(tx, c, "revert", &cur, &ex); err != nil {
+		return internalErr("unable to record change", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusOK, ex)
+}
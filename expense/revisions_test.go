@@ -0,0 +1,187 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var revisionRows = []string{"expense_id", "revision", "action", "actor", "created_at", "data"}
+
+func TestGetExpenseRevisionUnit(t *testing.T) {
+	//arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	c.SetPath("/:id/revisions/:n")
+	c.SetParamNames("id", "n")
+	c.SetParamValues("1", "2")
+
+	var mock sqlmock.Sqlmock
+	var err error
+	rt := Revision{}
+
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_revisions WHERE expense_id = $1 ORDER BY revision DESC LIMIT 1`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(revisionRows).
+			AddRow(1, 3, "update", "admin", time.Now(), []byte(`{"id":1,"title":"apple smoothie","amount":99,"tags":["beverage"],"owner":"admin"}`)))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT expense_id, revision, action, actor, created_at, data FROM expense_revisions WHERE expense_id = $1 AND revision = $2`)).
+		WithArgs("1", "2").
+		WillReturnRows(sqlmock.NewRows(revisionRows).
+			AddRow(1, 2, "update", "admin", time.Now(), []byte(`{"id":1,"title":"apple smoothie","amount":89,"note":"no discount","tags":["beverage"],"owner":"admin"}`)))
+
+	//action
+	err = serve(GetExpenseRevision, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&rt)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, rt.Revision)
+	assert.Equal(t, "apple smoothie", rt.Expense.Title)
+}
+
+func TestGetExpenseRevisionOfOtherGroup(t *testing.T) {
+	//arrange
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	// the expense was moved out of group 7, where alice still sees revision 1
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_revisions WHERE expense_id = $1 ORDER BY revision DESC LIMIT 1`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(revisionRows).
+			AddRow(1, 2, "update", "bob", time.Now(), []byte(`{"id":1,"title":"taxi","amount":250,"tags":["travel"],"owner":"bob","group_id":8}`)))
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	SetCurrentUser(c, User{Username: "alice", Role: RoleViewer, GroupIds: []int64{7}})
+	c.SetParamNames("id", "n")
+	c.SetParamValues("1", "1")
+
+	//action
+	err = serve(GetExpenseRevision, c)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertExpenseUnit(t *testing.T) {
+	//arrange
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/?to=1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	c.SetPath("/:id/revert")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	var mock sqlmock.Sqlmock
+	var err error
+	tags := []string{"food"}
+	rt := Expense{}
+
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, title, amount, note, tags, owner, payer, split, participants, COALESCE(group_id, 0), status, currency, date, metadata FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "apple smoothie", 89, "no discount", pq.Array([]string{"beverage"}), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_revisions WHERE expense_id = $1 AND revision = $2`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(revisionRows).
+			AddRow(1, 1, "create", "admin", time.Now(), []byte(`{"id":1,"title":"strawberry smoothie","amount":79,"note":"night market","tags":["food"],"owner":"admin"}`)))
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE expenses SET`)).
+		WithArgs("strawberry smoothie", float32(79), "night market", pq.Array(tags), "", "", Participants(nil), 0, "", Date{}, Metadata(nil), 1).
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "strawberry smoothie", 79, "night market", pq.Array(tags), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs("admin", "revert", 1, "", sqlmock.AnyArg(), sqlmock.AnyArg(), pq.Array([]string{"amount", "note", "tags", "title"})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO expense_revisions`)).
+		WithArgs(1, sqlmock.AnyArg(), "revert", "admin").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).
+		WithArgs(1, "expense.updated", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	//action
+	err = serve(RevertExpense, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&rt)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "strawberry smoothie", rt.Title)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertExpenseValidates(t *testing.T) {
+	//arrange
+	en, _ := NewRuleEngine(RulesConfig{Default: RuleSet{MaxAmount: 50}})
+	setRules(en)
+	defer setRules(&RuleEngine{})
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "apple smoothie", 39, "", pq.Array([]string{"beverage"}), "admin", "", "", []byte("[]"), 0, "draft", "THB", testDate, []byte("{}")))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expense_revisions WHERE expense_id = $1 AND revision = $2`)).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows(revisionRows).
+			AddRow(1, 1, "create", "admin", time.Now(), []byte(`{"id":1,"title":"strawberry smoothie","amount":79,"tags":["food"],"owner":"admin"}`)))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPost, "/?to=1", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	var r Err
+
+	//action
+	err = serve(RevertExpense, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "amount error : this field should not more than 50.00.", r.Message)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
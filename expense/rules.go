@@ -0,0 +1,239 @@
+package expense
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+	// the zone of the rules loads on images without a zoneinfo database
+	_ "time/tzdata"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is one configurable check on an expense. It adds what it finds wrong
+// to v.
+type Rule interface {
+	Check(e *Expense, v *ValidationError)
+}
+
+type MaxAmountRule struct{ Max float64 }
+
+func (r MaxAmountRule) Check(e *Expense, v *ValidationError) {
+	if toCents(float64(e.Amount)) > toCents(r.Max) {
+		v.add("amount", CodeMax, fmt.Sprintf("amount error : this field should not more than %.2f.", r.Max))
+	}
+}
+
+// MaxLengthRule limits the title or the note to Max characters.
+type MaxLengthRule struct {
+	Field string
+	Max   int
+}
+
+func (r MaxLengthRule) Check(e *Expense, v *ValidationError) {
+	s := e.Title
+	if r.Field == "note" {
+		s = e.Note
+	}
+	if utf8.RuneCountInString(s) > r.Max {
+		v.add(r.Field, CodeMaxLength, fmt.Sprintf("%s error : this field should not longer than %d characters.", r.Field, r.Max))
+	}
+}
+
+// AllowedTagsRule limits tags to those listed, or to those matching Pattern.
+// A tag passes when either allows it.
+type AllowedTagsRule struct {
+	Tags    []string
+	Pattern *regexp.Regexp
+}
+
+func (r AllowedTagsRule) Check(e *Expense, v *ValidationError) {
+	for _, t := range e.Tags {
+		if contains(r.Tags, t) || (r.Pattern != nil && r.Pattern.MatchString(t)) {
+			continue
+		}
+		v.add("tags", CodeEnum, fmt.Sprintf("tags error : %s is not an allowed tag.", t))
+	}
+}
+
+// NoteRequiredRule asks for a note on expenses of more than Above.
+type NoteRequiredRule struct{ Above float64 }
+
+func (r NoteRequiredRule) Check(e *Expense, v *ValidationError) {
+	if e.Note == "" && toCents(float64(e.Amount)) > toCents(r.Above) {
+		v.add("note", CodeRequired, fmt.Sprintf("note error : this field should not empty when amount is more than %.2f.", r.Above))
+	}
+}
+
+// NoFutureDateRule refuses dates after today in Location, the zone the
+// business runs in. Without one, a date is in the future once it is so in
+// every zone, UTC+14 being the first to start a day.
+type NoFutureDateRule struct{ Location *time.Location }
+
+var earliestZone = time.FixedZone("UTC+14", 14*60*60)
+
+func (r NoFutureDateRule) Check(e *Expense, v *ValidationError) {
+	loc := r.Location
+	if loc == nil {
+		loc = earliestZone
+	}
+	if e.Date.After(NewDate(time.Now().In(loc)).Time) {
+		v.add("date", CodeFuture, "date error : this field should not be in the future.")
+	}
+}
+
+// RuleSet is how rules are written in the config. Zero values turn a rule
+// off.
+type RuleSet struct {
+	MaxAmount         float64  `json:"max_amount,omitempty" yaml:"max_amount"`
+	MaxTitleLength    int      `json:"max_title_length,omitempty" yaml:"max_title_length"`
+	MaxNoteLength     int      `json:"max_note_length,omitempty" yaml:"max_note_length"`
+	AllowedTags       []string `json:"allowed_tags,omitempty" yaml:"allowed_tags"`
+	TagPattern        string   `json:"tag_pattern,omitempty" yaml:"tag_pattern"`
+	NoteRequiredAbove float64  `json:"note_required_above,omitempty" yaml:"note_required_above"`
+	NoFutureDate      bool     `json:"no_future_date,omitempty" yaml:"no_future_date"`
+}
+
+// Rules compiles the set into the rules it turns on. Dates are checked in
+// loc, see NoFutureDateRule.
+func (s RuleSet) Rules(loc *time.Location) ([]Rule, error) {
+	rs := []Rule{}
+	if s.MaxAmount < 0 || s.MaxTitleLength < 0 || s.MaxNoteLength < 0 || s.NoteRequiredAbove < 0 {
+		return nil, fmt.Errorf("limits should not less than 0")
+	}
+	if s.MaxAmount > 0 {
+		rs = append(rs, MaxAmountRule{Max: s.MaxAmount})
+	}
+	if s.MaxTitleLength > 0 {
+		rs = append(rs, MaxLengthRule{Field: "title", Max: s.MaxTitleLength})
+	}
+	if s.MaxNoteLength > 0 {
+		rs = append(rs, MaxLengthRule{Field: "note", Max: s.MaxNoteLength})
+	}
+	if len(s.AllowedTags) > 0 || s.TagPattern != "" {
+		r := AllowedTagsRule{Tags: s.AllowedTags}
+		if s.TagPattern != "" {
+			p, err := regexp.Compile(`^(?:` + s.TagPattern + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("tag_pattern: %w", err)
+			}
+			r.Pattern = p
+		}
+		rs = append(rs, r)
+	}
+	if s.NoteRequiredAbove > 0 {
+		rs = append(rs, NoteRequiredRule{Above: s.NoteRequiredAbove})
+	}
+	if s.NoFutureDate {
+		rs = append(rs, NoFutureDateRule{Location: loc})
+	}
+	return rs, nil
+}
+
+// RulesConfig holds the rules of a deployment. Groups are the tenants: an
+// expense filed under a group listed here is checked against that group's
+// rules instead of the default ones.
+type RulesConfig struct {
+	// TimeZone is the IANA name of the zone the business runs in, such as
+	// Asia/Bangkok. The server clock is usually UTC, which starts the day
+	// 7 hours after Bangkok does.
+	TimeZone string          `json:"time_zone,omitempty" yaml:"time_zone"`
+	Default  RuleSet         `json:"default" yaml:"default"`
+	Groups   map[int]RuleSet `json:"groups,omitempty" yaml:"groups"`
+}
+
+// RuleEngine evaluates the compiled rules of a RulesConfig.
+type RuleEngine struct {
+	def    []Rule
+	groups map[int][]Rule
+}
+
+func NewRuleEngine(cfg RulesConfig) (*RuleEngine, error) {
+	var loc *time.Location
+	if cfg.TimeZone != "" {
+		l, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("time_zone: %w", err)
+		}
+		loc = l
+	}
+	def, err := cfg.Default.Rules(loc)
+	if err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	en := &RuleEngine{def: def, groups: map[int][]Rule{}}
+	for id, s := range cfg.Groups {
+		rs, err := s.Rules(loc)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", id, err)
+		}
+		en.groups[id] = rs
+	}
+	return en, nil
+}
+
+// Check runs the rules that apply to e.
+func (en *RuleEngine) Check(e *Expense, v *ValidationError) {
+	rs, ok := en.groups[e.GroupId]
+	if !ok {
+		rs = en.def
+	}
+	for _, r := range rs {
+		r.Check(e, v)
+	}
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = &RuleEngine{}
+)
+
+func setRules(en *RuleEngine) {
+	rulesMu.Lock()
+	rules = en
+	rulesMu.Unlock()
+}
+
+func currentRules() *RuleEngine {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules
+}
+
+// LoadRules reads the validation rules from the YAML or JSON file at path,
+// told apart by its extension. Unknown settings are an error, a misspelt
+// rule would otherwise be off without anyone noticing.
+func LoadRules(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cfg := RulesConfig{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+	default:
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	en, err := NewRuleEngine(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	setRules(en)
+	return nil
+}
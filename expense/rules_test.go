@@ -0,0 +1,200 @@
+//go:build unit
+
+package expense
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkRule(r Rule, e Expense) ValidationError {
+	v := ValidationError{}
+	r.Check(&e, &v)
+	return v
+}
+
+func TestMaxAmountRule(t *testing.T) {
+	r := MaxAmountRule{Max: 1000}
+
+	assert.Empty(t, checkRule(r, Expense{Amount: 1000}))
+	assert.Equal(t, ValidationError{{Field: "amount", Code: CodeMax, Message: "amount error : this field should not more than 1000.00."}},
+		checkRule(r, Expense{Amount: 1000.01}))
+}
+
+func TestMaxLengthRule(t *testing.T) {
+	title := MaxLengthRule{Field: "title", Max: 5}
+	note := MaxLengthRule{Field: "note", Max: 3}
+
+	assert.Empty(t, checkRule(title, Expense{Title: "ค่ารถ"}))
+	assert.Equal(t, ValidationError{{Field: "title", Code: CodeMaxLength, Message: "title error : this field should not longer than 5 characters."}},
+		checkRule(title, Expense{Title: "taxi fare"}))
+	assert.Empty(t, checkRule(note, Expense{Title: "taxi fare", Note: "ok"}))
+	assert.Equal(t, ValidationError{{Field: "note", Code: CodeMaxLength, Message: "note error : this field should not longer than 3 characters."}},
+		checkRule(note, Expense{Note: "airport"}))
+}
+
+func TestAllowedTagsRule(t *testing.T) {
+	r := AllowedTagsRule{Tags: []string{"travel"}, Pattern: regexp.MustCompile(`^(?:proj-[0-9]+)$`)}
+
+	assert.Empty(t, checkRule(r, Expense{Tags: []string{"travel", "proj-12"}}))
+	assert.Equal(t, ValidationError{
+		{Field: "tags", Code: CodeEnum, Message: "tags error : food is not an allowed tag."},
+		{Field: "tags", Code: CodeEnum, Message: "tags error : proj-x is not an allowed tag."},
+	}, checkRule(r, Expense{Tags: []string{"food", "travel", "proj-x"}}))
+}
+
+func TestNoteRequiredRule(t *testing.T) {
+	r := NoteRequiredRule{Above: 500}
+
+	assert.Empty(t, checkRule(r, Expense{Amount: 500}))
+	assert.Empty(t, checkRule(r, Expense{Amount: 900, Note: "team dinner"}))
+	assert.Equal(t, ValidationError{{Field: "note", Code: CodeRequired, Message: "note error : this field should not empty when amount is more than 500.00."}},
+		checkRule(r, Expense{Amount: 900}))
+}
+
+func TestNoFutureDateRule(t *testing.T) {
+	r := NoFutureDateRule{}
+
+	assert.Empty(t, checkRule(r, Expense{}))
+	assert.Empty(t, checkRule(r, Expense{Date: Today()}))
+	assert.Equal(t, ValidationError{{Field: "date", Code: CodeFuture, Message: "date error : this field should not be in the future."}},
+		checkRule(r, Expense{Date: NewDate(time.Now().AddDate(0, 0, 2))}))
+
+	t.Run("should take today in the zone of the business", func(t *testing.T) {
+		ahead := time.FixedZone("UTC+13", 13*60*60)
+		behind := time.FixedZone("UTC-11", -11*60*60)
+		today := NewDate(time.Now().In(ahead))
+
+		assert.Empty(t, checkRule(NoFutureDateRule{Location: ahead}, Expense{Date: today}))
+		assert.Equal(t, CodeFuture, checkRule(NoFutureDateRule{Location: behind}, Expense{Date: today})[0].Code)
+	})
+}
+
+func TestRuleSetRules(t *testing.T) {
+	rs, err := RuleSet{MaxAmount: 10, MaxTitleLength: 20, MaxNoteLength: 30, TagPattern: "[a-z]+", NoteRequiredAbove: 5, NoFutureDate: true}.Rules(nil)
+
+	assert.Nil(t, err)
+	assert.Len(t, rs, 6)
+
+	rs, err = RuleSet{}.Rules(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, rs)
+
+	_, err = RuleSet{TagPattern: "("}.Rules(nil)
+	assert.NotNil(t, err)
+	_, err = RuleSet{MaxAmount: -1}.Rules(nil)
+	assert.EqualError(t, err, "limits should not less than 0")
+}
+
+func TestRuleEngineByGroup(t *testing.T) {
+	en, err := NewRuleEngine(RulesConfig{
+		Default: RuleSet{MaxAmount: 100},
+		Groups:  map[int]RuleSet{2: {MaxAmount: 1000}},
+	})
+	assert.Nil(t, err)
+
+	v := ValidationError{}
+	en.Check(&Expense{Amount: 500}, &v)
+	assert.Len(t, v, 1)
+
+	v = ValidationError{}
+	en.Check(&Expense{Amount: 500, GroupId: 2}, &v)
+	assert.Empty(t, v)
+}
+
+func TestExpenseValidationAppliesRules(t *testing.T) {
+	en, _ := NewRuleEngine(RulesConfig{Default: RuleSet{MaxAmount: 100, NoteRequiredAbove: 50}})
+	setRules(en)
+	defer setRules(&RuleEngine{})
+	ex := Expense{Title: "taxi", Amount: 200, Tags: []string{"travel"}}
+
+	err := ex.validation()
+
+	assert.EqualError(t, err, "amount error : this field should not more than 100.00.; note error : this field should not empty when amount is more than 50.00.")
+}
+
+func TestLoadRules(t *testing.T) {
+	defer setRules(&RuleEngine{})
+	dir := t.TempDir()
+	files := map[string]string{
+		"rules.yaml": "time_zone: Asia/Bangkok\ndefault:\n  max_amount: 100\ngroups:\n  3:\n    allowed_tags: [travel]\n",
+		"rules.json": `{"default":{"max_amount":100},"groups":{"3":{"allowed_tags":["travel"]}}}`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0644)
+
+			err := LoadRules(path)
+
+			assert.Nil(t, err)
+			v := ValidationError{}
+			currentRules().Check(&Expense{Amount: 200, Tags: []string{"food"}, GroupId: 3}, &v)
+			assert.Equal(t, ValidationError{{Field: "tags", Code: CodeEnum, Message: "tags error : food is not an allowed tag."}}, v)
+			v = ValidationError{}
+			currentRules().Check(&Expense{Amount: 200, Tags: []string{"food"}}, &v)
+			assert.Equal(t, CodeMax, v[0].Code)
+		})
+	}
+
+	for name, content := range map[string]string{
+		"bad.yaml":     "default:\n  tag_pattern: \"(\"\n",
+		"zone.yaml":    "time_zone: Asia/Nowhere\n",
+		"unknown.yaml": "default:\n  max_amout: 100\n",
+		"unknown.json": `{"default":{"max_amout":100}}`,
+	} {
+		bad := filepath.Join(dir, name)
+		os.WriteFile(bad, []byte(content), 0644)
+		assert.NotNil(t, LoadRules(bad), name)
+	}
+}
+
+func TestUpdateAppliesGroupRules(t *testing.T) {
+	//arrange
+	en, _ := NewRuleEngine(RulesConfig{Groups: map[int]RuleSet{7: {MaxAmount: 100}}})
+	setRules(en)
+	defer setRules(&RuleEngine{})
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	defer Db.Close()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM expenses WHERE id = $1 FOR UPDATE`)).
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(expenseRows).AddRow(1, "taxi", 50, "", pq.Array([]string{"travel"}), "admin", "", "", []byte("[]"), 7, StatusDraft, "THB", testDate, []byte("{}")))
+	mock.ExpectRollback()
+	req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(`{"title":"taxi","amount":200,"tags":["travel"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	SetCurrentUser(c, testAdmin)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	var r Err
+
+	//action
+	err = serve(UpdateExpensesById, c)
+	assert.Nil(t, err)
+	err = json.NewDecoder(rec.Body).Decode(&r)
+
+	//assert
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "amount error : this field should not more than 100.00.", r.Message)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,84 @@
+package expense
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/lib/pq"
+)
+
+// seedTemplate is a kind of expense SyntheticExpenses makes, with amounts
+// in THB between min and max.
+type seedTemplate struct {
+	title    string
+	tags     []string
+	min, max float64
+}
+
+var seedTemplates = []seedTemplate{
+	{"taxi to the airport", []string{"travel"}, 250, 650},
+	{"train ticket", []string{"travel"}, 40, 900},
+	{"hotel", []string{"travel", "lodging"}, 1200, 4800},
+	{"flight", []string{"travel"}, 1800, 12000},
+	{"team lunch", []string{"food", "team"}, 600, 3500},
+	{"client dinner", []string{"food", "client"}, 1500, 7000},
+	{"coffee", []string{"food"}, 60, 180},
+	{"office supplies", []string{"office"}, 120, 1500},
+	{"software subscription", []string{"software"}, 300, 2500},
+	{"conference ticket", []string{"training"}, 3000, 15000},
+	{"parking", []string{"travel"}, 40, 300},
+	{"mobile phone bill", []string{"utilities"}, 399, 1299},
+}
+
+var (
+	seedStatuses = []string{StatusDraft, StatusDraft, StatusSubmitted, StatusApproved, StatusApproved, StatusRejected, StatusReimbursed}
+	seedNotes    = []string{"", "", "receipt attached", "paid by card", "reimburse with the next payroll"}
+)
+
+// seedDays is how far back the dates of synthetic expenses go.
+const seedDays = 90
+
+// SyntheticExpenses makes n expenses of owner that look like real ones:
+// common kinds of expenses, plausible amounts, dates over the last 90 days
+// and a mix of statuses. They have no metadata, which would need field
+// definitions. On a given day, r seeded the same makes the same expenses.
+func SyntheticExpenses(r *rand.Rand, n int, owner string) []Expense {
+	today := Today()
+	exs := make([]Expense, 0, n)
+	for i := 0; i < n; i++ {
+		t := seedTemplates[r.Intn(len(seedTemplates))]
+		exs = append(exs, Expense{
+			Title:    t.title,
+			Amount:   float32(math.Round((t.min+r.Float64()*(t.max-t.min))*100) / 100),
+			Note:     seedNotes[r.Intn(len(seedNotes))],
+			Tags:     append([]string{}, t.tags...),
+			Owner:    owner,
+			Status:   seedStatuses[r.Intn(len(seedStatuses))],
+			Currency: DefaultCurrency,
+			Date:     NewDate(today.AddDate(0, 0, -r.Intn(seedDays))),
+			Metadata: Metadata{},
+		})
+	}
+	return exs
+}
+
+// Seed stores exs as they are in a transaction and sets their ids. It
+// records no changes and publishes no events: it is meant for synthetic
+// data, not for expenses users file.
+func Seed(exs []Expense) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range exs {
+		ex := &exs[i]
+		row := tx.QueryRow("INSERT INTO expenses (title, amount, note, tags, owner, payer, split, participants, group_id, status, currency, date, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, $11, $12, $13) RETURNING id",
+			ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags), ex.Owner, ex.Payer, ex.Split, ex.Participants, ex.GroupId, ex.Status, ex.Currency, ex.Date, ex.Metadata)
+		if err := row.Scan(&ex.Id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
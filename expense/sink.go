@@ -0,0 +1,84 @@
+package expense
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Sink receives the events relayed from the outbox.
+type Sink interface {
+	Publish(ctx context.Context, ev Event) error
+}
+
+// WebhookSink queues a delivery of the event for every active webhook
+// subscribed to its type. The Dispatcher sends them. An event is queued once
+// per webhook however often it is published.
+type WebhookSink struct{}
+
+func (WebhookSink) Publish(ctx context.Context, ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = Db.ExecContext(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload)
+		SELECT id, $1, $2, $3 FROM webhooks WHERE active AND $2 = ANY(events)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`, ev.Id, ev.Type, string(payload))
+	return err
+}
+
+// NDJSONSink writes every event as one line of JSON, to stdout or a file.
+type NDJSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{enc: json.NewEncoder(w)}
+}
+
+func (s *NDJSONSink) Publish(ctx context.Context, ev Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(ev)
+}
+
+// Bus hands the events to subscribers in this process. An error from any
+// subscriber makes the relay publish the event again later, to all of them.
+type Bus struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]func(Event) error
+}
+
+// Events is the bus the server relays the outbox to.
+var Events = &Bus{}
+
+// Subscribe calls fn for every published event until the returned function is called.
+func (b *Bus) Subscribe(fn func(Event) error) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = map[int]func(Event) error{}
+	}
+	id := b.next
+	b.next++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func (b *Bus) Publish(ctx context.Context, ev Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, fn := range b.subs {
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
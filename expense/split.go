@@ -0,0 +1,163 @@
+package expense
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	SplitEqual   = "equal"
+	SplitExact   = "exact"
+	SplitPercent = "percent"
+	SplitShares  = "shares"
+)
+
+// Participant is one person sharing an expense. Value is what the split mode
+// needs: the amount for exact, the percentage for percent and the number of
+// shares for shares; it is ignored for equal. Owed is computed on save.
+type Participant struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value,omitempty"`
+	Owed  float64 `json:"owed"`
+}
+
+// Participants is stored as a JSONB column.
+type Participants []Participant
+
+func (p *Participants) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	}
+	return fmt.Errorf("can't scan %T into participants", src)
+}
+
+func (p Participants) Value() (driver.Value, error) {
+	if p == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (e *Expense) validateSplit(v *ValidationError) {
+	if len(e.Participants) == 0 {
+		if e.Split != "" {
+			v.add("participants", CodeRequired, "participants error : this field should have at least 1 when split is set.")
+		}
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, p := range e.Participants {
+		if p.Name == "" {
+			v.add("participants", CodeRequired, "participants error : name should not empty.")
+			continue
+		}
+		if seen[p.Name] {
+			v.add("participants", CodeDuplicate, fmt.Sprintf("participants error : %s is listed more than once.", p.Name))
+		}
+		seen[p.Name] = true
+		if p.Value < 0 {
+			v.add("participants", CodeMin, fmt.Sprintf("participants error : value of %s should not less than 0.", p.Name))
+		}
+	}
+
+	sum := 0.0
+	for _, p := range e.Participants {
+		sum += p.Value
+	}
+	switch e.Split {
+	case "", SplitEqual:
+	case SplitExact:
+		if toCents(sum) != toCents(float64(e.Amount)) {
+			v.add("participants", CodeTotal, fmt.Sprintf("participants error : exact values should add up to the amount %.2f.", e.Amount))
+		}
+	case SplitPercent:
+		if math.Abs(sum-100) > 0.001 {
+			v.add("participants", CodeTotal, "participants error : percent values should add up to 100.")
+		}
+	case SplitShares:
+		if sum == 0 {
+			v.add("participants", CodeTotal, "participants error : shares should add up to more than 0.")
+		}
+	default:
+		v.add("split", CodeEnum, "split error : this field should be one of equal, exact, percent or shares.")
+	}
+}
+
+// computeSplit fills in what every participant owes. It expects a validated
+// expense; amounts are split in cents and the cents left over by rounding go
+// to the participants with the largest remainders.
+func (e *Expense) computeSplit() {
+	if len(e.Participants) == 0 {
+		return
+	}
+	if e.Split == "" {
+		e.Split = SplitEqual
+	}
+
+	if e.Split == SplitExact {
+		for i := range e.Participants {
+			e.Participants[i].Owed = e.Participants[i].Value
+		}
+		return
+	}
+
+	weights := make([]float64, len(e.Participants))
+	for i, p := range e.Participants {
+		weights[i] = p.Value
+		if e.Split == SplitEqual {
+			weights[i] = 1
+		}
+	}
+	for i, c := range allocate(toCents(float64(e.Amount)), weights) {
+		e.Participants[i].Owed = fromCents(c)
+	}
+}
+
+// allocate splits total cents by weights with the largest remainder method,
+// so the parts always add up to total.
+func allocate(total int64, weights []float64) []int64 {
+	sum := 0.0
+	for _, w := range weights {
+		sum += w
+	}
+
+	parts := make([]int64, len(weights))
+	rems := make([]float64, len(weights))
+	left := total
+	for i, w := range weights {
+		exact := float64(total) * w / sum
+		parts[i] = int64(math.Floor(exact))
+		rems[i] = exact - float64(parts[i])
+		left -= parts[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return rems[order[a]] > rems[order[b]] })
+	for i := 0; left > 0; i++ {
+		parts[order[i%len(order)]]++
+		left--
+	}
+	return parts
+}
+
+func toCents(v float64) int64 {
+	return int64(math.Round(v * 100))
+}
+
+func fromCents(c int64) float64 {
+	return float64(c) / 100
+}
@@ -0,0 +1,158 @@
+package expense
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var statementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"join":       strings.Join,
+	"money":      money,
+	"currencies": currencies,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Expense report #{{.Id}}: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td.amount, th.amount { text-align: right; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Expense report #{{.Id}}: {{.Title}}</h1>
+<p>Owner: {{.Owner}}<br>Period: {{.PeriodStart}} to {{.PeriodEnd}}<br>Status: {{.Status}}</p>
+<table>
+<thead><tr><th>Date</th><th>Title</th><th>Tags</th><th>Note</th><th>Receipts</th><th class="amount">Amount</th></tr></thead>
+<tbody>
+{{- range .Expenses}}
+<tr><td>{{.Date}}</td><td>{{.Title}}</td><td>{{join .Tags ", "}}</td><td>{{.Note}}</td><td>{{range index $.Receipts .Id}}{{.Filename}}<br>{{end}}</td><td class="amount">{{money .Amount}} {{.Currency}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<h2>Totals</h2>
+<table>
+{{- range currencies .Totals}}
+<tr><td>{{.}}</td><td class="amount">{{money (index $.Totals .)}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+func money(v interface{}) string {
+	switch a := v.(type) {
+	case float32:
+		return fmt.Sprintf("%.2f", a)
+	case float64:
+		return fmt.Sprintf("%.2f", a)
+	}
+	return fmt.Sprint(v)
+}
+
+// currencies lists the currencies of totals in order.
+func currencies(totals map[string]float64) []string {
+	cs := make([]string, 0, len(totals))
+	for c := range totals {
+		cs = append(cs, c)
+	}
+	sort.Strings(cs)
+	return cs
+}
+
+// statement is what a statement shows: the report and the receipts of its
+// expenses.
+type statement struct {
+	Report
+	Receipts map[int][]Receipt
+}
+
+// statementLines lays the statement out as fixed width text for the PDF.
+func statementLines(r statement) []string {
+	row := func(date, title, tags, amount string) string {
+		return fmt.Sprintf("%s  %s  %s  %16s", column(date, 10), column(title, 30), column(tags, 22), amount)
+	}
+	lines := []string{
+		fmt.Sprintf("EXPENSE REPORT #%d: %s", r.Id, r.Title),
+		"",
+		"Owner:  " + r.Owner,
+		fmt.Sprintf("Period: %s to %s", r.PeriodStart, r.PeriodEnd),
+		"Status: " + r.Status,
+		"",
+		row("Date", "Title", "Tags", "Amount"),
+		strings.Repeat("-", 84),
+	}
+	for _, ex := range r.Expenses {
+		lines = append(lines, row(ex.Date.String(), ex.Title, strings.Join(ex.Tags, ", "), money(ex.Amount)+" "+ex.Currency))
+		if ex.Note != "" {
+			lines = append(lines, "            "+ex.Note)
+		}
+		for _, rc := range r.Receipts[ex.Id] {
+			lines = append(lines, "            receipt: "+rc.Filename)
+		}
+	}
+	lines = append(lines, strings.Repeat("-", 84), "Totals")
+	for _, c := range currencies(r.Totals) {
+		lines = append(lines, fmt.Sprintf("%-66s  %16s", "", money(r.Totals[c])+" "+c))
+	}
+	return lines
+}
+
+// column cuts or pads s to n columns. The characters combined over another,
+// Thai vowels and tone marks for some, take no column of their own.
+func column(s string, n int) string {
+	b := &strings.Builder{}
+	for _, r := range s {
+		if !combining(r) {
+			if n == 0 {
+				break
+			}
+			n--
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + strings.Repeat(" ", n)
+}
+
+// GetReportStatement renders the report as a printable statement, HTML by
+// default or PDF with ?format=pdf.
+func GetReportStatement(c echo.Context) error {
+	report, err := viewReport(c)
+	if err != nil {
+		return err
+	}
+	receipts, err := loadReceipts(Db, report.ExpenseIds)
+	if err != nil {
+		return internalErr("unable to query receipts", err)
+	}
+	r := statement{Report: report, Receipts: receipts}
+
+	buf := &bytes.Buffer{}
+	switch c.QueryParam("format") {
+	case "", "html":
+		if err := statementTemplate.Execute(buf, r); err != nil {
+			return internalErr("unable to render statement", err)
+		}
+		return c.HTMLBlob(http.StatusOK, buf.Bytes())
+	case "pdf":
+		err := writePDF(buf, statementLines(r))
+		if pe, ok := err.(*PDFError); ok {
+			return &Err{Status: http.StatusUnprocessableEntity, Message: "unable to render the PDF statement: " + pe.Reason + ", use format=html"}
+		}
+		if err != nil {
+			return internalErr("unable to render statement", err)
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`inline; filename="report-%d.pdf"`, r.Id))
+		return c.Blob(http.StatusOK, "application/pdf", buf.Bytes())
+	}
+	return &Err{Status: http.StatusBadRequest, Message: "format error : this field should be html or pdf."}
+}
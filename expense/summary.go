@@ -0,0 +1,118 @@
+package expense
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Summary aggregates a set of expenses. Amounts are totalled per currency,
+// and an expense with several tags counts towards each of them.
+type Summary struct {
+	Count   int                `json:"count"`
+	Totals  map[string]float64 `json:"totals"`
+	ByTag   []SummaryLine      `json:"by_tag"`
+	ByOwner []SummaryLine      `json:"by_owner"`
+}
+
+type SummaryLine struct {
+	Key    string             `json:"key"`
+	Count  int                `json:"count"`
+	Totals map[string]float64 `json:"totals"`
+}
+
+// summarize aggregates the expenses matching where.
+func summarize(where string, args ...interface{}) (Summary, error) {
+	s := Summary{Totals: map[string]float64{}, ByTag: []SummaryLine{}, ByOwner: []SummaryLine{}}
+	rows, err := Db.Query(`SELECT currency, COUNT(*), SUM(amount) FROM expenses WHERE `+where+` GROUP BY currency`, args...)
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var cur string
+		var count int
+		var total float64
+		if err := rows.Scan(&cur, &count, &total); err != nil {
+			rows.Close()
+			return s, err
+		}
+		s.Count += count
+		s.Totals[cur] = total
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	for _, by := range []struct {
+		lines *[]SummaryLine
+		query string
+	}{
+		{&s.ByTag, `SELECT tag, currency, COUNT(*), SUM(amount) FROM expenses, UNNEST(tags) AS tag WHERE ` + where + ` GROUP BY tag, currency ORDER BY tag, currency`},
+		{&s.ByOwner, `SELECT owner, currency, COUNT(*), SUM(amount) FROM expenses WHERE ` + where + ` GROUP BY owner, currency ORDER BY owner, currency`},
+	} {
+		rows, err := Db.Query(by.query, args...)
+		if err != nil {
+			return s, err
+		}
+		for rows.Next() {
+			var key, cur string
+			var count int
+			var total float64
+			if err := rows.Scan(&key, &cur, &count, &total); err != nil {
+				rows.Close()
+				return s, err
+			}
+			// rows of a key come together, one per currency
+			ls := *by.lines
+			if len(ls) == 0 || ls[len(ls)-1].Key != key {
+				ls = append(ls, SummaryLine{Key: key, Totals: map[string]float64{}})
+			}
+			ls[len(ls)-1].Count += count
+			ls[len(ls)-1].Totals[cur] = total
+			*by.lines = ls
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// GetSummary aggregates the expenses the current user can see, narrowed by
+// the same filters as GetExpenses.
+func GetSummary(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	f, err := expenseFilterParams(c)
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	where, args, err := f.where(u)
+	if err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	s, err := summarize(where, args...)
+	if err != nil {
+		return internalErr("unable to summarize expenses", err)
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+// GetGroupReport aggregates the expenses filed under a group.
+func GetGroupReport(c echo.Context) error {
+	id, err := groupParam(c, CurrentUser(c))
+	if err != nil {
+		return err
+	}
+
+	s, err := summarize(`group_id = $1`, id)
+	if err != nil {
+		return internalErr("unable to summarize expenses", err)
+	}
+	return c.JSON(http.StatusOK, s)
+}
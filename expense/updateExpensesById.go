@@ -9,34 +9,101 @@ import (
 )
 
 func UpdateExpensesById(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionUpdate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
 	id := c.Param("id")
-	b := Expense{}
+	b := expenseUpdate{}
 	err := c.Bind(&b)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
 	}
 
-	err = b.validation()
+	ex, err := editExpense(c, id, b.Expense, b.GroupId)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return err
 	}
+	return c.JSON(http.StatusOK, ex)
+}
 
-	stmt, err := Db.Prepare(`UPDATE expenses SET title = $1, amount = $2, note = $3, tags = $4 WHERE id = $5 RETURNING *`)
+// expenseUpdate is the body of an update. The expense stays in its group when
+// group_id is left out, 0 takes it out of its group.
+type expenseUpdate struct {
+	Expense
+	GroupId *int `json:"group_id"`
+}
+
+// editExpense validates b and writes it over the expense id, which has to be
+// editable by the current user. The expense is moved to group groupId, 0 for
+// none, or kept in its group when groupId is nil; the group of b is ignored.
+// b is validated once its group is known, under the rules of that group.
+// The REST, GraphQL and gRPC APIs all update expenses through it.
+func editExpense(c echo.Context, id string, b Expense, groupId *int) (Expense, error) {
+	u := CurrentUser(c)
+	tx, err := Db.Begin()
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: "unable to setup query statement" + err.Error()})
+		return b, internalErr("unable to begin transaction", err)
 	}
+	defer tx.Rollback()
 
-	ex := Expense{}
-
-	update := stmt.QueryRow(b.Title, b.Amount, b.Note, pq.Array(b.Tags), id)
-	err = update.Scan(&ex.Id, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags))
+	cur := Expense{}
+	err = scanExpense(tx.QueryRow(`SELECT `+expenseColumns+` FROM expenses WHERE id = $1 FOR UPDATE`, id), &cur)
 	switch err {
 	case sql.ErrNoRows:
-		return c.JSON(http.StatusNotFound, Err{Message: "updated expense's not found"})
+		return b, &Err{Status: http.StatusNotFound, Message: "updated expense's not found"}
 	case nil:
-		return c.JSON(http.StatusOK, ex)
 	default:
-		return c.JSON(http.StatusInternalServerError, Err{Message: "can't scan updated expense:" + err.Error()})
+		return b, internalErr("can't scan expense", err)
+	}
+	if err := Authorize(u, ActionUpdate, &cur); err != nil {
+		return b, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	b.Id, b.Owner, b.GroupId = cur.Id, cur.Owner, cur.GroupId
+	if groupId != nil {
+		b.GroupId = *groupId
+	}
+	if err := Authorize(u, ActionUpdate, &b); err != nil {
+		return b, &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+	if cur.locked() {
+		return b, &Err{Status: http.StatusConflict, Message: "expense is " + cur.Status + " and can't be edited"}
 	}
+	if err := b.validation(); err != nil {
+		return b, invalid(err)
+	}
+	if b.Payer == "" && len(b.Participants) > 0 {
+		b.Payer = cur.Owner
+	}
+	if b.Currency == "" {
+		b.Currency = cur.Currency
+	}
+	if b.Date.IsZero() {
+		b.Date = cur.Date
+	}
+	b.computeSplit()
 
+	ex, err := updateExpense(tx, cur.Id, b)
+	if err != nil {
+		return b, internalErr("can't scan updated expense", err)
+	}
+	if err = recordChange(tx, c, "update", &cur, &ex); err != nil {
+		return b, internalErr("unable to record change", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return b, internalErr("unable to commit transaction", err)
+	}
+	return ex, nil
+}
+
+// updateExpense writes the editable fields of b to the expense id. The status
+// only changes through the approval workflow.
+func updateExpense(tx *sql.Tx, id int, b Expense) (Expense, error) {
+	ex := Expense{}
+	update := tx.QueryRow(`UPDATE expenses SET title = $1, amount = $2, note = $3, tags = $4, payer = $5, split = $6, participants = $7, group_id = NULLIF($8, 0),
+		currency = COALESCE(NULLIF($9, ''), currency), date = COALESCE($10, date), metadata = $11 WHERE id = $12 RETURNING `+expenseColumns,
+		b.Title, b.Amount, b.Note, pq.Array(b.Tags), b.Payer, b.Split, b.Participants, b.GroupId, b.Currency, b.Date, b.Metadata, id)
+	err := scanExpense(update, &ex)
+	return ex, err
 }
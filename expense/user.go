@@ -0,0 +1,312 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type User struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password,omitempty"`
+	Role       Role     `json:"role"`
+	Groups     []string `json:"groups"`
+	GroupRoles []string `json:"-"`
+	GroupIds   []int64  `json:"-"`
+}
+
+type Group struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+func (u User) memberOf(id int) bool {
+	for _, g := range u.GroupIds {
+		if g == int64(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveRole is the highest of the user's own role and the roles granted
+// by the groups the user belongs to.
+func (u User) EffectiveRole() Role {
+	r := u.Role
+	for _, gr := range u.GroupRoles {
+		if roleLevel[Role(gr)] > roleLevel[r] {
+			r = Role(gr)
+		}
+	}
+	return r
+}
+
+const selectUser = `SELECT u.username, u.password, u.role,
+	COALESCE(ARRAY_AGG(g.name) FILTER (WHERE g.name IS NOT NULL), '{}'),
+	COALESCE(ARRAY_AGG(g.role) FILTER (WHERE g.role <> ''), '{}'),
+	COALESCE(ARRAY_AGG(g.id) FILTER (WHERE g.id IS NOT NULL), '{}')
+	FROM users u
+	LEFT JOIN group_members m ON m.username = u.username
+	LEFT JOIN groups g ON g.id = m.group_id`
+
+func scanUser(row scanner, u *User) error {
+	return row.Scan(&u.Username, &u.Password, &u.Role, pq.Array(&u.Groups), pq.Array(&u.GroupRoles), pq.Array(&u.GroupIds))
+}
+
+// Authenticate returns the user with the given credentials, or nil when the
+// username is unknown or the password does not match.
+func Authenticate(username, password string) (*User, error) {
+	u := User{}
+	row := Db.QueryRow(selectUser+` WHERE u.username = $1 GROUP BY u.username`, username)
+	err := scanUser(row, &u)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		return nil, nil
+	}
+	u.Password = ""
+	return &u, nil
+}
+
+func hashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+func (u *User) validation(create bool) error {
+	if u.Username == "" {
+		return fmt.Errorf("username error : this field should not empty.")
+	}
+	if create && u.Password == "" {
+		return fmt.Errorf("password error : this field should not empty.")
+	}
+	if !u.Role.valid() {
+		return fmt.Errorf("role error : this field should be one of viewer, editor, approver or admin.")
+	}
+	return nil
+}
+
+func GetUsers(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageUsers, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(selectUser + ` GROUP BY u.username ORDER BY u.username`)
+	if err != nil {
+		return internalErr("unable to query users", err)
+	}
+	defer rows.Close()
+
+	us := []User{}
+	for rows.Next() {
+		u := User{}
+		if err := scanUser(rows, &u); err != nil {
+			return internalErr("unable to scan user", err)
+		}
+		u.Password = ""
+		us = append(us, u)
+	}
+	return c.JSON(http.StatusOK, us)
+}
+
+func CreateUser(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageUsers, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	u := User{}
+	if err := c.Bind(&u); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := u.validation(true); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := saveUser(u, true); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return &Err{Status: http.StatusConflict, Message: "user " + u.Username + " already exists"}
+		}
+		return internalErr("unable to create user", err)
+	}
+	u.Password = ""
+	return c.JSON(http.StatusCreated, u)
+}
+
+func UpdateUser(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageUsers, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	u := User{}
+	if err := c.Bind(&u); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	u.Username = c.Param("username")
+	if err := u.validation(false); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	switch err := saveUser(u, false); err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "user's not found"}
+	case nil:
+		u.Password = ""
+		return c.JSON(http.StatusOK, u)
+	default:
+		return internalErr("unable to update user", err)
+	}
+}
+
+// AddUser creates u outside of a request, as the server's user add command
+// does.
+func AddUser(u User) error {
+	if err := u.validation(true); err != nil {
+		return err
+	}
+	err := saveUser(u, true)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return fmt.Errorf("user %s already exists", u.Username)
+	}
+	return err
+}
+
+// SetPassword replaces the password of the user username.
+func SetPassword(username, password string) error {
+	if password == "" {
+		return fmt.Errorf("password error : this field should not empty.")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := Db.Exec(`UPDATE users SET password = $2 WHERE username = $1`, username, hash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("user %s is not found", username)
+	}
+	return nil
+}
+
+// saveUser inserts or updates u and replaces its group memberships. An empty
+// password on update keeps the current one.
+func saveUser(u User, create bool) error {
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hash := ""
+	if u.Password != "" {
+		if hash, err = hashPassword(u.Password); err != nil {
+			return err
+		}
+	}
+
+	if create {
+		_, err = tx.Exec(`INSERT INTO users (username, password, role) VALUES ($1, $2, $3)`, u.Username, hash, u.Role)
+	} else {
+		var res sql.Result
+		res, err = tx.Exec(`UPDATE users SET role = $2, password = COALESCE(NULLIF($3, ''), password) WHERE username = $1`, u.Username, u.Role, hash)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				return sql.ErrNoRows
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM group_members WHERE username = $1`, u.Username); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`INSERT INTO group_members (group_id, username) SELECT id, $1 FROM groups WHERE name = ANY($2)`, u.Username, pq.Array(u.Groups)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetGroups lists every group to admins and their own groups to other users.
+func GetGroups(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionView, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(`SELECT id, name, role FROM groups WHERE $1 OR id = ANY($2) ORDER BY name`, u.EffectiveRole() == RoleAdmin, pq.Array(u.GroupIds))
+	if err != nil {
+		return internalErr("unable to query groups", err)
+	}
+	defer rows.Close()
+
+	gs := []Group{}
+	for rows.Next() {
+		g := Group{}
+		if err := rows.Scan(&g.Id, &g.Name, &g.Role); err != nil {
+			return internalErr("unable to scan group", err)
+		}
+		gs = append(gs, g)
+	}
+	return c.JSON(http.StatusOK, gs)
+}
+
+// CreateGroup creates a group. Editors may create groups without a role,
+// such as a household, and become their first member. Groups granting a role
+// are created by admins.
+func CreateGroup(c echo.Context) error {
+	u := CurrentUser(c)
+	if err := Authorize(u, ActionCreate, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	g := Group{}
+	if err := c.Bind(&g); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if g.Role != "" {
+		if err := Authorize(u, ActionManageUsers, nil); err != nil {
+			return &Err{Status: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+	if g.Name == "" {
+		return &Err{Status: http.StatusBadRequest, Message: "name error : this field should not empty."}
+	}
+	if g.Role != "" && !g.Role.valid() {
+		return &Err{Status: http.StatusBadRequest, Message: "role error : this field should be one of viewer, editor, approver or admin."}
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return internalErr("unable to begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO groups (name, role) VALUES ($1, $2) RETURNING id`, g.Name, g.Role).Scan(&g.Id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return &Err{Status: http.StatusConflict, Message: "group " + g.Name + " already exists"}
+		}
+		return internalErr("unable to create group", err)
+	}
+	if g.Role == "" {
+		if _, err = tx.Exec(`INSERT INTO group_members (group_id, username) VALUES ($1, $2)`, g.Id, u.Username); err != nil {
+			return internalErr("unable to add member", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return internalErr("unable to commit transaction", err)
+	}
+	return c.JSON(http.StatusCreated, g)
+}
@@ -0,0 +1,63 @@
+package expense
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Codes of the violations found by validation. Clients match on these rather
+// than on the messages, which may change.
+const (
+	CodeRequired  = "required"
+	CodeMin       = "min"
+	CodeFormat    = "format"
+	CodeType      = "type"
+	CodeEnum      = "enum"
+	CodeUnknown   = "unknown"
+	CodeDuplicate = "duplicate"
+	CodeTotal     = "total"
+	CodeMax       = "max"
+	CodeMaxLength = "max_length"
+	CodeFuture    = "future"
+)
+
+// FieldError is one violation of a field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a value. Its message joins
+// theirs, so a single violation reads as it always has.
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	ms := make([]string, len(v))
+	for i, e := range v {
+		ms[i] = e.Message
+	}
+	return strings.Join(ms, "; ")
+}
+
+func (v *ValidationError) add(field, code, message string) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: message})
+}
+
+// err returns v as an error, or nil when nothing was found.
+func (v ValidationError) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// invalid is the error of a failed validation. A ValidationError also lists
+// its violations.
+func invalid(err error) *Err {
+	e := &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	if v, ok := err.(ValidationError); ok {
+		e.Errors = v
+	}
+	return e
+}
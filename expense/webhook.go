@@ -0,0 +1,220 @@
+package expense
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	EventExpenseCreated = "expense.created"
+	EventExpenseUpdated = "expense.updated"
+	EventExpenseDeleted = "expense.deleted"
+)
+
+var webhookEvents = map[string]bool{
+	EventExpenseCreated: true,
+	EventExpenseUpdated: true,
+	EventExpenseDeleted: true,
+}
+
+type Webhook struct {
+	Id        int       `json:"id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type WebhookDelivery struct {
+	Id            int64           `json:"id"`
+	WebhookId     int             `json:"webhook_id"`
+	EventId       int64           `json:"event_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastError     string          `json:"last_error"`
+	ResponseCode  int             `json:"response_code"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// SignWebhook returns the X-Webhook-Signature header of a delivery: "sha256="
+// followed by the hex HMAC-SHA256, keyed with secret, of the X-Webhook-Timestamp
+// value, a dot and the body.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) validation() error {
+	u, err := url.Parse(w.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url error : this field should be an absolute http or https URL.")
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("events error : this field should have at least 1.")
+	}
+	for _, e := range w.Events {
+		if !webhookEvents[e] {
+			return fmt.Errorf("events error : %q is not one of expense.created, expense.updated or expense.deleted.", e)
+		}
+	}
+	if w.Secret == "" {
+		return fmt.Errorf("secret error : this field should not empty.")
+	}
+	return nil
+}
+
+const selectWebhook = `SELECT id, url, events, active, created_at FROM webhooks`
+
+func scanWebhook(row scanner, w *Webhook) error {
+	return row.Scan(&w.Id, &w.Url, pq.Array(&w.Events), &w.Active, &w.CreatedAt)
+}
+
+func CreateWebhook(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	w := Webhook{}
+	if err := c.Bind(&w); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err := w.validation(); err != nil {
+		return &Err{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	w.Active = true
+
+	row := Db.QueryRow(`INSERT INTO webhooks (url, events, secret) VALUES ($1, $2, $3) RETURNING id, created_at`, w.Url, pq.Array(w.Events), w.Secret)
+	if err := row.Scan(&w.Id, &w.CreatedAt); err != nil {
+		return internalErr("unable to create webhook", err)
+	}
+	w.Secret = ""
+	return c.JSON(http.StatusCreated, w)
+}
+
+func GetWebhooks(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	rows, err := Db.Query(selectWebhook + ` ORDER BY id`)
+	if err != nil {
+		return internalErr("unable to query webhooks", err)
+	}
+	defer rows.Close()
+
+	ws := []Webhook{}
+	for rows.Next() {
+		w := Webhook{}
+		if err := scanWebhook(rows, &w); err != nil {
+			return internalErr("unable to scan webhook", err)
+		}
+		ws = append(ws, w)
+	}
+	return c.JSON(http.StatusOK, ws)
+}
+
+func GetWebhookById(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	w := Webhook{}
+	switch err := scanWebhook(Db.QueryRow(selectWebhook+` WHERE id = $1`, c.Param("id")), &w); err {
+	case sql.ErrNoRows:
+		return &Err{Status: http.StatusNotFound, Message: "webhook's not found"}
+	case nil:
+		return c.JSON(http.StatusOK, w)
+	default:
+		return internalErr("can't scan webhook", err)
+	}
+}
+
+func DeleteWebhook(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	res, err := Db.Exec(`DELETE FROM webhooks WHERE id = $1`, c.Param("id"))
+	if err != nil {
+		return internalErr("unable to delete webhook", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "webhook's not found"}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries is the delivery log of a webhook, newest first. It can
+// be filtered by ?status=pending|delivered|dead.
+func GetWebhookDeliveries(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	q := `SELECT id, webhook_id, COALESCE(event_id, 0), event, payload, status, attempts, next_attempt_at, last_error, response_code, created_at, updated_at
+		FROM webhook_deliveries WHERE webhook_id = $1`
+	args := []interface{}{c.Param("id")}
+	if s := c.QueryParam("status"); s != "" {
+		q += ` AND status = $2`
+		args = append(args, s)
+	}
+	rows, err := Db.Query(q+` ORDER BY id DESC LIMIT 100`, args...)
+	if err != nil {
+		return internalErr("unable to query deliveries", err)
+	}
+	defer rows.Close()
+
+	ds := []WebhookDelivery{}
+	for rows.Next() {
+		d := WebhookDelivery{}
+		var payload []byte
+		err := rows.Scan(&d.Id, &d.WebhookId, &d.EventId, &d.Event, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.ResponseCode, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return internalErr("unable to scan delivery", err)
+		}
+		d.Payload = payload
+		ds = append(ds, d)
+	}
+	return c.JSON(http.StatusOK, ds)
+}
+
+// RetryWebhookDelivery puts a dead delivery back in the queue.
+func RetryWebhookDelivery(c echo.Context) error {
+	if err := Authorize(CurrentUser(c), ActionManageWebhooks, nil); err != nil {
+		return &Err{Status: http.StatusForbidden, Message: err.Error()}
+	}
+
+	res, err := Db.Exec(`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()
+		WHERE id = $1 AND webhook_id = $2 AND status = 'dead'`, c.Param("delivery"), c.Param("id"))
+	if err != nil {
+		return internalErr("unable to retry delivery", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return &Err{Status: http.StatusNotFound, Message: "dead delivery's not found"}
+	}
+	return c.NoContent(http.StatusAccepted)
+}
@@ -0,0 +1,156 @@
+package expense
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Dispatcher sends pending webhook deliveries. A failed delivery is retried
+// with exponential backoff and marked dead after MaxAttempts.
+type Dispatcher struct {
+	Client      *http.Client
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration // delay before the first retry, doubled for every next one
+	MaxBackoff  time.Duration
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Interval:    time.Second,
+		BatchSize:   20,
+		MaxAttempts: 8,
+		Backoff:     10 * time.Second,
+		MaxBackoff:  time.Hour,
+	}
+}
+
+// Run dispatches until ctx is done. Several replicas can run it together,
+// a delivery is only claimed by one of them at a time.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.Interval)
+	defer t.Stop()
+	for {
+		if err := d.dispatch(ctx); err != nil {
+			slog.Error("webhook dispatch error", ErrorAttrs(err)...)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+type claimedDelivery struct {
+	id       int64
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context) error {
+	// claimed deliveries are leased until the client gave up on them, so that
+	// the ones of a crashed replica are picked up again
+	lease := d.Client.Timeout + time.Minute
+	rows, err := Db.QueryContext(ctx, `WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + $2 * INTERVAL '1 second'
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.event, d.payload, d.attempts, w.url, w.secret`, d.BatchSize, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	claimed := []claimedDelivery{}
+	for rows.Next() {
+		cd := claimedDelivery{}
+		if err := rows.Scan(&cd.id, &cd.event, &cd.payload, &cd.attempts, &cd.url, &cd.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		claimed = append(claimed, cd)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, cd := range claimed {
+		code, err := d.deliver(ctx, cd)
+		if err := d.finish(cd, code, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver posts the payload of cd to its webhook and returns the response
+// status. Any status but 2xx is an error.
+func (d *Dispatcher) deliver(ctx context.Context, cd claimedDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cd.url, bytes.NewReader(cd.payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "expenses-webhook/1")
+	req.Header.Set("X-Webhook-Event", cd.event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(cd.id, 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhook(cd.secret, ts, cd.payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (d *Dispatcher) finish(cd claimedDelivery, code int, derr error) error {
+	attempts := cd.attempts + 1
+	if derr == nil {
+		_, err := Db.Exec(`UPDATE webhook_deliveries SET status = 'delivered', attempts = $2, response_code = $3, last_error = '', updated_at = now()
+			WHERE id = $1`, cd.id, attempts, code)
+		return err
+	}
+
+	status := DeliveryPending
+	if attempts >= d.MaxAttempts {
+		status = DeliveryDead
+	}
+	_, err := Db.Exec(`UPDATE webhook_deliveries SET status = $2, attempts = $3, response_code = $4, last_error = $5,
+			next_attempt_at = now() + $6 * INTERVAL '1 second', updated_at = now()
+		WHERE id = $1`, cd.id, status, attempts, code, derr.Error(), d.backoff(attempts).Seconds())
+	return err
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.Backoff
+	for i := 1; i < attempts && b < d.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > d.MaxBackoff {
+		b = d.MaxBackoff
+	}
+	return b
+}
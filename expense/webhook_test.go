@@ -0,0 +1,114 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayload = `{"event":"expense.created","occurred_at":"2022-12-24T10:00:00Z","expense":{"id":1}}`
+
+// receiver is a webhook endpoint answering status and keeping the last request.
+func receiver(t *testing.T, status int) (*httptest.Server, *http.Request, *[]byte) {
+	last := &http.Request{}
+	body := &[]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*last = *r
+		*body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, last, body
+}
+
+func mockClaim(t *testing.T, url string, attempts int) sqlmock.Sqlmock {
+	var mock sqlmock.Sqlmock
+	var err error
+	Db, mock, err = sqlmock.New()
+	if err != nil {
+		t.Fatal("unable to create mock db", err)
+	}
+	t.Cleanup(func() { Db.Close() })
+
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE webhook_deliveries d SET next_attempt_at`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event", "payload", "attempts", "url", "secret"}).
+			AddRow(7, "expense.created", []byte(testPayload), attempts, url, "s3cret"))
+	return mock
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	srv, req, body := receiver(t, http.StatusNoContent)
+	mock := mockClaim(t, srv.URL, 0)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET status = 'delivered'`)).
+		WithArgs(int64(7), 1, http.StatusNoContent).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := NewDispatcher().dispatch(context.Background())
+
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, testPayload, string(*body))
+	assert.Equal(t, "expense.created", req.Header.Get("X-Webhook-Event"))
+	assert.Equal(t, "7", req.Header.Get("X-Webhook-Delivery"))
+	ts, _ := strconv.ParseInt(req.Header.Get("X-Webhook-Timestamp"), 10, 64)
+	assert.Equal(t, SignWebhook("s3cret", ts, []byte(testPayload)), req.Header.Get("X-Webhook-Signature"))
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	t.Run("should schedule a retry with backoff when the receiver fails", func(t *testing.T) {
+		srv, _, _ := receiver(t, http.StatusInternalServerError)
+		mock := mockClaim(t, srv.URL, 2)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET status = $2`)).
+			WithArgs(int64(7), DeliveryPending, 3, http.StatusInternalServerError, "webhook responded 500 Internal Server Error", float64(40)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := NewDispatcher().dispatch(context.Background())
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("should dead-letter after the last attempt", func(t *testing.T) {
+		srv, _, _ := receiver(t, http.StatusBadGateway)
+		mock := mockClaim(t, srv.URL, 7)
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries SET status = $2`)).
+			WithArgs(int64(7), DeliveryDead, 8, http.StatusBadGateway, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := NewDispatcher().dispatch(context.Background())
+
+		assert.Nil(t, err)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, d.backoff(1))
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 8*time.Second, d.backoff(4))
+	assert.Equal(t, 10*time.Second, d.backoff(5))
+}
+
+func TestWebhookValidation(t *testing.T) {
+	w := Webhook{Url: "https://ledger.internal/hooks", Events: []string{"expense.created"}, Secret: "s"}
+	assert.Nil(t, w.validation())
+
+	w.Url = "ledger.internal/hooks"
+	assert.EqualError(t, w.validation(), "url error : this field should be an absolute http or https URL.")
+
+	w.Url = "https://ledger.internal/hooks"
+	w.Events = []string{"expense.archived"}
+	assert.EqualError(t, w.validation(), `events error : "expense.archived" is not one of expense.created, expense.updated or expense.deleted.`)
+}
@@ -0,0 +1,5 @@
+// Package expensepb holds the protobuf messages and gRPC stubs of the
+// ExpenseService defined in expense.proto.
+package expensepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative expense.proto
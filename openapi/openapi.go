@@ -0,0 +1,137 @@
+// Package openapi holds the OpenAPI 3 document of the API, serves it and
+// checks values against its schemas.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Document is the part of an OpenAPI document requests are validated
+// against. Anything else in the file is only there for readers.
+type Document struct {
+	OpenAPI string `json:"openapi"`
+	Servers []struct {
+		Url string `json:"url"`
+	} `json:"servers,omitempty"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type Operation struct {
+	OperationId string       `json:"operationId"`
+	Parameters  []Parameter  `json:"parameters,omitempty"`
+	RequestBody *RequestBody `json:"requestBody,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Style    string  `json:"style,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema requests are checked against. An empty
+// schema accepts anything, and so does null, which the handlers read as the
+// zero value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Spec parses the embedded document.
+func Spec() (*Document, error) {
+	doc := &Document{}
+	if err := json.Unmarshal(spec, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Operation finds the operation of method on an echo route path such as
+// /v1/expenses/:id. The path may or may not start with the path of a server.
+func (d *Document) Operation(method, path string) (*Operation, bool) {
+	path = TemplatePath(path)
+	for _, s := range d.Servers {
+		if strings.HasPrefix(path, s.Url+"/") {
+			path = strings.TrimPrefix(path, s.Url)
+			break
+		}
+	}
+	op, ok := d.Paths[path][strings.ToLower(method)]
+	return op, ok
+}
+
+// resolve follows a $ref to the schema it names.
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// TemplatePath turns the :name parameters of an echo route into the {name}
+// of an OpenAPI path.
+func TemplatePath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		if strings.HasPrefix(s, ":") {
+			segs[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// ServeSpec answers with the document as written.
+func ServeSpec(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, spec)
+}
+
+// docsPage is Swagger UI pointed at /openapi.json. The assets come from a
+// CDN so that the binary doesn't carry them.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Expense tracking API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`
+
+// ServeDocs answers with a page to browse and try the API.
+func ServeDocs(c echo.Context) error {
+	return c.HTML(http.StatusOK, docsPage)
+}
@@ -0,0 +1,196 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+)
+
+// ValidateParams checks the path and query parameters of a request to op.
+// Only the shape of values is checked, what they mean is up to the handler.
+func (d *Document) ValidateParams(op *Operation, path func(string) string, query url.Values) expense.ValidationError {
+	v := expense.ValidationError{}
+	for _, p := range op.Parameters {
+		var s string
+		switch {
+		case p.In == "path":
+			s = path(p.Name)
+		case p.In == "query" && p.Style != "deepObject":
+			s = query.Get(p.Name)
+		default:
+			continue
+		}
+		if s == "" {
+			if p.Required {
+				v = append(v, fieldError(p.Name, expense.CodeRequired, "should not empty"))
+			}
+			continue
+		}
+		d.param(s, d.resolve(p.Schema), p.Name, &v)
+	}
+	return v
+}
+
+func (d *Document) param(s string, sc *Schema, field string, v *expense.ValidationError) {
+	if sc == nil {
+		return
+	}
+	switch sc.Type {
+	case "integer":
+		if _, err := strconv.Atoi(s); err != nil {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a number"))
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a number"))
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(s); err != nil {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be true or false"))
+		}
+	case "string":
+		d.str(s, sc, field, v)
+	}
+}
+
+// ValidateBody checks a JSON request body against the schema of op. A body
+// that isn't JSON is left to the handler to refuse.
+func (d *Document) ValidateBody(op *Operation, contentType string, body []byte) expense.ValidationError {
+	v := expense.ValidationError{}
+	if op.RequestBody == nil || !strings.HasPrefix(contentType, "application/json") {
+		return v
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			v = append(v, fieldError("body", expense.CodeRequired, "should not empty"))
+		}
+		return v
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return v
+	}
+	d.value(value, op.RequestBody.Content["application/json"].Schema, "", &v)
+	return v
+}
+
+func (d *Document) value(value interface{}, sc *Schema, field string, v *expense.ValidationError) {
+	sc = d.resolve(sc)
+	if sc == nil || value == nil {
+		return
+	}
+
+	switch sc.Type {
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a whole number"))
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a number"))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be true or false"))
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a text"))
+			return
+		}
+		d.str(s, sc, field, v)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be a list"))
+			return
+		}
+		for i, item := range items {
+			d.value(item, sc.Items, fmt.Sprintf("%s[%d]", field, i), v)
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			*v = append(*v, fieldError(field, expense.CodeType, "should be an object"))
+			return
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				*v = append(*v, fieldError(join(field, name), expense.CodeRequired, "should not empty"))
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := sc.Properties[name]; ok {
+				d.value(obj[name], p, join(field, name), v)
+			} else if sc.AdditionalProperties != nil {
+				d.value(obj[name], sc.AdditionalProperties, join(field, name), v)
+			}
+		}
+	}
+}
+
+// str checks the enum and format of a string. An empty string is left to
+// the handler, which reads it as not set.
+func (d *Document) str(s string, sc *Schema, field string, v *expense.ValidationError) {
+	if s == "" {
+		return
+	}
+	if len(sc.Enum) > 0 && !contains(sc.Enum, s) {
+		*v = append(*v, fieldError(field, expense.CodeEnum, "should be one of "+oneOf(sc.Enum)))
+	}
+	switch sc.Format {
+	case "date":
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			*v = append(*v, fieldError(field, expense.CodeFormat, "should be a date formatted as 2006-01-02"))
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			*v = append(*v, fieldError(field, expense.CodeFormat, "should be an RFC 3339 time"))
+		}
+	}
+}
+
+func fieldError(field, code, should string) expense.FieldError {
+	return expense.FieldError{Field: field, Code: code, Message: field + " error : this field " + should + "."}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// oneOf lists values as "a, b or c".
+func oneOf(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
@@ -0,0 +1,93 @@
+//go:build unit
+
+package openapi
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSpec(t *testing.T) *Document {
+	doc, err := Spec()
+	if err != nil {
+		t.Fatal("unable to parse the openapi document", err)
+	}
+	return doc
+}
+
+func TestValidateParams(t *testing.T) {
+	doc := testSpec(t)
+	op, ok := doc.Operation("GET", "/reports/:id/statement")
+	assert.True(t, ok)
+	path := func(id string) func(string) string {
+		return func(string) string { return id }
+	}
+
+	t.Run("should pass valid parameters", func(t *testing.T) {
+		v := doc.ValidateParams(op, path("1"), url.Values{"format": {"pdf"}})
+
+		assert.Empty(t, v)
+	})
+
+	t.Run("should list every invalid parameter", func(t *testing.T) {
+		v := doc.ValidateParams(op, path("abc"), url.Values{"format": {"doc"}})
+
+		assert.Equal(t, expense.ValidationError{
+			{Field: "id", Code: expense.CodeType, Message: "id error : this field should be a number."},
+			{Field: "format", Code: expense.CodeEnum, Message: "format error : this field should be one of html or pdf."},
+		}, v)
+	})
+
+	t.Run("should refuse a missing required parameter", func(t *testing.T) {
+		op, _ := doc.Operation("POST", "/expenses/:id/revert")
+
+		v := doc.ValidateParams(op, path("1"), url.Values{})
+
+		assert.Equal(t, expense.ValidationError{
+			{Field: "to", Code: expense.CodeRequired, Message: "to error : this field should not empty."},
+		}, v)
+	})
+}
+
+func TestValidateBody(t *testing.T) {
+	doc := testSpec(t)
+	op, _ := doc.Operation("POST", "/expenses")
+
+	t.Run("should pass a valid expense", func(t *testing.T) {
+		v := doc.ValidateBody(op, "application/json", []byte(`{"title":"taxi","amount":250,"tags":["travel"],"date":"2023-01-31","metadata":{"project":"x"},"participants":[{"name":"bob","value":1}]}`))
+
+		assert.Empty(t, v)
+	})
+
+	t.Run("should list every field of the wrong shape", func(t *testing.T) {
+		v := doc.ValidateBody(op, "application/json", []byte(`{"title":1,"amount":"250","tags":"travel","date":"31/01/2023","participants":[{"name":"bob","value":"1"}],"note":null}`))
+
+		assert.Equal(t, expense.ValidationError{
+			{Field: "amount", Code: expense.CodeType, Message: "amount error : this field should be a number."},
+			{Field: "date", Code: expense.CodeFormat, Message: "date error : this field should be a date formatted as 2006-01-02."},
+			{Field: "participants[0].value", Code: expense.CodeType, Message: "participants[0].value error : this field should be a number."},
+			{Field: "tags", Code: expense.CodeType, Message: "tags error : this field should be a list."},
+			{Field: "title", Code: expense.CodeType, Message: "title error : this field should be a text."},
+		}, v)
+	})
+
+	t.Run("should refuse missing required fields", func(t *testing.T) {
+		v := doc.ValidateBody(op, "application/json", []byte(`{"amount":250}`))
+
+		assert.Equal(t, []string{"title", "tags"}, []string{v[0].Field, v[1].Field})
+		assert.Equal(t, expense.CodeRequired, v[0].Code)
+	})
+
+	t.Run("should leave bodies that aren't JSON to the handler", func(t *testing.T) {
+		assert.Empty(t, doc.ValidateBody(op, "application/json", []byte(`{"title":`)))
+		assert.Empty(t, doc.ValidateBody(op, "application/x-www-form-urlencoded", []byte(`title=1`)))
+	})
+}
+
+func TestTemplatePath(t *testing.T) {
+	assert.Equal(t, "/webhooks/{id}/deliveries/{delivery}/retry", TemplatePath("/webhooks/:id/deliveries/:delivery/retry"))
+	assert.Equal(t, "/expenses", TemplatePath("/expenses"))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/customMiddleware"
+	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/Suvisuttikasame/assessment/openapi"
+	"github.com/labstack/echo/v4"
+)
+
+// apiVersion is a version of the API mounted under its own prefix, so that
+// versions with different representations are served side by side.
+type apiVersion struct {
+	prefix   string
+	register func(g *routeGroup, idempotent echo.MiddlewareFunc)
+}
+
+// apiVersions are the versions served. A new version is a prefix and the
+// function mounting its handlers.
+var apiVersions = []apiVersion{
+	{prefix: "/v1", register: registerV1},
+}
+
+// legacyVersion is the version the routes without a prefix, from before
+// versioning, are aliases of until they are sunset.
+var legacyVersion = apiVersions[0]
+
+// routeGroup mounts routes under a prefix with middleware of its own. It
+// stands in for echo.Group, whose Use registers catch-all routes that would
+// answer 404 in place of 405 and show up in Echo.Routes.
+type routeGroup struct {
+	*echo.Group
+	middleware []echo.MiddlewareFunc
+}
+
+func newRouteGroup(e *echo.Echo, prefix string, m ...echo.MiddlewareFunc) *routeGroup {
+	return &routeGroup{Group: e.Group(prefix), middleware: m}
+}
+
+func (g *routeGroup) with(m []echo.MiddlewareFunc) []echo.MiddlewareFunc {
+	return append(append([]echo.MiddlewareFunc{}, g.middleware...), m...)
+}
+
+func (g *routeGroup) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.Group.GET(path, h, g.with(m)...)
+}
+
+func (g *routeGroup) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.Group.POST(path, h, g.with(m)...)
+}
+
+func (g *routeGroup) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.Group.PUT(path, h, g.with(m)...)
+}
+
+func (g *routeGroup) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return g.Group.DELETE(path, h, g.with(m)...)
+}
+
+// registerRoutes mounts every version of the API.
+func registerRoutes(e *echo.Echo, idempotent echo.MiddlewareFunc) {
+	for _, v := range apiVersions {
+		v.register(newRouteGroup(e, v.prefix), idempotent)
+	}
+}
+
+// registerLegacyRoutes mounts the routes without a version prefix,
+// answering with Deprecation and Sunset headers, and with 410 Gone from the
+// sunset on.
+func registerLegacyRoutes(e *echo.Echo, idempotent echo.MiddlewareFunc, sunset time.Time) {
+	legacyVersion.register(newRouteGroup(e, "", customMiddleware.Deprecated(sunset, legacyVersion.prefix)), idempotent)
+}
+
+// registerV1 mounts the handlers of version 1. Every route should be
+// described in openapi/openapi.json.
+func registerV1(g *routeGroup, idempotent echo.MiddlewareFunc) {
+	g.GET("/openapi.json", openapi.ServeSpec)
+	g.GET("/docs", openapi.ServeDocs)
+
+	g.POST("/expenses", expense.CreateExpenses, idempotent)
+	g.GET("/expenses", expense.GetExpenses)
+	g.GET("/expenses/summary", expense.GetSummary)
+	g.GET("/expenses/:id", expense.GetExpensesById)
+	g.PUT("/expenses/:id", expense.UpdateExpensesById)
+	g.DELETE("/expenses/:id", expense.DeleteExpensesById)
+	g.GET("/expenses/:id/revisions", expense.GetExpenseRevisions)
+	g.GET("/expenses/:id/revisions/:n", expense.GetExpenseRevision)
+	g.POST("/expenses/:id/revert", expense.RevertExpense)
+	g.POST("/expenses/:id/submit", expense.SubmitExpense)
+	g.POST("/expenses/:id/approve", expense.ApproveExpense)
+	g.POST("/expenses/:id/reject", expense.RejectExpense)
+	g.POST("/expenses/:id/reimburse", expense.ReimburseExpense)
+	g.GET("/expenses/:id/transitions", expense.GetExpenseTransitions)
+	g.GET("/expenses/:id/receipts", expense.GetReceipts)
+	g.POST("/expenses/:id/receipts", expense.UploadReceipt)
+	g.GET("/expenses/:id/receipts/:receiptId", expense.GetReceipt)
+	g.DELETE("/expenses/:id/receipts/:receiptId", expense.DeleteReceipt)
+	g.GET("/approvals", expense.GetApprovalQueue)
+	g.POST("/graphql", expense.GraphQL)
+
+	g.GET("/reports", expense.GetReports)
+	g.POST("/reports", expense.CreateReport)
+	g.GET("/reports/:id", expense.GetReportById)
+	g.PUT("/reports/:id", expense.UpdateReport)
+	g.DELETE("/reports/:id", expense.DeleteReport)
+	g.POST("/reports/:id/submit", expense.SubmitReport)
+	g.POST("/reports/:id/approve", expense.ApproveReport)
+	g.POST("/reports/:id/reject", expense.RejectReport)
+	g.GET("/reports/:id/statement", expense.GetReportStatement)
+
+	g.GET("/payment-batches", expense.GetPaymentBatches)
+	g.POST("/payment-batches", expense.CreatePaymentBatch)
+	g.GET("/payment-batches/:id", expense.GetPaymentBatchById)
+	g.DELETE("/payment-batches/:id", expense.DeletePaymentBatch)
+	g.GET("/payment-batches/:id/file", expense.GetPaymentBatchFile)
+	g.POST("/payment-batches/:id/paid", expense.PayPaymentBatch)
+
+	g.GET("/balances", expense.GetBalances)
+	g.GET("/settlements", expense.GetSettlements)
+	g.POST("/settlements", expense.CreateSettlement)
+
+	g.GET("/fields", expense.GetFields)
+	g.PUT("/fields/:name", expense.SaveField)
+	g.DELETE("/fields/:name", expense.DeleteField)
+
+	g.GET("/audit", expense.GetAudit)
+
+	g.GET("/webhooks", expense.GetWebhooks)
+	g.POST("/webhooks", expense.CreateWebhook)
+	g.GET("/webhooks/:id", expense.GetWebhookById)
+	g.DELETE("/webhooks/:id", expense.DeleteWebhook)
+	g.GET("/webhooks/:id/deliveries", expense.GetWebhookDeliveries)
+	g.POST("/webhooks/:id/deliveries/:delivery/retry", expense.RetryWebhookDelivery)
+
+	g.GET("/users", expense.GetUsers)
+	g.POST("/users", expense.CreateUser)
+	g.PUT("/users/:username", expense.UpdateUser)
+	g.GET("/users/:username/payee", expense.GetPayee)
+	g.PUT("/users/:username/payee", expense.UpdatePayee)
+	g.GET("/groups", expense.GetGroups)
+	g.POST("/groups", expense.CreateGroup)
+	g.GET("/groups/:id/members", expense.GetGroupMembers)
+	g.DELETE("/groups/:id/members/:username", expense.RemoveGroupMember)
+	g.POST("/groups/:id/invitations", expense.InviteGroupMember)
+	g.GET("/groups/:id/report", expense.GetGroupReport)
+	g.GET("/invitations", expense.GetInvitations)
+	g.POST("/invitations/:id/accept", expense.AcceptInvitation)
+	g.POST("/invitations/:id/decline", expense.DeclineInvitation)
+}
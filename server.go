@@ -2,57 +2,243 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/Suvisuttikasame/assessment/config"
 	"github.com/Suvisuttikasame/assessment/customMiddleware"
 	"github.com/Suvisuttikasame/assessment/expense"
+	"github.com/Suvisuttikasame/assessment/expensepb"
+	"github.com/Suvisuttikasame/assessment/openapi"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	fmt.Println("Initiating database ...")
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// maxRequestBody bounds every request body, the largest being a receipt of
+// up to 10 MB in its multipart form.
+const maxRequestBody = "11M"
+
+// runServe starts the HTTP and gRPC servers and stops them gracefully on
+// SIGINT or SIGTERM.
+func runServe(cl *cli, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg := cl.cfg
+	slog.Info("effective config", slog.Any("config", cfg))
+
+	slog.Info("initiating database")
 	//init db connection & create table
-	Port := os.Getenv("PORT")
-	Url := os.Getenv("DATABASE_URL")
-	expense.InitDb(Url)
-	fmt.Println("Successfully initiate database")
+	if err := connectDb(cfg.Database); err != nil {
+		return fmt.Errorf("can not connect to the database: %w", err)
+	}
+	if err := expense.CreateTables(expense.Db); err != nil {
+		return fmt.Errorf("can not create tables: %w", err)
+	}
+	if err := customMiddleware.CreateTables(expense.Db); err != nil {
+		return fmt.Errorf("can not create middleware tables: %w", err)
+	}
+	if err := expense.LoadFields(); err != nil {
+		return fmt.Errorf("can not load field definitions: %w", err)
+	}
+	slog.Info("successfully initiated database")
+	if path := cfg.Files.ValidationRules; path != "" {
+		if err := expense.LoadRules(path); err != nil {
+			return fmt.Errorf("can not load validation rules: %w", err)
+		}
+	}
+	if path := cfg.Files.BankLayouts; path != "" {
+		if err := expense.LoadBankLayouts(path); err != nil {
+			return fmt.Errorf("can not load bank layouts: %w", err)
+		}
+	}
+	if path := cfg.Files.PDFFont; path != "" {
+		if err := expense.LoadPDFFont(path); err != nil {
+			return fmt.Errorf("can not load the PDF font: %w", err)
+		}
+	}
+	if err := expense.CheckBankLayout(expense.DefaultBankLayout); err != nil {
+		return fmt.Errorf("can not write bank files, override the layout in files.bank_layouts: %w", err)
+	}
+	sinks, err := outboxSinks(cfg.Files.OutboxNDJSON)
+	if err != nil {
+		return err
+	}
 
 	e := echo.New()
-	e.Use(middleware.Recover())
-	e.Use(middleware.BasicAuth(customMiddleware.Authentication))
+	e.HideBanner, e.HidePort = true, true
+	e.Server.ReadTimeout = cfg.Server.ReadTimeout
+	e.Server.WriteTimeout = cfg.Server.WriteTimeout
+	e.Server.IdleTimeout = cfg.Server.IdleTimeout
+	e.HTTPErrorHandler = expense.HTTPErrorHandler
+	e.Use(middleware.RequestID())
+	e.Use(customMiddleware.RequestLogger(slog.Default()))
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{LogErrorFunc: customMiddleware.LogPanic}))
+	e.Use(middleware.BodyLimit(maxRequestBody))
+	limiter, guard := rateLimitStore(cfg.Auth)
+	e.Use(customMiddleware.RateLimit(limiter))
+	authenticator := customMiddleware.Authenticator(guard)
+	e.Use(middleware.BasicAuth(authenticator))
+	e.Use(customMiddleware.UserRateLimit(limiter))
+	doc, err := openapi.Spec()
+	if err != nil {
+		return fmt.Errorf("can not parse the openapi document: %w", err)
+	}
+	e.Use(customMiddleware.ValidateRequest(doc))
+
+	keys := idempotencyStore(cfg.Auth.IdempotencyStore)
+	idempotent := customMiddleware.Idempotency(keys, cfg.Auth.IdempotencyTTL)
 
-	e.POST("/expenses", expense.CreateExpenses)
-	e.GET("/expenses", expense.GetExpenses)
-	e.GET("/expenses/:id", expense.GetExpensesById)
-	e.PUT("/expenses/:id", expense.UpdateExpensesById)
+	registerRoutes(e, idempotent)
+	if cfg.Features.LegacyRoutes {
+		registerLegacyRoutes(e, idempotent, cfg.Server.LegacySunset.Time)
+	}
+
+	var grpcServer *grpc.Server
+	var grpcListener net.Listener
+	if cfg.Features.GRPC {
+		grpcServer = grpc.NewServer(
+			grpc.ChainUnaryInterceptor(
+				customMiddleware.UnaryRecover(),
+				customMiddleware.UnaryRateLimit(limiter),
+				customMiddleware.UnaryAuth(authenticator),
+				customMiddleware.UnaryUserRateLimit(limiter),
+			),
+			grpc.ChainStreamInterceptor(
+				customMiddleware.StreamRecover(),
+				customMiddleware.StreamRateLimit(limiter),
+				customMiddleware.StreamAuth(authenticator),
+				customMiddleware.StreamUserRateLimit(limiter),
+			),
+		)
+		expensepb.RegisterExpenseServiceServer(grpcServer, expense.ExpenseServer{})
+		grpcListener, err = net.Listen("tcp", ":"+strconv.Itoa(cfg.Server.GRPCPort))
+		if err != nil {
+			return fmt.Errorf("can not listen for grpc: %w", err)
+		}
+	}
 
-	// fmt.Println("Please use server.go for main file")
-	// fmt.Println("start at port:", os.Getenv("PORT"))
-	fmt.Println("server is running on port:", Port)
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	go expense.NewRelay(sinks...).Run(workerCtx)
+	if cfg.Features.Webhooks {
+		go expense.NewDispatcher().Run(workerCtx)
+	}
+	go expense.RefreshFields(workerCtx, time.Minute)
+	if s, ok := keys.(*customMiddleware.MemoryIdempotencyStore); ok {
+		go s.ExpireKeys(workerCtx, time.Minute)
+	}
+
+	slog.Info("server is running", slog.Int("port", cfg.Server.Port))
 	go func() {
-		fmt.Println(e.Start(":" + Port))
+		if err := e.Start(":" + strconv.Itoa(cfg.Server.Port)); err != nil && err != http.ErrServerClosed {
+			slog.Error("server stopped", slog.Any("error", err))
+		}
 	}()
 
+	if grpcServer != nil {
+		slog.Info("grpc server is running", slog.Int("port", cfg.Server.GRPCPort))
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				slog.Error("grpc server stopped", slog.Any("error", err))
+			}
+		}()
+	}
+
 	//create buffer to listen to os signal
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGINT, syscall.SIGTERM)
 	//wait til receive signal
 	<-gracefulStop
 	//define context
-	//clear all req & shut down server with in 10 sec
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	//clear all req & shut down server with in the shutdown timeout
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
-	fmt.Println("server is shutting down...")
+	slog.Info("server is shutting down")
+	stopWorkers()
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
 	if err := e.Shutdown(ctx); err != nil {
-		log.Fatal(err)
+		return err
 	}
-	fmt.Println("server is shut down gracefully")
+	slog.Info("server is shut down gracefully")
+	return nil
+}
 
+// stopGRPC lets the calls in progress finish, and ends those still running
+// when ctx is done.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// rateLimitStore picks where rate limits and failed logins are kept: in
+// process by default, or in Postgres when the store is "postgres" so that
+// they hold across replicas.
+func rateLimitStore(auth config.Auth) (customMiddleware.Limiter, customMiddleware.LoginGuard) {
+	lockout := customMiddleware.Lockout{MaxFailures: auth.LockoutMaxFailures, Base: auth.LockoutBase, Max: auth.LockoutMax}
+	if auth.RateLimitStore == "postgres" {
+		return customMiddleware.NewPostgresLimiter(expense.Db, auth.Rate, auth.Burst),
+			customMiddleware.NewPostgresGuard(expense.Db, lockout)
+	}
+	return customMiddleware.NewMemoryLimiter(auth.Rate, auth.Burst),
+		customMiddleware.NewMemoryGuard(lockout)
+}
+
+// idempotencyStore picks where responses to POST requests with an
+// Idempotency-Key are kept, in process by default or in Postgres when store
+// is "postgres".
+func idempotencyStore(store string) customMiddleware.IdempotencyStore {
+	if store == "postgres" {
+		return customMiddleware.NewPostgresIdempotencyStore(expense.Db)
+	}
+	return customMiddleware.NewMemoryIdempotencyStore()
+}
+
+// outboxSinks are the sinks the outbox is relayed to: webhooks, in-process
+// subscribers and, when ndjson is set, a file of one JSON event per line
+// ("-" for stdout).
+func outboxSinks(ndjson string) ([]expense.Sink, error) {
+	sinks := []expense.Sink{expense.WebhookSink{}, expense.Events}
+	switch ndjson {
+	case "":
+	case "-":
+		sinks = append(sinks, expense.NewNDJSONSink(os.Stdout))
+	default:
+		f, err := os.OpenFile(ndjson, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("can not open outbox file: %w", err)
+		}
+		sinks = append(sinks, expense.NewNDJSONSink(f))
+	}
+	return sinks, nil
 }
@@ -0,0 +1,77 @@
+//go:build unit
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Suvisuttikasame/assessment/openapi"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var noMiddleware = func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+
+func TestEveryRouteIsInTheSpec(t *testing.T) {
+	doc, err := openapi.Spec()
+	if err != nil {
+		t.Fatal("unable to parse the openapi document", err)
+	}
+	e := echo.New()
+	registerRoutes(e, noMiddleware)
+	registerLegacyRoutes(e, noMiddleware, time.Time{})
+
+	routes := map[string]bool{}
+	for _, r := range e.Routes() {
+		routes[r.Method+" "+openapi.TemplatePath(r.Path)] = true
+		_, ok := doc.Operation(r.Method, r.Path)
+		assert.True(t, ok, "%s %s has no operation in openapi/openapi.json", r.Method, r.Path)
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			m := strings.ToUpper(method)
+			assert.True(t, routes[m+" "+legacyVersion.prefix+path], "%s %s is in openapi/openapi.json but has no route", m, path)
+		}
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	e := echo.New()
+	registerRoutes(e, noMiddleware)
+	registerLegacyRoutes(e, noMiddleware, time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("should answer without deprecation under /v1", func(t *testing.T) {
+		rec := get("/v1/openapi.json")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Deprecation"))
+	})
+
+	t.Run("should answer the unprefixed alias with deprecation headers", func(t *testing.T) {
+		rec := get("/openapi.json")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+		assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+		assert.Equal(t, `</v1/openapi.json>; rel="successor-version"`, rec.Header().Get("Link"))
+	})
+
+	t.Run("should still tell a wrong method from a missing route", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPatch, "/v1/expenses", nil)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}